@@ -0,0 +1,669 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strings"
+)
+
+// apiTemplatePrefix is the route prefix the taxonomy paths of the OpenAPI document are written with. The document
+// served repeats them under the prefix of each configured taxonomy.
+const apiTemplatePrefix = "/transformers/locations"
+
+// apiHandler serves the OpenAPI document describing every route of the service.
+type apiHandler struct {
+	document *payload
+}
+
+func newAPIHandler(taxonomies []taxonomyConfig) (apiHandler, error) {
+	doc, err := apiDocument(taxonomies)
+	if err != nil {
+		return apiHandler{}, err
+	}
+	contents, err := json.Marshal(doc)
+	if err != nil {
+		return apiHandler{}, err
+	}
+	return apiHandler{document: newPayload("application/json", contents)}, nil
+}
+
+func (h *apiHandler) registerRoutes(m *mux.Router) {
+	m.HandleFunc("/__api", h.getAPI).Methods("GET")
+}
+
+func (h *apiHandler) getAPI(writer http.ResponseWriter, req *http.Request) {
+	h.document.write(writer, req)
+}
+
+// apiDocument is the OpenAPI document with the taxonomy paths repeated for each taxonomy.
+func apiDocument(taxonomies []taxonomyConfig) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPIDocument), &doc); err != nil {
+		return nil, err
+	}
+	paths := make(map[string]interface{})
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		if !strings.HasPrefix(path, apiTemplatePrefix) {
+			paths[path] = item
+			continue
+		}
+		for _, t := range taxonomies {
+			paths[t.routePrefix+strings.TrimPrefix(path, apiTemplatePrefix)] = item
+		}
+	}
+	doc["paths"] = paths
+	return doc, nil
+}
+
+const openAPIDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Locations Transformer",
+    "description": "Serves locations from TME as UPP concepts. Every taxonomy is served under its own route prefix, with the same endpoints as /transformers/locations. Errors are application/problem+json documents. Admin operations need an API key with the admin role. Read operations need one with the read role, or none at all when anonymous reads are allowed, and answer 401 otherwise. Clients making too many requests are answered with 429 and a Retry-After header.",
+    "version": "1.0.0"
+  },
+  "security": [{}, {"apiKey": []}, {"bearer": []}],
+  "paths": {
+    "/transformers/locations": {
+      "get": {
+        "summary": "List links to every location, or to the locations of a type",
+        "parameters": [
+          {"$ref": "#/components/parameters/type"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"},
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {
+            "description": "Links to the locations, or the locations as a GeoJSON FeatureCollection",
+            "content": {
+              "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/LocationLink"}}},
+              "application/geo+json": {"schema": {"$ref": "#/components/schemas/FeatureCollection"}}
+            }
+          },
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__count": {
+      "get": {
+        "summary": "Count the locations",
+        "parameters": [
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {"description": "The number of locations", "content": {"text/plain": {"schema": {"type": "string", "pattern": "^[0-9]+$"}}}},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__ids": {
+      "get": {
+        "summary": "List the uuids of every location, or of the locations of a type",
+        "parameters": [
+          {"$ref": "#/components/parameters/type"},
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {"description": "One {\"id\": uuid} object per line", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__dump": {
+      "get": {
+        "summary": "Dump every location, or the locations of a type",
+        "parameters": [
+          {"$ref": "#/components/parameters/type"},
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {"description": "One location per line in uuid order, each as in the Location schema", "content": {"text/plain": {"schema": {"type": "string"}}}},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__duplicates": {
+      "get": {
+        "summary": "List the locations several TME terms mapped to",
+        "responses": {
+          "200": {"description": "The duplicates of the latest load", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/DuplicateLocation"}}}}},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/transformers/locations/__enrichment-issues": {
+      "get": {
+        "summary": "List the supplementary data that could not be applied",
+        "responses": {
+          "200": {"description": "The issues of the latest load", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/EnrichmentIssue"}}}}},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/transformers/locations/__lookup": {
+      "get": {
+        "summary": "Find a location by one of its identifiers",
+        "parameters": [
+          {"name": "authority", "in": "query", "required": true, "schema": {"type": "string", "enum": ["TME", "UUID", "GeoNames", "Wikidata", "ISO3166"]}},
+          {"name": "identifierValue", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"},
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Location"},
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__deprecated": {
+      "get": {
+        "summary": "List the merged and retired locations",
+        "responses": {
+          "200": {"description": "The deprecated locations", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/DeprecatedLocation"}}}}},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/transformers/locations/near": {
+      "get": {
+        "summary": "List the locations nearest a point, nearest first",
+        "parameters": [
+          {"name": "lat", "in": "query", "required": true, "schema": {"type": "number", "minimum": -90, "maximum": 90}},
+          {"name": "lon", "in": "query", "required": true, "schema": {"type": "number", "minimum": -180, "maximum": 180}},
+          {"name": "radius", "in": "query", "required": true, "description": "In kilometres", "schema": {"type": "number", "exclusiveMinimum": true, "minimum": 0, "maximum": 20000}},
+          {"$ref": "#/components/parameters/limit"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The locations within the radius",
+            "content": {
+              "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/NearbyLocation"}}},
+              "application/geo+json": {"schema": {"$ref": "#/components/schemas/FeatureCollection"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/within": {
+      "get": {
+        "summary": "List the locations within a bounding box",
+        "parameters": [
+          {"name": "bbox", "in": "query", "required": true, "description": "west,south,east,north in degrees", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/limit"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Locations"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/search": {
+      "get": {
+        "summary": "Search locations by label in any language",
+        "parameters": [
+          {"name": "q", "in": "query", "required": true, "schema": {"type": "string", "minLength": 1}},
+          {"$ref": "#/components/parameters/type"},
+          {"$ref": "#/components/parameters/limit"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Locations"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__reload": {
+      "post": {
+        "summary": "Reload the locations from TME in the background",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "responses": {
+          "202": {"$ref": "#/components/responses/Message"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "409": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/transformers/locations/__force-apply": {
+      "post": {
+        "summary": "Apply the latest load although it was rejected",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Message"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "409": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/transformers/locations/{uuid}": {
+      "get": {
+        "summary": "Get a location",
+        "parameters": [
+          {"$ref": "#/components/parameters/uuid"},
+          {"$ref": "#/components/parameters/format"},
+          {"$ref": "#/components/parameters/lang"},
+          {"$ref": "#/components/parameters/ifNoneMatch"},
+          {"$ref": "#/components/parameters/ifModifiedSince"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Location"},
+          "301": {
+            "description": "The location was merged into the one in the Location header",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/DeprecatedLocation"}}}
+          },
+          "304": {"$ref": "#/components/responses/NotModified"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "503": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/__overrides": {
+      "get": {
+        "summary": "List the overrides",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "responses": {
+          "200": {"description": "The overrides", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/OverrideView"}}}}},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/__overrides/__audit": {
+      "get": {
+        "summary": "List every change made to the overrides",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "responses": {
+          "200": {"description": "The audit trail, oldest first", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/OverrideChange"}}}}},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/__overrides/{uuid}": {
+      "get": {
+        "summary": "Get the override of a location",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/uuid"}],
+        "responses": {
+          "200": {"description": "The override", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/OverrideView"}}}},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      },
+      "put": {
+        "summary": "Add or replace the override of a location",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/uuid"}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Override"}}}},
+        "responses": {
+          "200": {"description": "The override as saved", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/OverrideView"}}}},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "summary": "Remove the override of a location",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "parameters": [{"$ref": "#/components/parameters/uuid"}],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "required": ["reason"],
+                "properties": {"editor": {"type": "string"}, "reason": {"type": "string"}}
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Message"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/__api": {
+      "get": {
+        "summary": "Get this document",
+        "responses": {
+          "200": {"description": "The OpenAPI document", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/__config": {
+      "get": {
+        "summary": "Show the options in use and where each came from, with secrets redacted",
+        "security": [{"apiKey": []}, {"bearer": []}],
+        "responses": {
+          "200": {"description": "The effective configuration", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Config"}}}},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/TooManyRequests"}
+        }
+      }
+    },
+    "/__ready": {
+      "get": {
+        "summary": "Tell load balancers whether to route requests to the instance",
+        "responses": {
+          "200": {"description": "Ready", "content": {"text/plain": {"schema": {"type": "string", "enum": ["OK"]}}}},
+          "503": {"description": "Not ready, as the first loads are running, no locations are loaded or the instance is shutting down", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      }
+    },
+    "/metrics": {
+      "get": {
+        "summary": "Get the metrics in the Prometheus text format",
+        "responses": {
+          "200": {"description": "The metrics", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "apiKey", "in": "header", "name": "X-Api-Key", "description": "An API key from API_KEYS, or the ADMIN_TOKEN"},
+      "bearer": {"type": "http", "scheme": "bearer", "description": "An API key from API_KEYS, or the ADMIN_TOKEN, as a bearer token"}
+    },
+    "parameters": {
+      "uuid": {"name": "uuid", "in": "path", "required": true, "schema": {"type": "string", "format": "uuid"}},
+      "type": {"name": "type", "in": "query", "description": "Only locations of this type, such as Country or City", "schema": {"type": "string"}},
+      "limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+      "format": {"name": "format", "in": "query", "description": "geojson for GeoJSON, which can also be asked for with Accept: application/geo+json", "schema": {"type": "string", "enum": ["geojson"]}},
+      "lang": {"name": "lang", "in": "query", "description": "Comma separated language tags choosing the prefLabel, taking precedence over Accept-Language", "schema": {"type": "string"}},
+      "ifNoneMatch": {"name": "If-None-Match", "in": "header", "schema": {"type": "string"}},
+      "ifModifiedSince": {"name": "If-Modified-Since", "in": "header", "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Location": {
+        "description": "The location, as JSON or as a GeoJSON Feature",
+        "content": {
+          "application/json": {"schema": {"$ref": "#/components/schemas/Location"}},
+          "application/geo+json": {"schema": {"$ref": "#/components/schemas/Feature"}}
+        }
+      },
+      "Locations": {
+        "description": "The locations, as JSON or as a GeoJSON FeatureCollection",
+        "content": {
+          "application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Location"}}},
+          "application/geo+json": {"schema": {"$ref": "#/components/schemas/FeatureCollection"}}
+        }
+      },
+      "NotModified": {"description": "The client already has the current version"},
+      "Message": {"description": "The request was accepted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Message"}}}},
+      "Problem": {"description": "An error, told apart by its type", "content": {"application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}}}},
+      "TooManyRequests": {
+        "description": "The client made too many requests, or too many such requests are being served",
+        "headers": {"Retry-After": {"description": "Seconds to wait before retrying", "schema": {"type": "integer"}}},
+        "content": {"application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}}}
+      }
+    },
+    "schemas": {
+      "LocationLink": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["apiUrl"],
+        "properties": {"apiUrl": {"type": "string"}}
+      },
+      "Location": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["uuid", "prefLabel", "type"],
+        "properties": {
+          "uuid": {"type": "string", "format": "uuid"},
+          "alternativeIdentifiers": {"$ref": "#/components/schemas/AlternativeIdentifiers"},
+          "prefLabel": {"type": "string"},
+          "prefLabels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "altLabels": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}},
+          "type": {"type": "string"},
+          "types": {"type": "array", "items": {"type": "string"}},
+          "broaderUUIDs": {"type": "array", "items": {"type": "string", "format": "uuid"}},
+          "coordinates": {"$ref": "#/components/schemas/Coordinates"},
+          "boundingBox": {"$ref": "#/components/schemas/BoundingBox"}
+        }
+      },
+      "NearbyLocation": {
+        "allOf": [
+          {"$ref": "#/components/schemas/Location"},
+          {"type": "object", "required": ["distanceKm"], "properties": {"distanceKm": {"type": "number"}}}
+        ]
+      },
+      "AlternativeIdentifiers": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "TME": {"type": "array", "items": {"type": "string"}},
+          "uuids": {"type": "array", "items": {"type": "string", "format": "uuid"}},
+          "geonames": {"type": "array", "items": {"type": "string"}},
+          "wikidata": {"type": "array", "items": {"type": "string"}},
+          "iso3166": {"type": "array", "items": {"type": "string"}}
+        }
+      },
+      "Coordinates": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["latitude", "longitude"],
+        "properties": {"latitude": {"type": "number"}, "longitude": {"type": "number"}}
+      },
+      "BoundingBox": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["south", "west", "north", "east"],
+        "properties": {"south": {"type": "number"}, "west": {"type": "number"}, "north": {"type": "number"}, "east": {"type": "number"}}
+      },
+      "Feature": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["type", "id", "geometry", "properties"],
+        "properties": {
+          "type": {"type": "string", "enum": ["Feature"]},
+          "id": {"type": "string", "format": "uuid"},
+          "bbox": {"type": "array", "items": {"type": "number"}},
+          "geometry": {
+            "type": "object",
+            "nullable": true,
+            "additionalProperties": false,
+            "required": ["type", "coordinates"],
+            "properties": {"type": {"type": "string", "enum": ["Point", "Polygon"]}, "coordinates": {"type": "array"}}
+          },
+          "properties": {
+            "type": "object",
+            "additionalProperties": false,
+            "required": ["uuid", "prefLabel", "type"],
+            "properties": {
+              "uuid": {"type": "string", "format": "uuid"},
+              "prefLabel": {"type": "string"},
+              "type": {"type": "string"},
+              "broader": {"type": "array", "items": {"type": "string"}},
+              "distanceKm": {"type": "number"}
+            }
+          }
+        }
+      },
+      "FeatureCollection": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["type", "features"],
+        "properties": {
+          "type": {"type": "string", "enum": ["FeatureCollection"]},
+          "features": {"type": "array", "items": {"$ref": "#/components/schemas/Feature"}}
+        }
+      },
+      "DuplicateLocation": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["uuid", "tmeIdentifiers", "kept"],
+        "properties": {
+          "uuid": {"type": "string", "format": "uuid"},
+          "tmeIdentifiers": {"type": "array", "items": {"type": "string"}},
+          "kept": {"type": "string"}
+        }
+      },
+      "EnrichmentIssue": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["source", "key", "issue"],
+        "properties": {"source": {"type": "string"}, "key": {"type": "string"}, "issue": {"type": "string"}}
+      },
+      "DeprecatedLocation": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["uuid", "status", "reason", "since"],
+        "properties": {
+          "uuid": {"type": "string", "format": "uuid"},
+          "prefLabel": {"type": "string"},
+          "tmeIdentifier": {"type": "string"},
+          "status": {"type": "string", "enum": ["merged", "retired"]},
+          "mergedInto": {"type": "string", "format": "uuid"},
+          "reason": {"type": "string"},
+          "since": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Override": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["author", "reason"],
+        "properties": {
+          "uuid": {"type": "string", "format": "uuid", "readOnly": true},
+          "prefLabel": {"type": "string"},
+          "prefLabels": {"type": "object", "additionalProperties": {"type": "string"}},
+          "altLabels": {"type": "object", "additionalProperties": {"type": "array", "items": {"type": "string"}}},
+          "type": {"type": "string"},
+          "coordinates": {"$ref": "#/components/schemas/Coordinates"},
+          "author": {"type": "string", "readOnly": true, "description": "The name of the API key that set the override"},
+          "editor": {"type": "string", "description": "Who made the change, as the client says"},
+          "reason": {"type": "string"},
+          "updatedAt": {"type": "string", "format": "date-time", "readOnly": true}
+        }
+      },
+      "OverrideView": {
+        "allOf": [
+          {"$ref": "#/components/schemas/Override"},
+          {
+            "type": "object",
+            "properties": {
+              "status": {
+                "type": "object",
+                "additionalProperties": false,
+                "required": ["taxonomy", "matchesSource", "checkedAt"],
+                "properties": {
+                  "taxonomy": {"type": "string"},
+                  "matchesSource": {"type": "boolean"},
+                  "checkedAt": {"type": "string", "format": "date-time"}
+                }
+              }
+            }
+          }
+        ]
+      },
+      "OverrideChange": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["uuid", "action", "author", "reason", "at"],
+        "properties": {
+          "uuid": {"type": "string", "format": "uuid"},
+          "action": {"type": "string", "enum": ["set", "remove"]},
+          "author": {"type": "string", "description": "The name of the API key that made the change"},
+          "editor": {"type": "string"},
+          "reason": {"type": "string"},
+          "at": {"type": "string", "format": "date-time"},
+          "override": {"$ref": "#/components/schemas/Override"}
+        }
+      },
+      "Config": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["settings"],
+        "properties": {
+          "configFile": {"type": "string"},
+          "settings": {
+            "type": "object",
+            "additionalProperties": {
+              "type": "object",
+              "additionalProperties": false,
+              "required": ["value", "source", "reloadable"],
+              "properties": {
+                "value": {"nullable": true, "description": "The value in use, of the type of the option, with secrets redacted"},
+                "source": {"type": "string"},
+                "reloadable": {"type": "boolean"}
+              }
+            }
+          }
+        }
+      },
+      "Message": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["message"],
+        "properties": {"message": {"type": "string"}}
+      },
+      "Problem": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["type", "title", "status"],
+        "properties": {
+          "type": {"type": "string", "format": "uri"},
+          "title": {"type": "string"},
+          "status": {"type": "integer"},
+          "detail": {"type": "string"},
+          "instance": {"type": "string"},
+          "transactionId": {"type": "string"},
+          "deprecation": {"$ref": "#/components/schemas/DeprecatedLocation"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Financial-Times/service-status-go/gtg"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var routeVariablePattern = regexp.MustCompile(`\{(\w+):[^/]+\}`)
+
+// apiRouter registers every route the service registers on its mux router.
+func apiRouter(t *testing.T, s locationService) *mux.Router {
+	m := mux.NewRouter()
+	h := newLocationsHandler(s, testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
+	store, err := newOverrideStore("")
+	assert.NoError(t, err)
+	oh := newOverridesHandler(store, []locationService{s}, testAuth, testLimits)
+	oh.registerRoutes(m)
+	ah, err := newAPIHandler([]taxonomyConfig{testTaxonomy})
+	assert.NoError(t, err)
+	ah.registerRoutes(m)
+	cfg, _ := testSettings(t, "", "")
+	registerInstanceRoutes(m, cfg, testAuth, testLimits, allGoodToGo([]gtg.StatusChecker{h.G2GCheck}))
+	return m
+}
+
+func testAPIDocument(t *testing.T) openAPI {
+	doc, err := apiDocument([]taxonomyConfig{testTaxonomy})
+	assert.NoError(t, err)
+	return openAPI(doc)
+}
+
+// routes lists the registered routes as "METHOD /path/{variable}".
+func routes(t *testing.T, m *mux.Router) []string {
+	var registered []string
+	err := m.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			registered = append(registered, method+" "+routeVariablePattern.ReplaceAllString(template, "{$1}"))
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	sort.Strings(registered)
+	return registered
+}
+
+func TestAPIDocumentsEveryRoute(t *testing.T) {
+	doc := testAPIDocument(t)
+	registered := routes(t, apiRouter(t, &dummyService{}))
+	assert.NotEmpty(t, registered)
+	assert.Equal(t, registered, doc.operations(), "Routes and documented operations differ")
+}
+
+func TestAPIDocumentRepeatsTaxonomyPaths(t *testing.T) {
+	regions := taxonomyConfig{name: "ON", locationType: "Region", routePrefix: "/transformers/regions"}
+	doc, err := apiDocument([]taxonomyConfig{testTaxonomy, regions})
+	assert.NoError(t, err)
+	paths := doc["paths"].(map[string]interface{})
+	assert.Contains(t, paths, "/transformers/locations/{uuid}")
+	assert.Contains(t, paths, "/transformers/regions/{uuid}")
+	assert.Contains(t, paths, "/transformers/regions/__count")
+	assert.Contains(t, paths, "/__overrides")
+	assert.NotContains(t, paths, "/transformers/regions/__overrides")
+}
+
+func TestAPIResponsesMatchDocument(t *testing.T) {
+	doc := testAPIDocument(t)
+	loaded := &dummyService{found: true, locations: []location{paris, london}, duplicates: []duplicateLocation{{UUID: testUUID, TMEIdentifiers: []string{"MTE3-R0w=", "MTI3-R0w="}, Kept: "MTE3-R0w="}}, issues: []enrichmentIssue{{Source: "concordance", Key: "MTE3-R0w=", Issue: "No location for GeoNames 2988507"}}, version: contentVersion{Hash: "abc", Modified: since}}
+	localised := &dummyService{found: true, locations: []location{munich}}
+	missing := &dummyService{found: false, locations: []location{{}}, deprecated: []deprecatedLocation{{UUID: london.UUID, Status: mergedStatus, MergedInto: testUUID, Reason: "Listed in the merges file", Since: since}, {UUID: testUUID, PrefLabel: "Atlantis", Status: retiredStatus, Reason: "No longer in TME", Since: since}}}
+	loading := &dummyService{found: false, dataLoaded: LoadingData}
+	rejected := &dummyService{found: true, locations: []location{paris}, dataLoaded: RejectedData}
+
+	override := `{"prefLabel":"Paris, France","prefLabels":{"fr":"Paris"},"coordinates":{"latitude":48.8566,"longitude":2.3522},"editor":"jane.doe","reason":"Ticket 42"}`
+	removal := `{"editor":"jane.doe","reason":"Fixed in TME"}`
+
+	tests := []struct {
+		name    string
+		service locationService
+		req     *http.Request
+		status  int
+	}{
+		{"List", loaded, newRequest("GET", "/transformers/locations"), http.StatusOK},
+		{"List as GeoJSON", loaded, newGeoJSONRequest("GET", "/transformers/locations"), http.StatusOK},
+		{"List not modified", loaded, withHeader(newRequest("GET", "/transformers/locations"), "If-Modified-Since", "Thu, 20 Oct 2016 10:00:00 GMT"), http.StatusNotModified},
+		{"List nothing", &dummyService{found: false}, newRequest("GET", "/transformers/locations"), http.StatusNotFound},
+		{"List not loaded", loading, newRequest("GET", "/transformers/locations"), http.StatusServiceUnavailable},
+		{"Count", loaded, newRequest("GET", "/transformers/locations/__count"), http.StatusOK},
+		{"Ids", loaded, newRequest("GET", "/transformers/locations/__ids"), http.StatusOK},
+		{"Dump", loaded, newRequest("GET", "/transformers/locations/__dump"), http.StatusOK},
+		{"Duplicates", loaded, newRequest("GET", "/transformers/locations/__duplicates"), http.StatusOK},
+		{"Enrichment issues", loaded, newRequest("GET", "/transformers/locations/__enrichment-issues"), http.StatusOK},
+		{"Deprecated", missing, newRequest("GET", "/transformers/locations/__deprecated"), http.StatusOK},
+		{"Lookup", &dummyService{found: true, locations: []location{{UUID: testUUID, AlternativeIdentifiers: alternativeIdentifiers{GeoNames: []string{"2988507"}}}}}, newRequest("GET", "/transformers/locations/__lookup?authority=GeoNames&identifierValue=2988507"), http.StatusOK},
+		{"Lookup nothing", loaded, newRequest("GET", "/transformers/locations/__lookup?authority=GeoNames&identifierValue=1"), http.StatusNotFound},
+		{"Near", loaded, newRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=10"), http.StatusOK},
+		{"Near as GeoJSON", loaded, newGeoJSONRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=10"), http.StatusOK},
+		{"Near with bad radius", loaded, newRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=0"), http.StatusBadRequest},
+		{"Within", loaded, newRequest("GET", "/transformers/locations/within?bbox=-1,50,1,52"), http.StatusOK},
+		{"Within as GeoJSON", loaded, newGeoJSONRequest("GET", "/transformers/locations/within?bbox=-1,50,1,52"), http.StatusOK},
+		{"Search", localised, newLanguageRequest("GET", "/transformers/locations/search?q=muni", "de"), http.StatusOK},
+		{"Search without query", loaded, newRequest("GET", "/transformers/locations/search"), http.StatusBadRequest},
+		{"Search not loaded", loading, newRequest("GET", "/transformers/locations/search?q=paris"), http.StatusServiceUnavailable},
+		{"Location", localised, newRequest("GET", "/transformers/locations/"+testUUID+"?lang=fr"), http.StatusOK},
+		{"Location as GeoJSON", loaded, newGeoJSONRequest("GET", "/transformers/locations/"+testUUID), http.StatusOK},
+		{"Location not modified", loaded, withHeader(newRequest("GET", "/transformers/locations/"+testUUID), "If-None-Match", "*"), http.StatusNotModified},
+		{"Merged location", missing, newRequest("GET", "/transformers/locations/"+london.UUID), http.StatusMovedPermanently},
+		{"Retired location", missing, newRequest("GET", "/transformers/locations/"+testUUID), http.StatusGone},
+		{"Unknown location", &dummyService{found: false, locations: []location{{}}}, newRequest("GET", "/transformers/locations/"+testUUID), http.StatusNotFound},
+		{"Reload", loaded, newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), http.StatusAccepted},
+		{"Reload while loading", loading, newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), http.StatusConflict},
+		{"Reload unauthorised", loaded, newRequest("POST", "/transformers/locations/__reload"), http.StatusUnauthorized},
+		{"Reload with a read key", loaded, newAdminRequest("POST", "/transformers/locations/__reload", testReadKey), http.StatusForbidden},
+		{"Reload before starting", &dummyService{dataLoaded: NotInit}, newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), http.StatusServiceUnavailable},
+		{"Force apply", rejected, newAdminRequest("POST", "/transformers/locations/__force-apply", testAdminToken), http.StatusOK},
+		{"Force apply nothing", loaded, newAdminRequest("POST", "/transformers/locations/__force-apply", testAdminToken), http.StatusConflict},
+		{"Force apply unauthorised", rejected, newRequest("POST", "/transformers/locations/__force-apply"), http.StatusUnauthorized},
+		{"Overrides", loaded, newAdminRequest("GET", "/__overrides", testAdminToken), http.StatusOK},
+		{"Overrides anonymously", loaded, newRequest("GET", "/__overrides"), http.StatusUnauthorized},
+		{"Override audit", loaded, newAdminRequest("GET", "/__overrides/__audit", testAdminToken), http.StatusOK},
+		{"Override audit anonymously", loaded, newRequest("GET", "/__overrides/__audit"), http.StatusUnauthorized},
+		{"Override audit with a read key", loaded, newAdminRequest("GET", "/__overrides/__audit", testReadKey), http.StatusForbidden},
+		{"Override", loaded, newAdminRequest("GET", "/__overrides/"+testUUID, testAdminToken), http.StatusNotFound},
+		{"Override with a read key", loaded, newAdminRequest("GET", "/__overrides/"+testUUID, testReadKey), http.StatusForbidden},
+		{"Set override", loaded, withBody(newAdminRequest("PUT", "/__overrides/"+testUUID, testAdminToken), override), http.StatusOK},
+		{"Set invalid override", loaded, withBody(newAdminRequest("PUT", "/__overrides/"+testUUID, testAdminToken), `{"editor":"jane.doe"}`), http.StatusBadRequest},
+		{"Set override unauthorised", loaded, withBody(newRequest("PUT", "/__overrides/"+testUUID), override), http.StatusUnauthorized},
+		{"Remove override", loaded, withBody(newAdminRequest("DELETE", "/__overrides/"+testUUID, testAdminToken), removal), http.StatusNotFound},
+		{"Remove override without reason", loaded, withBody(newAdminRequest("DELETE", "/__overrides/"+testUUID, testAdminToken), `{"editor":"jane.doe"}`), http.StatusBadRequest},
+		{"Remove override unauthorised", loaded, withBody(newRequest("DELETE", "/__overrides/"+testUUID), removal), http.StatusUnauthorized},
+		{"API", loaded, newRequest("GET", "/__api"), http.StatusOK},
+		{"Config", loaded, newAdminRequest("GET", "/__config", testAdminToken), http.StatusOK},
+		{"Config anonymously", loaded, newRequest("GET", "/__config"), http.StatusUnauthorized},
+		{"Config with a read key", loaded, newAdminRequest("GET", "/__config", testReadKey), http.StatusForbidden},
+		{"Ready", loaded, newRequest("GET", "/__ready"), http.StatusOK},
+		{"Not ready", loading, newRequest("GET", "/__ready"), http.StatusServiceUnavailable},
+		{"Metrics", loaded, newRequest("GET", "/metrics"), http.StatusOK},
+	}
+
+	exercised := make(map[string]bool)
+	for _, test := range tests {
+		m := apiRouter(t, test.service)
+		var match mux.RouteMatch
+		if !assert.True(t, m.Match(test.req, &match), test.name) {
+			continue
+		}
+		template, _ := match.Route.GetPathTemplate()
+		route := test.req.Method + " " + routeVariablePattern.ReplaceAllString(template, "{$1}")
+		exercised[route] = true
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, test.req)
+		assert.Equal(t, test.status, rec.Code, test.name)
+		for _, err := range doc.checkResponse(route, rec) {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+
+	// Overrides set by one request are seen by the next only through a shared store, so check them in sequence.
+	m := apiRouter(t, loaded)
+	for _, req := range []*http.Request{
+		withBody(newAdminRequest("PUT", "/__overrides/"+testUUID, testAdminToken), override),
+		newAdminRequest("GET", "/__overrides/"+testUUID, testAdminToken),
+		newAdminRequest("GET", "/__overrides", testAdminToken),
+		newAdminRequest("GET", "/__overrides/__audit", testAdminToken),
+		withBody(newAdminRequest("DELETE", "/__overrides/"+testUUID, testAdminToken), removal),
+		newAdminRequest("GET", "/__overrides/__audit", testAdminToken),
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		assert.True(t, rec.Code < 300, "%s %s: %d", req.Method, req.URL.Path, rec.Code)
+		var match mux.RouteMatch
+		m.Match(req, &match)
+		template, _ := match.Route.GetPathTemplate()
+		for _, err := range doc.checkResponse(req.Method+" "+routeVariablePattern.ReplaceAllString(template, "{$1}"), rec) {
+			t.Errorf("%s %s: %s", req.Method, req.URL.Path, err)
+		}
+	}
+
+	for _, route := range routes(t, m) {
+		assert.True(t, exercised[route], "No request checks the responses of %s", route)
+	}
+}
+
+func withHeader(req *http.Request, name string, value string) *http.Request {
+	req.Header.Set(name, value)
+	return req
+}
+
+func withBody(req *http.Request, body string) *http.Request {
+	r, err := http.NewRequest(req.Method, req.URL.String(), strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	r.Header = req.Header
+	return r
+}
+
+// openAPI reads just enough of an OpenAPI 3 document to check responses against it.
+type openAPI map[string]interface{}
+
+// operations lists the documented operations as "METHOD /path/{variable}".
+func (doc openAPI) operations() []string {
+	var operations []string
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			operations = append(operations, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// checkResponse checks a response has a documented status and content type, and a body matching the documented schema.
+func (doc openAPI) checkResponse(route string, rec *httptest.ResponseRecorder) []string {
+	parts := strings.SplitN(route, " ", 2)
+	op, found := doc.object("paths", parts[1], strings.ToLower(parts[0]))
+	if !found {
+		return []string{fmt.Sprintf("%s is not documented", route)}
+	}
+	responses := op["responses"].(map[string]interface{})
+	response, found := responses[fmt.Sprint(rec.Code)].(map[string]interface{})
+	if !found {
+		return []string{fmt.Sprintf("%s does not document status %d", route, rec.Code)}
+	}
+	response = doc.resolve(response)
+	content, _ := response["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if rec.Body.Len() > 0 {
+			return []string{fmt.Sprintf("%s %d documents no body but has one", route, rec.Code)}
+		}
+		return nil
+	}
+	contentType, _, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		return []string{fmt.Sprintf("%s %d has no content type", route, rec.Code)}
+	}
+	media, found := content[contentType].(map[string]interface{})
+	if !found {
+		return []string{fmt.Sprintf("%s %d does not document content type %s", route, rec.Code, contentType)}
+	}
+	schema := media["schema"].(map[string]interface{})
+	if !strings.HasSuffix(contentType, "json") {
+		return doc.validate("body", schema, rec.Body.String())
+	}
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		return []string{fmt.Sprintf("%s %d body is not JSON: %v", route, rec.Code, err)}
+	}
+	return doc.validate("body", schema, body)
+}
+
+func (doc openAPI) object(keys ...string) (map[string]interface{}, bool) {
+	var current interface{} = map[string]interface{}(doc)
+	for _, key := range keys {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	m, ok := current.(map[string]interface{})
+	return m, ok
+}
+
+// resolve follows references, and merges the schemas of an allOf into one object schema.
+func (doc openAPI) resolve(schema map[string]interface{}) map[string]interface{} {
+	if ref, found := schema["$ref"].(string); found {
+		target, _ := doc.object(strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+		return doc.resolve(target)
+	}
+	allOf, found := schema["allOf"].([]interface{})
+	if !found {
+		return schema
+	}
+	merged := map[string]interface{}{"type": "object", "properties": map[string]interface{}{}, "required": []interface{}{}}
+	for _, s := range allOf {
+		part := doc.resolve(s.(map[string]interface{}))
+		for name, property := range part["properties"].(map[string]interface{}) {
+			merged["properties"].(map[string]interface{})[name] = property
+		}
+		if required, found := part["required"].([]interface{}); found {
+			merged["required"] = append(merged["required"].([]interface{}), required...)
+		}
+		if part["additionalProperties"] == false {
+			merged["additionalProperties"] = false
+		}
+	}
+	return merged
+}
+
+// validate checks a value against the parts of JSON schema the document uses.
+func (doc openAPI) validate(path string, schema map[string]interface{}, value interface{}) []string {
+	schema = doc.resolve(schema)
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s is null", path)}
+	}
+	if enum, found := schema["enum"].([]interface{}); found {
+		matched := false
+		for _, e := range enum {
+			matched = matched || e == value
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%s is %v, not one of %v", path, value, enum)}
+		}
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s is not an object", path)}
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, found := obj[name.(string)]; !found {
+				errs = append(errs, fmt.Sprintf("%s lacks %s", path, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, v := range obj {
+			if property, found := properties[name]; found {
+				errs = append(errs, doc.validate(path+"."+name, property.(map[string]interface{}), v)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s.%s is not documented", path, name))
+				}
+			case map[string]interface{}:
+				errs = append(errs, doc.validate(path+"."+name, additional, v)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s is not an array", path)}
+		}
+		if itemSchema, found := schema["items"].(map[string]interface{}); found {
+			for i, item := range items {
+				errs = append(errs, doc.validate(fmt.Sprintf("%s[%d]", path, i), itemSchema, item)...)
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s is not a string", path)}
+		}
+		if pattern, found := schema["pattern"].(string); found && !regexp.MustCompile(pattern).MatchString(s) {
+			errs = append(errs, fmt.Sprintf("%s does not match %s", path, pattern))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s is not a number", path)}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s is not an integer", path)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s is not a boolean", path)}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"strings"
+)
+
+// role is what a credential may do. Each role includes the ones before it.
+type role int
+
+const (
+	anonymousRole role = iota
+	readRole
+	adminRole
+)
+
+var roleNames = map[string]role{"read": readRole, "admin": adminRole}
+
+func (r role) String() string {
+	for name, named := range roleNames {
+		if named == r {
+			return name
+		}
+	}
+	return "anonymous"
+}
+
+// credential is an API key, presented as a bearer token or in the X-Api-Key header.
+type credential struct {
+	name string
+	role role
+	key  string
+}
+
+// authenticator checks requests carry a credential with the role a route requires. Read routes may be left open to
+// anonymous clients, but a credential that is presented must be valid.
+type authenticator struct {
+	credentials   []credential
+	anonymousRead bool
+}
+
+// newAuthenticator parses API keys of the form name:role:key, where role is read or admin. A non-empty admin token is
+// kept working as an admin key named admin-token. Errors tell the position of an invalid entry rather than echo it, as
+// a malformed entry may be nothing but the key, and the errors are logged.
+func newAuthenticator(apiKeys []string, adminToken string, anonymousRead bool) (*authenticator, error) {
+	a := &authenticator{anonymousRead: anonymousRead}
+	names := make(map[string]bool)
+	for i, entry := range apiKeys {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("Invalid API key %d of %d, expected name:role:key", i+1, len(apiKeys))
+		}
+		r, found := roleNames[parts[1]]
+		if !found {
+			return nil, fmt.Errorf("Invalid role for API key %d of %d, expected read or admin", i+1, len(apiKeys))
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("API key name %s is used twice", parts[0])
+		}
+		names[parts[0]] = true
+		a.credentials = append(a.credentials, credential{name: parts[0], role: r, key: parts[2]})
+	}
+	if adminToken != "" {
+		a.credentials = append(a.credentials, credential{name: "admin-token", role: adminRole, key: adminToken})
+	}
+	return a, nil
+}
+
+// identify finds the credential a request presents. It tells whether one was presented at all, so that an invalid key
+// is not mistaken for an anonymous request.
+func (a *authenticator) identify(req *http.Request) (credential, bool, bool) {
+	key := req.Header.Get("X-Api-Key")
+	if auth := req.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return credential{}, false, false
+	}
+	var matched credential
+	found := false
+	for _, c := range a.credentials {
+		if subtle.ConstantTimeCompare([]byte(c.key), []byte(key)) == 1 {
+			matched, found = c, true
+		}
+	}
+	return matched, found, true
+}
+
+// require lets requests through to next only when they carry a credential with at least the given role.
+func (a *authenticator) require(r role, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		c, found, presented := a.identify(req)
+		switch {
+		case presented && !found:
+			a.deny(writer, req, r, "", unauthorisedProblem, "The API key is not valid")
+		case !presented && (r > readRole || !a.anonymousRead):
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="locations-transformer"`)
+			a.deny(writer, req, r, "", unauthorisedProblem, fmt.Sprintf("An API key with the %s role is required", r))
+		case presented && c.role < r:
+			a.deny(writer, req, r, c.name, forbiddenProblem, fmt.Sprintf("The API key %s does not have the %s role", c.name, r))
+		default:
+			if r == adminRole {
+				log.WithFields(log.Fields{"event": "access_granted", "credential": c.name, "method": req.Method, "path": req.URL.Path}).Info("Admin request")
+			}
+			next(writer, req)
+		}
+	}
+}
+
+// deny logs a refused request for audit and answers it with a problem.
+func (a *authenticator) deny(writer http.ResponseWriter, req *http.Request, required role, name string, pt problemType, detail string) {
+	log.WithFields(log.Fields{
+		"event":         "access_denied",
+		"credential":    name,
+		"requiredRole":  required.String(),
+		"method":        req.Method,
+		"path":          req.URL.Path,
+		"remoteAddr":    req.RemoteAddr,
+		"transactionId": transactionID(writer, req),
+	}).Warn(detail)
+	metrics.GetOrRegisterCounter("auth.denied", metrics.DefaultRegistry).Inc(1)
+	writeProblem(writer, req, pt, detail)
+}
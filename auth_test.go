@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewAuthenticatorErrorsHideKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKeys []string
+		err     string
+	}{
+		{"Key alone", []string{"ingester:read:abc", "s3cr3t"}, "Invalid API key 2 of 2, expected name:role:key"},
+		{"Key without a role", []string{"ingester:s3cr3t"}, "Invalid API key 1 of 1, expected name:role:key"},
+		{"Key in place of the role", []string{"ingester:s3cr3t:read"}, "Invalid role for API key 1 of 1, expected read or admin"},
+	}
+	for _, test := range tests {
+		_, err := newAuthenticator(test.apiKeys, "", true)
+		assert.EqualError(t, err, test.err, test.name)
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	tests := []struct {
+		name        string
+		apiKeys     []string
+		adminToken  string
+		credentials []credential
+		valid       bool
+	}{
+		{"None", []string{}, "", nil, true},
+		{"Keys", []string{"ingester:read:abc", "ops:admin:d:e:f"}, "", []credential{{name: "ingester", role: readRole, key: "abc"}, {name: "ops", role: adminRole, key: "d:e:f"}}, true},
+		{"Admin token", []string{"ingester:read:abc"}, "secret", []credential{{name: "ingester", role: readRole, key: "abc"}, {name: "admin-token", role: adminRole, key: "secret"}}, true},
+		{"Unknown role", []string{"ingester:write:abc"}, "", nil, false},
+		{"Missing key", []string{"ingester:read:"}, "", nil, false},
+		{"Missing name", []string{":read:abc"}, "", nil, false},
+		{"Duplicate name", []string{"ingester:read:abc", "ingester:admin:def"}, "", nil, false},
+	}
+	for _, test := range tests {
+		a, err := newAuthenticator(test.apiKeys, test.adminToken, true)
+		assert.Equal(t, test.valid, err == nil, fmt.Sprintf("%s: Unexpected error %v", test.name, err))
+		if err == nil {
+			assert.Equal(t, test.credentials, a.credentials, test.name)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name          string
+		anonymousRead bool
+		required      role
+		headers       map[string]string
+		status        int
+	}{
+		{"Anonymous read", true, readRole, nil, http.StatusOK},
+		{"Anonymous read disabled", false, readRole, nil, http.StatusUnauthorized},
+		{"Read key", false, readRole, map[string]string{"X-Api-Key": testReadKey}, http.StatusOK},
+		{"Read key as bearer token", false, readRole, map[string]string{"Authorization": "Bearer " + testReadKey}, http.StatusOK},
+		{"Admin key reads", false, readRole, map[string]string{"X-Api-Key": testAdminToken}, http.StatusOK},
+		{"Invalid key on an open route", true, readRole, map[string]string{"X-Api-Key": "wrong"}, http.StatusUnauthorized},
+		{"Anonymous admin", true, adminRole, nil, http.StatusUnauthorized},
+		{"Read key on an admin route", true, adminRole, map[string]string{"X-Api-Key": testReadKey}, http.StatusForbidden},
+		{"Admin key", true, adminRole, map[string]string{"Authorization": "Bearer " + testAdminToken}, http.StatusOK},
+		{"Basic auth", true, adminRole, map[string]string{"Authorization": "Basic c2VjcmV0"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		a := &authenticator{credentials: testAuth.credentials, anonymousRead: test.anonymousRead}
+		handler := a.require(test.required, func(writer http.ResponseWriter, req *http.Request) {
+			writer.WriteHeader(http.StatusOK)
+		})
+		req := newRequest("POST", "/transformers/locations/__reload")
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, test.status, rec.Code, test.name)
+		if rec.Code != http.StatusOK {
+			assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"), test.name)
+		}
+	}
+}
+
+func TestDeniedRequestsAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.StandardLogger().Out
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+
+	handler := testAuth.require(adminRole, func(writer http.ResponseWriter, req *http.Request) {})
+	handler(httptest.NewRecorder(), newAdminRequest("PUT", "/__overrides/"+testUUID, testReadKey))
+
+	assert.Contains(t, buf.String(), "access_denied")
+	assert.Contains(t, buf.String(), "reader")
+	assert.Contains(t, buf.String(), "/__overrides/"+testUUID)
+	assert.NotContains(t, buf.String(), testReadKey, "Keys must never be logged")
+}
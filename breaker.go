@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Financial-Times/go-fthealth/v1a"
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+// The breaker state values are those of the tme.breaker.state gauge.
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+var errCircuitOpen = errors.New("Not calling TME as the circuit is open after repeated failures")
+
+// circuitBreaker stops calling TME after a number of calls in a row fail, each after the retries of the client. Once
+// open, it lets a single call through after a while to probe whether TME is back, closing on success. Each failed probe
+// doubles the time until the next, up to maxOpenFor.
+type circuitBreaker struct {
+	sync.Mutex
+	failureThreshold int
+	openFor          time.Duration
+	maxOpenFor       time.Duration
+	now              func() time.Time
+	state            breakerState
+	failures         int
+	backoff          time.Duration
+	retryAt          time.Time
+	lastError        error
+}
+
+// newCircuitBreaker makes a breaker opening after failureThreshold failed calls in a row. A zero threshold never opens.
+func newCircuitBreaker(failureThreshold int, openFor time.Duration, maxOpenFor time.Duration) *circuitBreaker {
+	b := &circuitBreaker{failureThreshold: failureThreshold, openFor: openFor, maxOpenFor: maxOpenFor, now: time.Now}
+	metrics.GetOrRegister("tme.breaker.state", metrics.NewFunctionalGauge(func() int64 { return int64(b.currentState()) }))
+	return b
+}
+
+// currentState tells the state of the breaker, an open one being half-open once it lets a probe through.
+func (b *circuitBreaker) currentState() breakerState {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == breakerOpen && !b.now().Before(b.retryAt) {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// isOpen tells whether calls to TME are currently refused, for scheduled reloads to be skipped.
+func (b *circuitBreaker) isOpen() bool {
+	return b.currentState() == breakerOpen
+}
+
+// allow tells whether a call may be made, moving an open breaker to half-open for a single probe once it is due.
+func (b *circuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if b.now().Before(b.retryAt) {
+			break
+		}
+		log.Infof("Probing TME after the circuit was open for %v", b.backoff)
+		b.state = breakerHalfOpen
+		return true
+	}
+	metrics.GetOrRegisterCounter("tme.breaker.rejected", metrics.DefaultRegistry).Inc(1)
+	return false
+}
+
+func (b *circuitBreaker) record(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if err == nil {
+		if b.state != breakerClosed {
+			log.WithField("event", "circuit_closed").Info("TME answered, closing the circuit")
+		}
+		b.state, b.failures, b.backoff, b.lastError = breakerClosed, 0, 0, nil
+		return
+	}
+	b.lastError = err
+	b.failures++
+	switch {
+	case b.state == breakerHalfOpen:
+		b.backoff *= 2
+		if b.backoff > b.maxOpenFor {
+			b.backoff = b.maxOpenFor
+		}
+	case b.failureThreshold > 0 && b.failures >= b.failureThreshold:
+		b.backoff = b.openFor
+	default:
+		return
+	}
+	b.state = breakerOpen
+	b.retryAt = b.now().Add(b.backoff)
+	metrics.GetOrRegisterCounter("tme.breaker.opened", metrics.DefaultRegistry).Inc(1)
+	log.WithField("event", "circuit_opened").Warnf("Not calling TME for %v after %d failures in a row, the latest being: %v", b.backoff, b.failures, err)
+}
+
+// client refuses calls while the circuit is open, and records the outcome of the others. Server errors count as
+// failures, other responses as successes.
+func (b *circuitBreaker) client(next httpClient) httpClient {
+	return breakerClient{next: next, breaker: b}
+}
+
+type breakerClient struct {
+	next    httpClient
+	breaker *circuitBreaker
+}
+
+func (c breakerClient) Do(req *http.Request) (*http.Response, error) {
+	if !c.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	resp, err := c.next.Do(req)
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		c.breaker.record(fmt.Errorf("TME answered %s", resp.Status))
+	} else {
+		c.breaker.record(err)
+	}
+	return resp, err
+}
+
+func (b *circuitBreaker) healthCheck() v1a.Check {
+	return v1a.Check{
+		BusinessImpact:   "Locations cannot be reloaded, so changes made in TME will not be published",
+		Name:             "Check the circuit to TME is closed",
+		PanicGuide:       panicGuide + "#circuit-breaker",
+		Severity:         2,
+		TechnicalSummary: "Calls to TME failed repeatedly, so they are refused for a while, and scheduled reloads skipped, to let TME recover. Locations are still served from the latest load. Check TME is up",
+		Checker:          b.checker,
+	}
+}
+
+// checker fails while the circuit is open or being probed.
+func (b *circuitBreaker) checker() (string, error) {
+	state := b.currentState()
+	if state == breakerClosed {
+		return "Circuit to TME is closed", nil
+	}
+	b.Lock()
+	defer b.Unlock()
+	return fmt.Sprintf("Circuit to TME is %s", state), fmt.Errorf("Not calling TME after %d failures in a row until %s, the latest being: %v", b.failures, b.retryAt.UTC().Format(time.RFC3339), b.lastError)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyClient answers with the outcomes it is given in turn, counting the calls it gets.
+type flakyClient struct {
+	outcomes []int
+	calls    int
+}
+
+func (c *flakyClient) Do(req *http.Request) (*http.Response, error) {
+	outcome := c.outcomes[c.calls%len(c.outcomes)]
+	c.calls++
+	if outcome == 0 {
+		return nil, errors.New("connection refused")
+	}
+	rec := httptest.NewRecorder()
+	rec.WriteHeader(outcome)
+	return rec.Result(), nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(3, time.Minute, 3*time.Minute)
+	breaker.now = func() time.Time { return now }
+	tme := &flakyClient{outcomes: []int{0, http.StatusBadGateway, http.StatusNotFound, 0}}
+	client := breaker.client(tme)
+	call := func() error {
+		req, _ := http.NewRequest("GET", "https://tme.ft.com/rs/authorityfiles/GL/terms", nil)
+		_, err := client.Do(req)
+		return err
+	}
+
+	call()
+	call()
+	call()
+	assert.Equal(t, breakerClosed, breaker.currentState(), "A response other than a server error should reset the failures")
+	tme.outcomes = []int{0}
+	call()
+	call()
+	assert.Equal(t, breakerClosed, breaker.currentState())
+	call()
+	assert.Equal(t, breakerOpen, breaker.currentState())
+	assert.True(t, breaker.isOpen())
+	status, err := breaker.checker()
+	assert.Equal(t, "Circuit to TME is open", status)
+	assert.Error(t, err)
+
+	calls := tme.calls
+	assert.Equal(t, errCircuitOpen, call())
+	assert.Equal(t, calls, tme.calls, "An open circuit should not call TME")
+
+	backoffs := []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}
+	for _, backoff := range backoffs {
+		now = now.Add(backoff - time.Second)
+		assert.Equal(t, errCircuitOpen, call(), "The circuit should stay open for %v", backoff)
+		now = now.Add(time.Second)
+		assert.Equal(t, breakerHalfOpen, breaker.currentState())
+		assert.False(t, breaker.isOpen(), "A circuit due a probe should let a scheduled reload through")
+		assert.Error(t, call())
+		assert.Equal(t, calls+1, tme.calls, "A single probe should be let through")
+		calls = tme.calls
+		assert.Equal(t, breakerOpen, breaker.currentState())
+	}
+
+	tme.outcomes = []int{http.StatusOK}
+	now = now.Add(3 * time.Minute)
+	assert.NoError(t, call())
+	assert.Equal(t, breakerClosed, breaker.currentState(), "A successful probe should close the circuit")
+	status, err = breaker.checker()
+	assert.Equal(t, "Circuit to TME is closed", status)
+	assert.NoError(t, err)
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Minute, time.Minute)
+	client := breaker.client(&flakyClient{outcomes: []int{0}})
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest("GET", "https://tme.ft.com", nil)
+		_, err := client.Do(req)
+		assert.NotEqual(t, errCircuitOpen, err)
+	}
+	assert.Equal(t, breakerClosed, breaker.currentState())
+}
+
+func TestOpenCircuitKeepsSnapshot(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(1, time.Minute, time.Minute)
+	breaker.now = func() time.Time { return now }
+	repo := &failingRepository{dummyRepo: dummyRepo{terms: []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}}, breaker: breaker}
+	service, err := newLocationService(repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+
+	repo.down = true
+	assert.Error(t, service.reload())
+	assert.True(t, breaker.isOpen())
+
+	sched := newScheduler()
+	sched.unavailable = breaker.isOpen
+	sched.add("GL", service)
+	calls := repo.calls
+	sched.reloadAll()
+	assert.Equal(t, calls, repo.calls, "Scheduled reloads should be skipped while the circuit is open")
+	assert.Equal(t, 1, service.getLocationCount(), "The locations served should be kept")
+}
+
+func TestPingThroughOpenCircuit(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute, time.Minute)
+	tme := &flakyClient{outcomes: []int{http.StatusServiceUnavailable}}
+	ping := newTMEPing(breaker.client(tme), "https://tme.ft.com")
+
+	assert.EqualError(t, ping("GL"), "TME answered 503 Service Unavailable")
+	assert.True(t, breaker.isOpen(), "A failed ping should count against the circuit")
+	assert.Equal(t, errCircuitOpen, ping("GL"))
+	assert.Equal(t, 1, tme.calls, "A ping should not call TME while the circuit is open")
+}
+
+// failingRepository calls TME through a circuit breaker before answering its terms, or failing when TME is down.
+type failingRepository struct {
+	dummyRepo
+	breaker *circuitBreaker
+	down    bool
+	calls   int
+}
+
+func (r *failingRepository) GetTmeTermsFromIndex(startRecord int) ([]interface{}, error) {
+	r.calls++
+	outcome := http.StatusOK
+	if r.down {
+		outcome = http.StatusServiceUnavailable
+	}
+	req, _ := http.NewRequest("GET", "https://tme.ft.com", nil)
+	if _, err := r.breaker.client(&flakyClient{outcomes: []int{outcome}}).Do(req); err != nil {
+		return nil, err
+	}
+	if r.down {
+		return nil, errors.New("TME answered 503 Service Unavailable")
+	}
+	return r.dummyRepo.GetTmeTermsFromIndex(startRecord)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// classificationRule assigns a location type to the terms matching all of its conditions.
+type classificationRule struct {
+	Type      string   `json:"type"`
+	IDs       []string `json:"ids,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Attribute string   `json:"attribute,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	Depth     *int     `json:"depth,omitempty"`
+
+	nameRegex  *regexp.Regexp
+	valueRegex *regexp.Regexp
+}
+
+// defaultClassificationRules use the type attribute TME sets on some of its terms.
+var defaultClassificationRules = []classificationRule{
+	{Type: "Continent", Attribute: "type", Value: "(?i)^continent$"},
+	{Type: "Country", Attribute: "type", Value: "(?i)^country$"},
+	{Type: "Region", Attribute: "type", Value: "(?i)^region$"},
+	{Type: "City", Attribute: "type", Value: "(?i)^city$"},
+}
+
+// classifier refines the type of a location. Rules are tried in order and the first match wins,
+// locations matching none keep the type of their taxonomy.
+type classifier struct {
+	rules []classificationRule
+}
+
+func newClassifier(rulesFile string) (*classifier, error) {
+	var rules []classificationRule
+	if rulesFile != "" {
+		contents, err := ioutil.ReadFile(rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(contents, &rules); err != nil {
+			return nil, fmt.Errorf("Could not parse classification rules file %s: %v", rulesFile, err)
+		}
+	}
+	rules = append(rules, defaultClassificationRules...)
+
+	for i := range rules {
+		r := &rules[i]
+		if r.Type == "" || (len(r.IDs) == 0 && r.Name == "" && r.Attribute == "" && r.Depth == nil) {
+			return nil, fmt.Errorf("Classification rule %d needs a type and at least one of ids, name, attribute or depth", i)
+		}
+		var err error
+		if r.nameRegex, err = compileOptional(r.Name); err != nil {
+			return nil, fmt.Errorf("Invalid name in classification rule %d: %v", i, err)
+		}
+		if r.valueRegex, err = compileOptional(r.Value); err != nil {
+			return nil, fmt.Errorf("Invalid value in classification rule %d: %v", i, err)
+		}
+	}
+	return &classifier{rules: rules}, nil
+}
+
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+func (c *classifier) classify(l location, t term, depth int) location {
+	for _, r := range c.rules {
+		if r.matches(t, depth) {
+			l.Type = r.Type
+			break
+		}
+	}
+	l.Types = typeAncestry(l.Type)
+	return l
+}
+
+func (r classificationRule) matches(t term, depth int) bool {
+	if r.Depth != nil && *r.Depth != depth {
+		return false
+	}
+	if r.nameRegex != nil && !r.nameRegex.MatchString(t.CanonicalName) {
+		return false
+	}
+	if len(r.IDs) > 0 && !contains(r.IDs, t.RawID) {
+		return false
+	}
+	if r.Attribute == "" {
+		return true
+	}
+	for _, a := range t.Attributes {
+		if a.Name == r.Attribute && (r.valueRegex == nil || r.valueRegex.MatchString(a.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeAncestry(locationType string) []string {
+	types := []string{"Thing", "Concept", "Location"}
+	if locationType != "Location" {
+		types = append(types, locationType)
+	}
+	return types
+}
+
+// termDepths returns how many ancestors each term has within the given terms. Top level terms have a depth of 0.
+func termDepths(terms []term) map[string]int {
+	parents := make(map[string]string, len(terms))
+	for _, t := range terms {
+		parents[t.RawID] = t.ParentID
+	}
+	depths := make(map[string]int, len(terms))
+	for _, t := range terms {
+		depth := 0
+		seen := map[string]bool{t.RawID: true}
+		for p := parents[t.RawID]; p != "" && !seen[p]; p = parents[p] {
+			if _, found := parents[p]; !found {
+				break
+			}
+			seen[p] = true
+			depth++
+		}
+		depths[t.RawID] = depth
+	}
+	return depths
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
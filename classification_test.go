@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const testClassificationRules = `[
+	{"type": "Country", "ids": ["Zm9v"]},
+	{"type": "City", "name": "^Greater "},
+	{"type": "Country", "depth": 0},
+	{"type": "Region", "depth": 1}
+]`
+
+func TestClassify(t *testing.T) {
+	f, err := ioutil.TempFile("", "classification-rules")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(testClassificationRules)
+	assert.NoError(t, err)
+	f.Close()
+
+	c, err := newClassifier(f.Name())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		term  term
+		depth int
+		types []string
+	}{
+		{"Id list", term{RawID: "Zm9v", CanonicalName: "Foo"}, 3, []string{"Thing", "Concept", "Location", "Country"}},
+		{"Name", term{RawID: "YmFy", CanonicalName: "Greater London"}, 1, []string{"Thing", "Concept", "Location", "City"}},
+		{"Depth", term{RawID: "YmF6", CanonicalName: "Europe"}, 0, []string{"Thing", "Concept", "Location", "Country"}},
+		{"Built in attribute", term{RawID: "cXV4", CanonicalName: "Paris", Attributes: []tmeAttribute{{Name: "type", Value: "City"}}}, 2, []string{"Thing", "Concept", "Location", "City"}},
+		{"Unclassified", term{RawID: "cXV4", CanonicalName: "Somewhere"}, 2, []string{"Thing", "Concept", "Location"}},
+	}
+
+	for _, test := range tests {
+		l := c.classify(location{Type: "Location"}, test.term, test.depth)
+		assert.Equal(t, test.types, l.Types, fmt.Sprintf("%s: Unexpected types", test.name))
+		assert.Equal(t, test.types[len(test.types)-1], l.Type, fmt.Sprintf("%s: Unexpected type", test.name))
+	}
+}
+
+func TestClassifierErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"No type", `[{"ids": ["Zm9v"]}]`},
+		{"No condition", `[{"type": "Country"}]`},
+		{"Bad regex", `[{"type": "Country", "name": "("}]`},
+		{"Not JSON", `type: Country`},
+	}
+
+	for _, test := range tests {
+		f, err := ioutil.TempFile("", "classification-rules")
+		assert.NoError(t, err)
+		_, err = f.WriteString(test.rules)
+		assert.NoError(t, err)
+		f.Close()
+
+		_, err = newClassifier(f.Name())
+		os.Remove(f.Name())
+		assert.Error(t, err, test.name)
+	}
+}
+
+func TestTermDepths(t *testing.T) {
+	terms := []term{
+		{RawID: "europe"},
+		{RawID: "france", ParentID: "europe"},
+		{RawID: "paris", ParentID: "france"},
+		{RawID: "orphan", ParentID: "unknown"},
+		{RawID: "a", ParentID: "b"},
+		{RawID: "b", ParentID: "a"},
+	}
+
+	depths := termDepths(terms)
+	assert.Equal(t, 0, depths["europe"])
+	assert.Equal(t, 1, depths["france"])
+	assert.Equal(t, 2, depths["paris"])
+	assert.Equal(t, 0, depths["orphan"])
+	assert.Equal(t, 1, depths["a"])
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	brotliEncoding = "br"
+	zstdEncoding   = "zstd"
+	gzipEncoding   = "gzip"
+)
+
+// supportedEncodings are the content codings offered, most preferred first when a client values several equally.
+var supportedEncodings = []string{brotliEncoding, zstdEncoding, gzipEncoding}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools keep encoders between responses, as each holds buffers far larger than most responses.
+var encoderPools = map[string]*sync.Pool{
+	brotliEncoding: {New: func() interface{} { return brotli.NewWriterLevel(nil, 5) }},
+	zstdEncoding: {New: func() interface{} {
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return e
+	}},
+	gzipEncoding: {New: func() interface{} {
+		e, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return e
+	}},
+}
+
+func acquireEncoder(encoding string, w io.Writer) encoder {
+	e := encoderPools[encoding].Get().(encoder)
+	e.Reset(w)
+	return e
+}
+
+func releaseEncoder(encoding string, e encoder) {
+	encoderPools[encoding].Put(e)
+}
+
+// negotiateEncoding picks the content coding for a response from the Accept-Encoding header of its request,
+// returning an empty string for an uncompressed response.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	values := parseQualityValues(header)
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].q > values[j].q || (values[i].q == values[j].q && encodingRank(values[i].value) < encodingRank(values[j].value))
+	})
+	for _, v := range values {
+		switch {
+		case v.value == "*":
+			return supportedEncodings[0]
+		case v.value == "identity":
+			return ""
+		case encodingRank(v.value) < len(supportedEncodings):
+			return v.value
+		}
+	}
+	return ""
+}
+
+func encodingRank(encoding string) int {
+	for i, e := range supportedEncodings {
+		if e == encoding {
+			return i
+		}
+	}
+	return len(supportedEncodings)
+}
+
+// compressionHandler compresses responses with the best content coding the client accepts. Responses that already
+// have a Content-Encoding, such as cached payloads, are left alone.
+func compressionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		cw := &compressingWriter{ResponseWriter: writer, encoding: negotiateEncoding(req.Header.Get("Accept-Encoding"))}
+		defer cw.close()
+		next.ServeHTTP(cw, req)
+	})
+}
+
+type compressingWriter struct {
+	http.ResponseWriter
+	encoding string
+	encoder  encoder
+	decided  bool
+}
+
+func (w *compressingWriter) WriteHeader(code int) {
+	if !w.decided {
+		w.decide(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressingWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.encoder.Write(b)
+}
+
+// Flush sends what has been written so far to the client, compressed, so streamed responses are not held back until
+// the end.
+func (w *compressingWriter) Flush() {
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressingWriter) decide(code int) {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return
+	}
+	addVary(h, "Accept-Encoding")
+	if w.encoding == "" || code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	weakenETag(h)
+	w.encoder = acquireEncoder(w.encoding, w.ResponseWriter)
+}
+
+func (w *compressingWriter) close() {
+	if w.encoder != nil {
+		w.encoder.Close()
+		releaseEncoder(w.encoding, w.encoder)
+		w.encoder = nil
+	}
+}
+
+func addVary(h http.Header, header string) {
+	for _, v := range h["Vary"] {
+		for _, existing := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), header) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", header)
+}
+
+// weakenETag marks an entity tag as weak, since a compressed body is not byte for byte the one the tag was made for.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// payload is a response body serialised once per snapshot. Its compressed forms are made the first time they are asked for.
+type payload struct {
+	sync.Mutex
+	contentType string
+	body        []byte
+	encoded     map[string][]byte
+}
+
+func newPayload(contentType string, body []byte) *payload {
+	return &payload{contentType: contentType, body: body, encoded: make(map[string][]byte)}
+}
+
+func (p *payload) encode(encoding string) []byte {
+	if encoding == "" {
+		return p.body
+	}
+	p.Lock()
+	defer p.Unlock()
+	if b, found := p.encoded[encoding]; found {
+		metrics.GetOrRegisterCounter("payloads.hits", metrics.DefaultRegistry).Inc(1)
+		return b
+	}
+	metrics.GetOrRegisterCounter("payloads.misses", metrics.DefaultRegistry).Inc(1)
+	var buf bytes.Buffer
+	e := acquireEncoder(encoding, &buf)
+	e.Write(p.body)
+	e.Close()
+	releaseEncoder(encoding, e)
+	p.encoded[encoding] = buf.Bytes()
+	return p.encoded[encoding]
+}
+
+// write serves the payload in the content coding the client prefers.
+func (p *payload) write(writer http.ResponseWriter, req *http.Request) {
+	encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	body := p.encode(encoding)
+	h := writer.Header()
+	h.Set("Content-Type", p.contentType)
+	addVary(h, "Accept-Encoding")
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+		weakenETag(h)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(body)
+}
+
+const (
+	listPayload = "list"
+	idsPayload  = "ids"
+	dumpPayload = "dump"
+)
+
+// snapshotPayloads serialises the collections of a snapshot: the list of location links, the ids and the dump of every
+// location, the latter two one JSON object per line in uuid order.
+func snapshotPayloads(locations locationsMap, links locationLinks) (map[string]*payload, error) {
+	uuids := make([]string, 0, len(locations))
+	for uuid := range locations {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	var list, ids, dump bytes.Buffer
+	if err := json.NewEncoder(&list).Encode(links); err != nil {
+		return nil, err
+	}
+	idsEnc := json.NewEncoder(&ids)
+	dumpEnc := json.NewEncoder(&dump)
+	for _, uuid := range uuids {
+		if err := idsEnc.Encode(locationID{ID: uuid}); err != nil {
+			return nil, err
+		}
+		if err := dumpEnc.Encode(locations[uuid]); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]*payload{
+		listPayload: newPayload("application/json", list.Bytes()),
+		idsPayload:  newPayload("text/plain", ids.Bytes()),
+		dumpPayload: newPayload("text/plain", dump.Bytes()),
+	}, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip", gzipEncoding},
+		{"gzip, deflate, br", brotliEncoding},
+		{"gzip, zstd", zstdEncoding},
+		{"br;q=0.5, gzip", gzipEncoding},
+		{"br;q=0, gzip;q=0.1", gzipEncoding},
+		{"deflate", ""},
+		{"*", brotliEncoding},
+		{"identity, gzip;q=0.5", ""},
+		{"GZIP", gzipEncoding},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.encoding, negotiateEncoding(test.header), test.header)
+	}
+}
+
+func TestCompressionHandler(t *testing.T) {
+	body := strings.Repeat(`{"apiUrl":"http://localhost:8080/transformers/locations/`+testUUID+`"}`, 100)
+	handler := compressionHandler(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("ETag", `"abc"`)
+		writer.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		io.WriteString(writer, body)
+	}))
+
+	for _, encoding := range []string{"", gzipEncoding, brotliEncoding, zstdEncoding} {
+		req := newRequest("GET", "/transformers/locations")
+		req.Header.Set("Accept-Encoding", encoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, encoding)
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"), encoding)
+		assert.Equal(t, encoding, rec.Header().Get("Content-Encoding"), encoding)
+		if encoding == "" {
+			assert.Equal(t, `"abc"`, rec.Header().Get("ETag"))
+			assert.Equal(t, body, rec.Body.String())
+			continue
+		}
+		assert.Equal(t, `W/"abc"`, rec.Header().Get("ETag"), encoding)
+		assert.Empty(t, rec.Header().Get("Content-Length"), encoding)
+		assert.True(t, rec.Body.Len() < len(body), encoding)
+		assert.Equal(t, body, decode(t, encoding, rec.Body.Bytes()), encoding)
+	}
+}
+
+func TestCompressionHandlerStreams(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(compressionHandler(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		features := 0
+		writeGeoJSONCollection(writer, func() (geoJSONFeature, bool) {
+			features++
+			if features == geoJSONFlushEvery+1 {
+				<-release
+			}
+			return toFeature(paris), features <= 2*geoJSONFlushEvery
+		})
+	})))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	prefix := `{"type":"FeatureCollection","features":[`
+
+	for _, encoding := range []string{gzipEncoding, brotliEncoding, zstdEncoding} {
+		release = make(chan struct{})
+		req := newRequest("GET", server.URL)
+		req.Header.Set("Accept-Encoding", encoding)
+		// The client reads in the background, as nothing, not even the headers, reaches it before the first flush.
+		body := make(chan string, 2)
+		go func() {
+			resp, err := client.Do(req)
+			if !assert.NoError(t, err, encoding) {
+				body <- ""
+				body <- ""
+				return
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+			r := decoder(t, encoding, resp.Body)
+			start := make([]byte, len(prefix))
+			io.ReadFull(r, start)
+			body <- string(start)
+			rest, _ := ioutil.ReadAll(r)
+			body <- string(rest)
+		}()
+		select {
+		case start := <-body:
+			assert.Equal(t, prefix, start, "%s: The features written before a flush should reach the client", encoding)
+			close(release)
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: Nothing reached the client before the end of the response", encoding)
+			close(release)
+			<-body
+		}
+		assert.True(t, strings.HasSuffix(<-body, "}]}\n"), encoding)
+	}
+}
+
+func TestCompressionHandlerSkipsEmptyResponses(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		handler := compressionHandler(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+			writer.WriteHeader(status)
+		}))
+		req := newRequest("GET", "/transformers/locations")
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, status, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, 0, rec.Body.Len())
+	}
+}
+
+func TestPayloadWrite(t *testing.T) {
+	body := []byte(strings.Repeat(`{"id":"`+testUUID+`"}`+"\n", 100))
+	p := newPayload("text/plain", body)
+	handler := compressionHandler(http.HandlerFunc(p.write))
+
+	for _, encoding := range []string{"", gzipEncoding, brotliEncoding, zstdEncoding} {
+		req := newRequest("GET", "/transformers/locations/__ids")
+		req.Header.Set("Accept-Encoding", encoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"), encoding)
+		assert.Equal(t, encoding, rec.Header().Get("Content-Encoding"), encoding)
+		assert.Equal(t, []string{"Accept-Encoding"}, rec.Header()["Vary"], encoding)
+		assert.Equal(t, fmt.Sprint(rec.Body.Len()), rec.Header().Get("Content-Length"), encoding)
+		assert.Equal(t, string(body), decode(t, encoding, rec.Body.Bytes()), encoding)
+	}
+
+	first := p.encode(gzipEncoding)
+	assert.True(t, &first[0] == &p.encode(gzipEncoding)[0], "Compressed payloads should be made once")
+}
+
+func TestServicePayloads(t *testing.T) {
+	repo := dummyRepo{terms: []term{{CanonicalName: "Paris", RawID: "UGFyaXM="}, {CanonicalName: "London", RawID: "TG9uZG9u"}}}
+	service, err := newLocationService(&repo, testTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	m := mux.NewRouter()
+	h := newLocationsHandler(service, testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
+
+	ids := service.getLocationIds("")
+	var expectedIds, expectedDump string
+	for _, uuid := range ids {
+		l, _ := service.getLocationByUUID(uuid)
+		expectedIds += fmt.Sprintf(`{"id":"%s"}`+"\n", uuid)
+		expectedDump += jsonLine(l)
+	}
+	links, _ := service.getLocations("")
+
+	tests := []struct {
+		url         string
+		contentType string
+		body        string
+	}{
+		{"/transformers/locations", "application/json", jsonLine(links)},
+		{"/transformers/locations/__ids", "text/plain", expectedIds},
+		{"/transformers/locations/__dump", "text/plain", expectedDump},
+		{"/transformers/locations/__dump?type=Location", "text/plain", expectedDump},
+	}
+	for _, test := range tests {
+		req := newRequest("GET", test.url)
+		req.Header.Set("Accept-Encoding", "br")
+		rec := httptest.NewRecorder()
+		compressionHandler(m).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, test.url)
+		assert.Equal(t, test.contentType, rec.Header().Get("Content-Type"), test.url)
+		assert.Equal(t, brotliEncoding, rec.Header().Get("Content-Encoding"), test.url)
+		assert.Equal(t, test.body, decode(t, brotliEncoding, rec.Body.Bytes()), test.url)
+	}
+}
+
+func jsonLine(obj interface{}) string {
+	rec := httptest.NewRecorder()
+	writeJSONResponse(obj, true, rec, newRequest("GET", "/transformers/locations"))
+	return rec.Body.String()
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	if encoding == "" {
+		return string(body)
+	}
+	decoded, err := ioutil.ReadAll(decoder(t, encoding, bytes.NewReader(body)))
+	assert.NoError(t, err)
+	return string(decoded)
+}
+
+// decoder reads a body in a content coding.
+func decoder(t *testing.T, encoding string, body io.Reader) io.Reader {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case gzipEncoding:
+		r, err = gzip.NewReader(body)
+	case brotliEncoding:
+		r = brotli.NewReader(body)
+	case zstdEncoding:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(body)
+		if err == nil {
+			t.Cleanup(d.Close)
+		}
+		r = d
+	}
+	assert.NoError(t, err)
+	return r
+}
+
+func benchmarkService(b *testing.B) locationService {
+	terms := make([]term, 20000)
+	for i := range terms {
+		terms[i] = term{CanonicalName: fmt.Sprintf("Location %d", i), RawID: fmt.Sprintf("location-%d", i)}
+	}
+	service, err := newLocationService(&dummyRepo{terms: terms}, testTaxonomy, 100000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return service
+}
+
+func benchmarkHandler(b *testing.B, handler http.Handler, url string) {
+	req := newRequest("GET", url)
+	req.Header.Set("Accept-Encoding", "gzip")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+// BenchmarkListEncodedPerRequest measures serving the list of locations the way it was served before payloads,
+// encoding and compressing it on every request.
+func BenchmarkListEncodedPerRequest(b *testing.B) {
+	service := benchmarkService(b)
+	benchmarkHandler(b, compressionHandler(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		links, found := service.getLocations("")
+		writeJSONResponse(links, found, writer, req)
+	})), "/transformers/locations")
+}
+
+func BenchmarkListPayload(b *testing.B) {
+	m := mux.NewRouter()
+	h := newLocationsHandler(benchmarkService(b), testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
+	benchmarkHandler(b, compressionHandler(m), "/transformers/locations")
+}
+
+// BenchmarkDumpEncodedPerRequest measures the dump as served when a type is given, encoded and compressed on every request.
+func BenchmarkDumpEncodedPerRequest(b *testing.B) {
+	m := mux.NewRouter()
+	h := newLocationsHandler(benchmarkService(b), testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
+	benchmarkHandler(b, compressionHandler(m), "/transformers/locations/__dump?type=Location")
+}
+
+func BenchmarkDumpPayload(b *testing.B) {
+	m := mux.NewRouter()
+	h := newLocationsHandler(benchmarkService(b), testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
+	benchmarkHandler(b, compressionHandler(m), "/transformers/locations/__dump")
+}
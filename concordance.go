@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	geoNamesAuthority = "GeoNames"
+	wikidataAuthority = "Wikidata"
+	iso3166Authority  = "ISO3166"
+)
+
+var authorityValues = map[string]*regexp.Regexp{
+	geoNamesAuthority: regexp.MustCompile(`^[0-9]+$`),
+	wikidataAuthority: regexp.MustCompile(`^Q[0-9]+$`),
+	iso3166Authority:  regexp.MustCompile(`^[A-Z]{2}(-[A-Z0-9]{1,3})?$`),
+}
+
+// concordance maps a TME identifier, as found in alternativeIdentifiers.TME, to an identifier from an external authority.
+type concordance struct {
+	TMEIdentifier   string `json:"tmeIdentifier"`
+	Authority       string `json:"authority"`
+	IdentifierValue string `json:"identifierValue"`
+}
+
+// concordances is an enricher reading a CSV or JSON file of concordances on every reload.
+type concordances struct {
+	file *supplementaryFile
+}
+
+func newConcordances(path string) (*concordances, error) {
+	file, err := newSupplementaryFile(path, func(path string) (interface{}, error) {
+		return readConcordances(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &concordances{file: file}, nil
+}
+
+func (c *concordances) enrich(taxonomy taxonomyConfig, locations locationsMap) []enrichmentIssue {
+	uuids := byTmeIdentifier(locations)
+	var issues []enrichmentIssue
+	for _, m := range c.file.current().([]concordance) {
+		if !inTaxonomy(m.TMEIdentifier, taxonomy) {
+			continue
+		}
+		uuid, found := uuids[m.TMEIdentifier]
+		if !found {
+			issues = append(issues, enrichmentIssue{Source: "concordance", Key: m.TMEIdentifier, Issue: fmt.Sprintf("No location for %s %s", m.Authority, m.IdentifierValue)})
+			continue
+		}
+		l := locations[uuid]
+		l.AlternativeIdentifiers = l.AlternativeIdentifiers.with(m.Authority, m.IdentifierValue)
+		locations[uuid] = l
+	}
+	return issues
+}
+
+func readConcordances(path string) ([]concordance, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mappings []concordance
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.NewDecoder(f).Decode(&mappings)
+	} else {
+		mappings, err = readConcordancesCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse concordances file %s: %v", path, err)
+	}
+
+	for i, m := range mappings {
+		authority, err := normaliseAuthority(m.Authority)
+		if err != nil {
+			return nil, fmt.Errorf("Concordance %d in %s: %v", i+1, path, err)
+		}
+		if !authorityValues[authority].MatchString(m.IdentifierValue) {
+			return nil, fmt.Errorf("Concordance %d in %s: invalid %s identifier %q", i+1, path, authority, m.IdentifierValue)
+		}
+		mappings[i].Authority = authority
+	}
+	return mappings, nil
+}
+
+// readConcordancesCSV reads rows of tmeIdentifier,authority,identifierValue after a header row.
+func readConcordancesCSV(r io.Reader) ([]concordance, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return []concordance{}, nil
+	}
+	mappings := make([]concordance, 0, len(records)-1)
+	for _, r := range records[1:] {
+		mappings = append(mappings, concordance{TMEIdentifier: r[0], Authority: r[1], IdentifierValue: r[2]})
+	}
+	return mappings, nil
+}
+
+func normaliseAuthority(authority string) (string, error) {
+	for known := range authorityValues {
+		if strings.EqualFold(strings.Replace(authority, "-", "", -1), known) {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown authority %q", authority)
+}
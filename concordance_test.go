@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, pattern string, contents string) string {
+	f, err := ioutil.TempFile("", pattern)
+	assert.NoError(t, err)
+	_, err = f.WriteString(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	return f.Name()
+}
+
+func TestReadConcordances(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		contents string
+		mappings []concordance
+		valid    bool
+	}{
+		{"CSV", "concordances*.csv", "tmeIdentifier,authority,identifierValue\nMTE3-R0w=,geonames,2988507\nMTE3-R0w=, iso-3166, FR-75\n",
+			[]concordance{{"MTE3-R0w=", geoNamesAuthority, "2988507"}, {"MTE3-R0w=", iso3166Authority, "FR-75"}}, true},
+		{"JSON", "concordances*.json", `[{"tmeIdentifier":"MTE3-R0w=","authority":"Wikidata","identifierValue":"Q90"}]`,
+			[]concordance{{"MTE3-R0w=", wikidataAuthority, "Q90"}}, true},
+		{"Unknown authority", "concordances*.csv", "tmeIdentifier,authority,identifierValue\nMTE3-R0w=,OSM,123\n", nil, false},
+		{"Invalid identifier", "concordances*.json", `[{"tmeIdentifier":"MTE3-R0w=","authority":"Wikidata","identifierValue":"90"}]`, nil, false},
+		{"Wrong columns", "concordances*.csv", "tmeIdentifier,identifierValue\nMTE3-R0w=,Q90\n", nil, false},
+	}
+
+	for _, test := range tests {
+		path := writeTempFile(t, test.pattern, test.contents)
+		mappings, err := readConcordances(path)
+		os.Remove(path)
+		assert.Equal(t, test.valid, err == nil, fmt.Sprintf("%s: Unexpected error %v", test.name, err))
+		assert.Equal(t, test.mappings, mappings, fmt.Sprintf("%s: Unexpected concordances", test.name))
+	}
+}
+
+func TestConcordancesEnrich(t *testing.T) {
+	path := writeTempFile(t, "concordances*.csv", "tmeIdentifier,authority,identifierValue\n"+
+		"MTE3-R0w=,GeoNames,2988507\n"+
+		"MTE3-R0w=,Wikidata,Q90\n"+
+		"MTg-R0w=,GeoNames,2643743\n"+
+		"MTE3-T04=,GeoNames,1\n")
+	defer os.Remove(path)
+	c, err := newConcordances(path)
+	assert.NoError(t, err)
+
+	locations := locationsMap{testUUID: getDummyLocation(testUUID, "Paris", "MTE3-R0w=")}
+	issues := c.enrich(glTaxonomy, locations)
+
+	assert.Equal(t, []string{"2988507"}, locations[testUUID].AlternativeIdentifiers.GeoNames)
+	assert.Equal(t, []string{"Q90"}, locations[testUUID].AlternativeIdentifiers.Wikidata)
+	assert.Equal(t, []enrichmentIssue{{Source: "concordance", Key: "MTg-R0w=", Issue: "No location for GeoNames 2643743"}}, issues)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("not,a,concordance\nMTE3-R0w=,Unknown,1\n"), 0644))
+	locations = locationsMap{testUUID: getDummyLocation(testUUID, "Paris", "MTE3-R0w=")}
+	c.enrich(glTaxonomy, locations)
+	assert.Equal(t, []string{"2988507"}, locations[testUUID].AlternativeIdentifiers.GeoNames, "Previous concordances should be kept")
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	log "github.com/Sirupsen/logrus"
+	"github.com/jawher/mow.cli"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	configFileOption = "config-file"
+	configFileEnvVar = "CONFIG_FILE"
+	configPath       = "/__config"
+	redacted         = "REDACTED"
+)
+
+// secretSettings are never shown by /__config.
+var secretSettings = map[string]bool{"tme-password": true, "token": true, "tme-credentials-url-token": true, "admin-token": true, "api-keys": true}
+
+// secretURLSettings are URLs that may carry credentials in their userinfo or query, which are never shown.
+var secretURLSettings = map[string]bool{"tme-credentials-url": true}
+
+// reloadableSettings are applied on SIGHUP. The others shape the routes, clients and data loaded at startup, so
+// changing them needs a restart.
+var reloadableSettings = map[string]bool{
+	"reload-interval":              true,
+	"minLocations":                 true,
+	"maxDropPercent":               true,
+	"max-staleness":                true,
+	"max-count-deviation-percent":  true,
+	"max-validation-error-percent": true,
+}
+
+// settingRule checks the value of a setting, which is a string, int, bool or []string.
+type settingRule func(value interface{}) error
+
+var settingRules = map[string]settingRule{
+	"port":                         intBetween(1, 65535),
+	"maxRecords":                   explained(intBetween(1, math.MaxInt32), "as a reload asking TME for pages of 0 terms never finishes"),
+	"slices":                       intBetween(1, math.MaxInt32),
+	"minLocations":                 intBetween(0, math.MaxInt32),
+	"maxDropPercent":               intBetween(0, 100),
+	"max-count-deviation-percent":  intBetween(0, math.MaxInt32),
+	"max-validation-error-percent": intBetween(0, 100),
+	"max-staleness":                nonNegativeDuration,
+	"drain-delay":                  nonNegativeDuration,
+	"drain-timeout":                nonNegativeDuration,
+	"reload-interval":              nonNegativeDuration,
+	"base-url":                     absoluteURL,
+	"tme-base-url":                 absoluteURL,
+	"tme-tls-min-version":          oneOf("1.2", "1.3"),
+	"tme-credentials-provider":     oneOf("env", "file", "http"),
+	"tme-credentials-refresh":      nonNegativeDuration,
+	"tme-breaker-failures":         intBetween(0, math.MaxInt32),
+	"tme-breaker-open-for":         explained(positiveDuration, "as a circuit open for no time lets every call through as a probe"),
+	"tme-breaker-max-open-for":     explained(positiveDuration, "as a circuit open for no time lets every call through as a probe"),
+	"tracing-exporter":             oneOf("none", "stdout", "otlp"),
+	"tracing-sample-ratio":         sampleRatio,
+}
+
+func intBetween(min int, max int) settingRule {
+	return func(value interface{}) error {
+		if n := value.(int); n < min || n > max {
+			if max == math.MaxInt32 {
+				return fmt.Errorf("must be at least %d, not %d", min, n)
+			}
+			return fmt.Errorf("must be between %d and %d, not %d", min, max, n)
+		}
+		return nil
+	}
+}
+
+func explained(rule settingRule, reason string) settingRule {
+	return func(value interface{}) error {
+		if err := rule(value); err != nil {
+			return fmt.Errorf("%v, %s", err, reason)
+		}
+		return nil
+	}
+}
+
+func nonNegativeDuration(value interface{}) error {
+	d, err := time.ParseDuration(value.(string))
+	if err != nil {
+		return fmt.Errorf("must be a duration such as 90s or 48h, not %q", value)
+	}
+	if d < 0 {
+		return fmt.Errorf("must not be negative, not %v", d)
+	}
+	return nil
+}
+
+func positiveDuration(value interface{}) error {
+	if err := nonNegativeDuration(value); err != nil {
+		return err
+	}
+	if d, _ := time.ParseDuration(value.(string)); d == 0 {
+		return fmt.Errorf("must be more than 0, not %v", d)
+	}
+	return nil
+}
+
+func absoluteURL(value interface{}) error {
+	u, err := url.Parse(value.(string))
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("must be an absolute URL, not %q", value)
+	}
+	return nil
+}
+
+func oneOf(allowed ...string) settingRule {
+	return func(value interface{}) error {
+		for _, a := range allowed {
+			if value.(string) == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, not %q", strings.Join(allowed, ", "), value)
+	}
+}
+
+func sampleRatio(value interface{}) error {
+	r, err := strconv.ParseFloat(value.(string), 64)
+	if err != nil || r < 0 || r > 1 {
+		return fmt.Errorf("must be a number between 0 and 1, not %q", value)
+	}
+	return nil
+}
+
+// setting is an option of the service, which may come from its default, the config file, an environment variable or
+// a flag, in increasing order of precedence.
+type setting struct {
+	name   string
+	envVar string
+	// def is the default of the option before the config file is applied.
+	def interface{}
+	// value points to the string, int, bool or []string the option is parsed into.
+	value     interface{}
+	fromFile  bool
+	setByUser bool
+}
+
+func (st *setting) get() interface{} {
+	return reflect.ValueOf(st.value).Elem().Interface()
+}
+
+func (st *setting) set(value interface{}) {
+	reflect.ValueOf(st.value).Elem().Set(reflect.ValueOf(value))
+}
+
+// source tells where the current value of a setting comes from.
+func (st *setting) source() string {
+	switch {
+	case st.setByUser:
+		return "environment or flag"
+	case st.fromFile:
+		return "config file"
+	}
+	return "default"
+}
+
+// settings declares the options of the app, taking their defaults from a YAML or TOML config file keyed by option
+// name. Environment variables and flags still override the file.
+type settings struct {
+	sync.RWMutex
+	app     *cli.Cli
+	path    string
+	file    map[string]interface{}
+	options []*setting
+	byName  map[string]*setting
+	errs    []string
+}
+
+// newSettings reads the config file given by the --config-file flag or CONFIG_FILE, if any. The file must be read
+// before the other options are declared, so the flag is looked for in the arguments ahead of parsing them.
+func newSettings(app *cli.Cli, args []string) (*settings, error) {
+	s := &settings{app: app, byName: make(map[string]*setting)}
+	s.path = configFilePath(args)
+	app.String(cli.StringOpt{
+		Name:   configFileOption,
+		Value:  "",
+		Desc:   "YAML (.yaml or .yml) or TOML (.toml) file setting any of the other options by name",
+		EnvVar: configFileEnvVar,
+	})
+	if s.path == "" {
+		return s, nil
+	}
+	file, err := readConfigFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+func configFilePath(args []string) string {
+	for i, arg := range args {
+		if arg == "--"+configFileOption && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--"+configFileOption+"=") {
+			return strings.TrimPrefix(arg, "--"+configFileOption+"=")
+		}
+	}
+	return os.Getenv(configFileEnvVar)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]interface{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	case ".toml":
+		err = toml.Unmarshal(data, &file)
+	default:
+		return nil, fmt.Errorf("Unsupported config file %s, expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse config file %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// decodeSetting converts a value read from the config file to the type of a setting. Errors redact secrets, as they
+// are logged.
+func decodeSetting(st *setting, value interface{}) (interface{}, error) {
+	switch st.def.(type) {
+	case string:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%s must be a string, not %v", st.name, redact(st.name, value))
+	case int:
+		switch n := value.(type) {
+		case int:
+			return n, nil
+		case int64:
+			return int(n), nil
+		case float64:
+			if n == math.Trunc(n) {
+				return int(n), nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be a whole number, not %v", st.name, redact(st.name, value))
+	case bool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("%s must be true or false, not %v", st.name, redact(st.name, value))
+	case []string:
+		if list, ok := value.([]interface{}); ok {
+			strs := make([]string, 0, len(list))
+			for _, item := range list {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s must be a list of strings, not %v", st.name, redact(st.name, value))
+				}
+				strs = append(strs, s)
+			}
+			return strs, nil
+		}
+		return nil, fmt.Errorf("%s must be a list of strings, not %v", st.name, redact(st.name, value))
+	}
+	return nil, fmt.Errorf("%s cannot be set in the config file", st.name)
+}
+
+// declare records an option, returning the default to declare it with: the value in the config file if there is one.
+func (s *settings) declare(name string, envVar string, def interface{}) (*setting, interface{}) {
+	st := &setting{name: name, envVar: envVar, def: def}
+	s.options = append(s.options, st)
+	s.byName[name] = st
+	value, found := s.file[name]
+	if !found {
+		return st, def
+	}
+	decoded, err := decodeSetting(st, value)
+	if err != nil {
+		s.errs = append(s.errs, err.Error())
+		return st, def
+	}
+	st.fromFile = true
+	return st, decoded
+}
+
+func (s *settings) String(opt cli.StringOpt) *string {
+	st, value := s.declare(opt.Name, opt.EnvVar, opt.Value)
+	opt.Value = value.(string)
+	opt.SetByUser = &st.setByUser
+	p := s.app.String(opt)
+	st.value = p
+	return p
+}
+
+func (s *settings) Int(opt cli.IntOpt) *int {
+	st, value := s.declare(opt.Name, opt.EnvVar, opt.Value)
+	opt.Value = value.(int)
+	opt.SetByUser = &st.setByUser
+	p := s.app.Int(opt)
+	st.value = p
+	return p
+}
+
+func (s *settings) Bool(opt cli.BoolOpt) *bool {
+	st, value := s.declare(opt.Name, opt.EnvVar, opt.Value)
+	opt.Value = value.(bool)
+	opt.SetByUser = &st.setByUser
+	p := s.app.Bool(opt)
+	st.value = p
+	return p
+}
+
+func (s *settings) Strings(opt cli.StringsOpt) *[]string {
+	st, value := s.declare(opt.Name, opt.EnvVar, opt.Value)
+	opt.Value = value.([]string)
+	opt.SetByUser = &st.setByUser
+	p := s.app.Strings(opt)
+	st.value = p
+	return p
+}
+
+// validate checks the options once they are parsed, reporting every problem at once. mow.cli only tells flags given
+// on the command line apart, so options set by environment variables are found here.
+func (s *settings) validate() error {
+	for _, st := range s.options {
+		if st.envVar != "" && os.Getenv(st.envVar) != "" {
+			st.setByUser = true
+		}
+	}
+	problems := append([]string{}, s.errs...)
+	problems = append(problems, s.unknown(s.file)...)
+	for _, st := range s.options {
+		problems = append(problems, check(st.name, st.get())...)
+	}
+	return settingsError(s.path, problems)
+}
+
+func (s *settings) unknown(file map[string]interface{}) []string {
+	var problems []string
+	for name := range file {
+		if _, found := s.byName[name]; !found || name == configFileOption {
+			problems = append(problems, fmt.Sprintf("%s is not a known setting", name))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func check(name string, value interface{}) []string {
+	if rule, found := settingRules[name]; found {
+		if err := rule(value); err != nil {
+			problem := fmt.Sprintf("%s %v", name, err)
+			if v, ok := value.(string); ok && v != "" && redact(name, v) != v {
+				problem = strings.Replace(problem, v, redact(name, v).(string), -1)
+			}
+			return []string{problem}
+		}
+	}
+	return nil
+}
+
+func settingsError(path string, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if path == "" {
+		return fmt.Errorf("Invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return fmt.Errorf("Invalid configuration from %s: %s", path, strings.Join(problems, "; "))
+}
+
+// reload reads the config file again and applies the reloadable settings not overridden by an environment variable or
+// flag. Nothing is applied if the file is invalid. It returns the names of the settings that changed.
+func (s *settings) reload() ([]string, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+	file, err := readConfigFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	problems := s.unknown(file)
+	values := make(map[string]interface{})
+	s.RLock()
+	for _, st := range s.options {
+		if st.setByUser || st.name == configFileOption {
+			continue
+		}
+		value, found := file[st.name]
+		if !found {
+			value = st.def
+		} else if value, err = decodeSetting(st, value); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if !reloadableSettings[st.name] {
+			if !reflect.DeepEqual(value, st.get()) {
+				log.Warnf("Setting %s changed in %s, but it is only applied on restart", st.name, s.path)
+			}
+			continue
+		}
+		problems = append(problems, check(st.name, value)...)
+		values[st.name] = value
+	}
+	s.RUnlock()
+	if err := settingsError(s.path, problems); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	var changed []string
+	for name, value := range values {
+		st := s.byName[name]
+		_, st.fromFile = file[name]
+		if !reflect.DeepEqual(value, st.get()) {
+			st.set(value)
+			changed = append(changed, name)
+		}
+	}
+	s.file = file
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// string returns the current value of a string setting, which may change on reload.
+func (s *settings) string(name string) string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.byName[name].get().(string)
+}
+
+// int returns the current value of an int setting, which may change on reload.
+func (s *settings) int(name string) int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.byName[name].get().(int)
+}
+
+// duration returns the current value of a duration setting, which has been validated.
+func (s *settings) duration(name string) time.Duration {
+	d, _ := time.ParseDuration(s.string(name))
+	return d
+}
+
+type effectiveSetting struct {
+	Value      interface{} `json:"value"`
+	Source     string      `json:"source"`
+	Reloadable bool        `json:"reloadable"`
+}
+
+// effective lists the settings in use, with secrets redacted.
+func (s *settings) effective() map[string]effectiveSetting {
+	s.RLock()
+	defer s.RUnlock()
+	all := make(map[string]effectiveSetting)
+	for _, st := range s.options {
+		if st.name == configFileOption {
+			continue
+		}
+		all[st.name] = effectiveSetting{Value: redact(st.name, st.get()), Source: st.source(), Reloadable: reloadableSettings[st.name]}
+	}
+	return all
+}
+
+// redact hides secrets, keeping the names and roles of API keys, and the hosts and paths of URLs, so it is clear what
+// is configured.
+func redact(name string, value interface{}) interface{} {
+	if v, ok := value.(string); ok && secretURLSettings[name] {
+		return redactURL(v)
+	}
+	if !secretSettings[name] {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return ""
+		}
+		return redacted
+	case []string:
+		keys := make([]string, 0, len(v))
+		for _, entry := range v {
+			parts := strings.SplitN(entry, ":", 3)
+			if len(parts) == 3 {
+				keys = append(keys, parts[0]+":"+parts[1]+":"+redacted)
+			} else {
+				keys = append(keys, redacted)
+			}
+		}
+		return keys
+	}
+	return redacted
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redacted
+	}
+	if u.Fragment != "" {
+		u.Fragment = redacted
+	}
+	return u.String()
+}
+
+// configHandler shows the effective configuration to admins.
+func configHandler(s *settings, auth *authenticator, limits *limiter) http.HandlerFunc {
+	return limits.limit("config", auth.require(adminRole, func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(struct {
+			ConfigFile string                      `json:"configFile,omitempty"`
+			Settings   map[string]effectiveSetting `json:"settings"`
+		}{s.path, s.effective()})
+	}))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentials authenticate the service with TME. They format as redacted so they cannot end up in logs.
+type credentials struct {
+	username string
+	password string
+	token    string
+}
+
+func (c credentials) String() string {
+	return "credentials{" + redacted + "}"
+}
+
+func (c credentials) GoString() string {
+	return c.String()
+}
+
+// credentialsProvider gives the current TME credentials. It is asked on every request to TME, so rotated credentials
+// are picked up without a restart.
+type credentialsProvider interface {
+	credentials() (credentials, error)
+}
+
+// newCredentialsProvider makes the provider named by kind: env, for the tme-username, tme-password and token options,
+// file or http.
+func newCredentialsProvider(kind string, static credentials, dir string, url string, urlToken string, refresh time.Duration, client httpClient) (credentialsProvider, error) {
+	switch kind {
+	case "env":
+		return staticCredentials{static}, nil
+	case "file":
+		if dir == "" {
+			return nil, errors.New("The file credentials provider needs tme-credentials-dir")
+		}
+		p := &fileCredentials{dir: dir}
+		if _, err := p.credentials(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "http":
+		if url == "" {
+			return nil, errors.New("The http credentials provider needs tme-credentials-url")
+		}
+		return &httpCredentials{url: url, token: urlToken, refresh: refresh, client: client, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("Unknown credentials provider %q, expected env, file or http", kind)
+}
+
+// staticCredentials are set once at startup.
+type staticCredentials struct {
+	c credentials
+}
+
+func (p staticCredentials) credentials() (credentials, error) {
+	return p.c, nil
+}
+
+var credentialFiles = []string{"username", "password", "token"}
+
+// fileCredentials reads the credentials from the files username, password and an optional token in a directory, such
+// as a mounted Kubernetes secret. The files are read again whenever one of them changes.
+type fileCredentials struct {
+	sync.Mutex
+	dir      string
+	modTimes map[string]time.Time
+	current  credentials
+}
+
+func (p *fileCredentials) credentials() (credentials, error) {
+	p.Lock()
+	defer p.Unlock()
+	modTimes := make(map[string]time.Time)
+	for _, name := range credentialFiles {
+		if info, err := os.Stat(filepath.Join(p.dir, name)); err == nil {
+			modTimes[name] = info.ModTime()
+		}
+	}
+	if p.modTimes != nil && sameModTimes(modTimes, p.modTimes) {
+		return p.current, nil
+	}
+	values := make(map[string]string)
+	for _, name := range credentialFiles {
+		data, err := ioutil.ReadFile(filepath.Join(p.dir, name))
+		if err != nil && (name != "token" || !os.IsNotExist(err)) {
+			return p.fallback(fmt.Errorf("Could not read TME %s from %s: %v", name, p.dir, err))
+		}
+		values[name] = strings.TrimSpace(string(data))
+	}
+	if values["username"] == "" || values["password"] == "" {
+		return p.fallback(fmt.Errorf("The TME username or password in %s is empty", p.dir))
+	}
+	if p.modTimes != nil {
+		log.WithField("event", "credentials_rotated").Infof("Read rotated TME credentials from %s", p.dir)
+	}
+	p.current = credentials{username: values["username"], password: values["password"], token: values["token"]}
+	p.modTimes = modTimes
+	return p.current, nil
+}
+
+// fallback keeps using the credentials last read while the files are being rotated, or fails if there are none.
+func (p *fileCredentials) fallback(err error) (credentials, error) {
+	if p.modTimes == nil {
+		return credentials{}, err
+	}
+	log.Warnf("Keeping the TME credentials last read: %v", err)
+	return p.current, nil
+}
+
+func sameModTimes(a map[string]time.Time, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, t := range a {
+		if !t.Equal(b[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// httpCredentials fetches the credentials as a JSON object with username, password and token fields from a secrets
+// endpoint, asking again once they are older than refresh.
+type httpCredentials struct {
+	sync.Mutex
+	url       string
+	token     string
+	refresh   time.Duration
+	client    httpClient
+	now       func() time.Time
+	current   credentials
+	fetchedAt time.Time
+}
+
+func (p *httpCredentials) credentials() (credentials, error) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.fetchedAt.IsZero() && p.now().Sub(p.fetchedAt) < p.refresh {
+		return p.current, nil
+	}
+	c, err := p.fetch()
+	if err != nil {
+		if p.fetchedAt.IsZero() {
+			return credentials{}, err
+		}
+		log.Warnf("Keeping the TME credentials last fetched: %v", err)
+		return p.current, nil
+	}
+	if !p.fetchedAt.IsZero() && c != p.current {
+		log.WithField("event", "credentials_rotated").Info("Fetched rotated TME credentials")
+	}
+	p.current = c
+	p.fetchedAt = p.now()
+	return c, nil
+}
+
+// fetch asks the secrets endpoint for the credentials. Its errors never include the response, which may hold secrets,
+// nor the userinfo or query of the endpoint.
+func (p *httpCredentials) fetch() (credentials, error) {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return credentials{}, fmt.Errorf("Could not fetch TME credentials: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return credentials{}, fmt.Errorf("Could not fetch TME credentials: the secrets endpoint answered %s", resp.Status)
+	}
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return credentials{}, errors.New("Could not fetch TME credentials: the secrets endpoint did not answer a JSON object")
+	}
+	if body.Username == "" || body.Password == "" {
+		return credentials{}, errors.New("Could not fetch TME credentials: the username or password is empty")
+	}
+	return credentials{username: body.Username, password: body.Password, token: body.Token}, nil
+}
+
+// credentialsClient authenticates each request to TME with the current credentials, replacing any set by the TME reader.
+type credentialsClient struct {
+	next     httpClient
+	provider credentialsProvider
+}
+
+func (c credentialsClient) Do(req *http.Request) (*http.Response, error) {
+	creds, err := c.provider.credentials()
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(creds.username, creds.password)
+	req.Header.Set("X-Coco-Auth", creds.token)
+	return c.next.Do(req)
+}
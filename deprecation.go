@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	mergedStatus  = "merged"
+	retiredStatus = "retired"
+)
+
+// deprecatedLocation records a UUID that is no longer served, either because it was merged into another location
+// or because it was retired, so that annotations using it can be migrated.
+type deprecatedLocation struct {
+	UUID          string    `json:"uuid"`
+	PrefLabel     string    `json:"prefLabel,omitempty"`
+	TMEIdentifier string    `json:"tmeIdentifier,omitempty"`
+	Status        string    `json:"status"`
+	MergedInto    string    `json:"mergedInto,omitempty"`
+	Reason        string    `json:"reason"`
+	Since         time.Time `json:"since"`
+}
+
+type merge struct {
+	tmeIdentifier string
+	mergedInto    string
+}
+
+// deprecations works out which UUIDs stopped being served with each load, from TME status attributes, a file of
+// merges shared by all taxonomies and the locations missing since the previous load. When path is set they are
+// persisted there, so a restart does not forget locations retired by earlier loads.
+type deprecations struct {
+	merges *supplementaryFile
+	path   string
+}
+
+func newDeprecations(merges *supplementaryFile, path string) *deprecations {
+	return &deprecations{merges: merges, path: path}
+}
+
+func newMerges(path string) (*supplementaryFile, error) {
+	return newSupplementaryFile(path, func(path string) (interface{}, error) {
+		return readMerges(path)
+	})
+}
+
+// readMerges reads rows of tmeIdentifier,mergedInto after a header row, both being TME identifiers.
+func readMerges(path string) ([]merge, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse merges file %s: %v", path, err)
+	}
+
+	merges := make([]merge, 0, len(records))
+	for i, r := range records {
+		if i == 0 {
+			continue
+		}
+		if r[0] == "" || r[1] == "" || r[0] == r[1] {
+			return nil, fmt.Errorf("Merge on line %d of %s must name two different TME identifiers", i+1, path)
+		}
+		merges = append(merges, merge{tmeIdentifier: r[0], mergedInto: r[1]})
+	}
+	return merges, nil
+}
+
+// termDeprecation tells whether TME marks a term as deprecated through its status attribute, and the raw id of the
+// term replacing it, if any, from its replacedBy attribute.
+func termDeprecation(t term) (bool, string) {
+	var deprecated bool
+	var replacedBy string
+	for _, a := range t.Attributes {
+		switch strings.ToLower(a.Name) {
+		case "status":
+			status := strings.ToLower(strings.TrimSpace(a.Value))
+			deprecated = status == "deprecated" || status == "retired" || status == "merged"
+		case "replacedby":
+			replacedBy = strings.TrimSpace(a.Value)
+		}
+	}
+	return deprecated, replacedBy
+}
+
+// update returns the deprecations of a new snapshot, starting from the current ones. Locations deprecated in TME have
+// already been left out of the builder; merged ones are removed from it here, locations that were served before and are
+// missing now are retired, and deprecated locations found in TME again are reinstated. Merge chains are resolved to
+// their last location, which lists the UUIDs merged into it.
+func (d *deprecations) update(taxonomy taxonomyConfig, uuids uuidStrategy, b *snapshotBuilder, fromTME []deprecatedLocation, previous locationsMap, current map[string]deprecatedLocation) map[string]deprecatedLocation {
+	now := time.Now().UTC()
+	result := make(map[string]deprecatedLocation, len(current))
+	for uuid, dl := range current {
+		result[uuid] = dl
+	}
+	record := func(dl deprecatedLocation) {
+		if existing, found := result[dl.UUID]; found && existing.Status == dl.Status && existing.MergedInto == dl.MergedInto {
+			return
+		}
+		dl.Since = now
+		result[dl.UUID] = dl
+	}
+
+	latest := make(map[string]bool)
+	for _, dl := range fromTME {
+		record(dl)
+		latest[dl.UUID] = true
+	}
+	if d != nil && d.merges != nil {
+		for _, m := range d.merges.current().([]merge) {
+			if !inTaxonomy(m.tmeIdentifier, taxonomy) {
+				continue
+			}
+			uuid := uuids.uuidFor(m.tmeIdentifier)
+			label := b.locations[uuid].PrefLabel
+			if label == "" {
+				label = previous[uuid].PrefLabel
+			}
+			record(deprecatedLocation{UUID: uuid, PrefLabel: label, TMEIdentifier: m.tmeIdentifier, Status: mergedStatus, MergedInto: uuids.uuidFor(m.mergedInto), Reason: "Listed in the merges file"})
+			latest[uuid] = true
+		}
+	}
+
+	for uuid := range result {
+		if _, found := b.locations[uuid]; !found {
+			continue
+		}
+		if latest[uuid] {
+			b.remove(uuid)
+			continue
+		}
+		log.Infof("Reinstating deprecated location with uuid=%s found in TME again", uuid)
+		delete(result, uuid)
+	}
+	for uuid, l := range previous {
+		if _, found := b.locations[uuid]; found {
+			continue
+		}
+		if _, found := result[uuid]; !found {
+			record(deprecatedLocation{UUID: uuid, PrefLabel: l.PrefLabel, TMEIdentifier: tmeIdentifier(l), Status: retiredStatus, Reason: "No longer in TME"})
+		}
+	}
+
+	resolveMerges(result)
+	for _, dl := range result {
+		if dl.Status != mergedStatus {
+			continue
+		}
+		if l, found := b.locations[dl.MergedInto]; found && !contains(l.AlternativeIdentifiers.Uuids, dl.UUID) {
+			l.AlternativeIdentifiers.Uuids = append(append([]string{}, l.AlternativeIdentifiers.Uuids...), dl.UUID)
+			b.locations[dl.MergedInto] = l
+		}
+	}
+	return result
+}
+
+// resolveMerges points every merged location at the end of its merge chain. Locations merged in a cycle are retired.
+func resolveMerges(deprecated map[string]deprecatedLocation) {
+	for uuid, dl := range deprecated {
+		if dl.Status != mergedStatus {
+			continue
+		}
+		visited := map[string]bool{uuid: true}
+		target := dl.MergedInto
+		for {
+			next, found := deprecated[target]
+			if !found || next.Status != mergedStatus {
+				break
+			}
+			if visited[target] {
+				log.Warnf("Retiring location with uuid=%s as it is merged in a cycle", uuid)
+				dl.Status, dl.MergedInto, dl.Reason = retiredStatus, "", "Merged in a cycle"
+				break
+			}
+			visited[target] = true
+			target = next.MergedInto
+		}
+		if dl.Status == mergedStatus {
+			dl.MergedInto = target
+		}
+		deprecated[uuid] = dl
+	}
+}
+
+// load reads the deprecations persisted by a previous run. A missing file is not an error.
+func (d *deprecations) load() (map[string]deprecatedLocation, error) {
+	deprecated := make(map[string]deprecatedLocation)
+	if d == nil || d.path == "" {
+		return deprecated, nil
+	}
+	contents, err := ioutil.ReadFile(d.path)
+	if os.IsNotExist(err) {
+		return deprecated, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []deprecatedLocation
+	if err := json.Unmarshal(contents, &list); err != nil {
+		return nil, fmt.Errorf("Could not parse deprecations file %s: %v", d.path, err)
+	}
+	for _, dl := range list {
+		deprecated[dl.UUID] = dl
+	}
+	return deprecated, nil
+}
+
+// save replaces the persisted deprecations with the given ones, as a JSON list in uuid order. Without a path it does nothing.
+func (d *deprecations) save(deprecated map[string]deprecatedLocation) error {
+	if d == nil || d.path == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(sortedDeprecations(deprecated), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(d.path, contents)
+}
+
+// writeFileAtomically replaces a file, writing to a temporary file first so a crash never leaves half a file.
+func writeFileAtomically(path string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sortedDeprecations(deprecated map[string]deprecatedLocation) []deprecatedLocation {
+	list := make([]deprecatedLocation, 0, len(deprecated))
+	for _, dl := range deprecated {
+		list = append(list, dl)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].UUID < list[j].UUID
+	})
+	return list
+}
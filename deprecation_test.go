@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeprecations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deprecations")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	merges := writeTempFile(t, "merges*.csv", "tmeIdentifier,mergedInto\n"+
+		buildTmeIdentifier("bombay", "GL")+","+buildTmeIdentifier("mumbai", "GL")+"\n"+
+		buildTmeIdentifier("elsewhere", "ON")+","+buildTmeIdentifier("mumbai", "ON")+"\n")
+	defer os.Remove(merges)
+	m, err := newMerges(merges)
+	assert.NoError(t, err)
+	d := newDeprecations(m, filepath.Join(dir, "deprecations-GL.json"))
+
+	uuidOf := func(rawID string) string {
+		return md5UUIDStrategy{}.uuidFor(buildTmeIdentifier(rawID, "GL"))
+	}
+	repo := dummyRepo{terms: []term{
+		{CanonicalName: "Mumbai", RawID: "mumbai"},
+		{CanonicalName: "Bombay", RawID: "bombay"},
+		{CanonicalName: "Calcutta", RawID: "calcutta", Attributes: []tmeAttribute{{Name: "Status", Value: "Deprecated"}, {Name: "replacedBy", Value: "kolkata"}}},
+		{CanonicalName: "Kolkata", RawID: "kolkata"},
+		{CanonicalName: "Atlantis", RawID: "atlantis"},
+	}}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, d)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, service.getLocationCount())
+
+	bombay, found := service.getDeprecation(uuidOf("bombay"))
+	assert.True(t, found)
+	assert.Equal(t, mergedStatus, bombay.Status)
+	assert.Equal(t, uuidOf("mumbai"), bombay.MergedInto)
+	assert.Equal(t, "Bombay", bombay.PrefLabel)
+	calcutta, found := service.getDeprecation(uuidOf("calcutta"))
+	assert.True(t, found)
+	assert.Equal(t, uuidOf("kolkata"), calcutta.MergedInto)
+	mumbai, _ := service.getLocationByUUID(uuidOf("mumbai"))
+	assert.Equal(t, []string{uuidOf("mumbai"), uuidOf("bombay")}, mumbai.AlternativeIdentifiers.Uuids)
+
+	repo.terms = repo.terms[:4]
+	assert.NoError(t, service.reload())
+	atlantis, found := service.getDeprecation(uuidOf("atlantis"))
+	assert.True(t, found)
+	assert.Equal(t, retiredStatus, atlantis.Status)
+	assert.Equal(t, "Atlantis", atlantis.PrefLabel)
+	assert.Len(t, service.getDeprecations(), 3)
+
+	restarted, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, d)
+	assert.NoError(t, err)
+	restored, found := restarted.getDeprecation(uuidOf("atlantis"))
+	assert.True(t, found)
+	assert.True(t, restored.Since.Equal(atlantis.Since))
+
+	repo.terms = append(repo.terms, term{CanonicalName: "Atlantis", RawID: "atlantis"})
+	assert.NoError(t, restarted.reload())
+	_, found = restarted.getDeprecation(uuidOf("atlantis"))
+	assert.False(t, found)
+	_, found = restarted.getLocationByUUID(uuidOf("atlantis"))
+	assert.True(t, found)
+}
+
+func TestResolveMerges(t *testing.T) {
+	deprecated := map[string]deprecatedLocation{
+		"a": {UUID: "a", Status: mergedStatus, MergedInto: "b"},
+		"b": {UUID: "b", Status: mergedStatus, MergedInto: "c"},
+		"x": {UUID: "x", Status: mergedStatus, MergedInto: "y"},
+		"y": {UUID: "y", Status: mergedStatus, MergedInto: "x"},
+	}
+	resolveMerges(deprecated)
+	assert.Equal(t, "c", deprecated["a"].MergedInto)
+	assert.Equal(t, "c", deprecated["b"].MergedInto)
+	assert.True(t, deprecated["x"].Status == retiredStatus || deprecated["y"].Status == retiredStatus)
+}
+
+func TestReadMerges(t *testing.T) {
+	for _, invalid := range []string{"tmeIdentifier,mergedInto\nMTE3-R0w=,\n", "tmeIdentifier,mergedInto\nMTE3-R0w=,MTE3-R0w=\n", "tmeIdentifier\nMTE3-R0w=\n"} {
+		path := writeTempFile(t, "merges*.csv", invalid)
+		_, err := readMerges(path)
+		os.Remove(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/base64"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// enricher adds data from outside TME to the locations of a freshly loaded snapshot, before it is indexed.
+type enricher interface {
+	enrich(taxonomy taxonomyConfig, locations locationsMap) []enrichmentIssue
+}
+
+// enrichmentIssue reports supplementary data that could not be applied, such as a mapping to an unknown location.
+type enrichmentIssue struct {
+	Source string `json:"source"`
+	Key    string `json:"key"`
+	Issue  string `json:"issue"`
+}
+
+// byTmeIdentifier indexes the UUIDs of the given locations by their TME identifiers.
+func byTmeIdentifier(locations locationsMap) map[string]string {
+	index := make(map[string]string, len(locations))
+	for uuid, l := range locations {
+		for _, id := range l.AlternativeIdentifiers.TME {
+			index[id] = uuid
+		}
+	}
+	return index
+}
+
+// inTaxonomy tells whether a TME identifier, as built by buildTmeIdentifier, belongs to the given taxonomy.
+func inTaxonomy(tmeIdentifier string, taxonomy taxonomyConfig) bool {
+	return strings.HasSuffix(tmeIdentifier, "-"+base64.StdEncoding.EncodeToString([]byte(taxonomy.name)))
+}
+
+// supplementaryFile rereads a file on every reload, falling back to the last contents read when it becomes unreadable.
+type supplementaryFile struct {
+	sync.Mutex
+	path  string
+	parse func(path string) (interface{}, error)
+	last  interface{}
+}
+
+func newSupplementaryFile(path string, parse func(path string) (interface{}, error)) (*supplementaryFile, error) {
+	contents, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+	return &supplementaryFile{path: path, parse: parse, last: contents}, nil
+}
+
+func (f *supplementaryFile) current() interface{} {
+	f.Lock()
+	defer f.Unlock()
+	contents, err := f.parse(f.path)
+	if err != nil {
+		log.Errorf("Could not reread %s, using its previous contents: %v", f.path, err)
+		return f.last
+	}
+	f.last = contents
+	return f.last
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type gazetteerEntry struct {
+	tmeIdentifier string
+	coordinates   *coordinates
+	boundingBox   *boundingBox
+}
+
+// gazetteer is an enricher setting coordinates and bounding boxes from a CSV file reread on every reload.
+// They take precedence over the coordinates held in TME.
+type gazetteer struct {
+	file *supplementaryFile
+}
+
+func newGazetteer(path string) (*gazetteer, error) {
+	file, err := newSupplementaryFile(path, func(path string) (interface{}, error) {
+		return readGazetteer(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gazetteer{file: file}, nil
+}
+
+func (g *gazetteer) enrich(taxonomy taxonomyConfig, locations locationsMap) []enrichmentIssue {
+	uuids := byTmeIdentifier(locations)
+	var issues []enrichmentIssue
+	for _, e := range g.file.current().([]gazetteerEntry) {
+		if !inTaxonomy(e.tmeIdentifier, taxonomy) {
+			continue
+		}
+		uuid, found := uuids[e.tmeIdentifier]
+		if !found {
+			issues = append(issues, enrichmentIssue{Source: "gazetteer", Key: e.tmeIdentifier, Issue: "No location for gazetteer entry"})
+			continue
+		}
+		l := locations[uuid]
+		if e.coordinates != nil {
+			l.Coordinates = e.coordinates
+		}
+		if e.boundingBox != nil {
+			l.BoundingBox = e.boundingBox
+		}
+		locations[uuid] = l
+	}
+	return issues
+}
+
+// readGazetteer reads rows of tmeIdentifier,latitude,longitude,south,west,north,east after a header row.
+// Either the coordinates or the bounding box may be left empty.
+func readGazetteer(path string) ([]gazetteerEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 7
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse gazetteer file %s: %v", path, err)
+	}
+
+	entries := make([]gazetteerEntry, 0, len(records))
+	for i, r := range records {
+		if i == 0 {
+			continue
+		}
+		e := gazetteerEntry{tmeIdentifier: r[0]}
+		if r[1] != "" || r[2] != "" {
+			c, err := parseCoordinates(r[1], r[2])
+			if err != nil {
+				return nil, fmt.Errorf("Gazetteer entry on line %d of %s: %v", i+1, path, err)
+			}
+			e.coordinates = &c
+		}
+		if r[3] != "" || r[4] != "" || r[5] != "" || r[6] != "" {
+			b, err := parseBoundingBox(strings.Join([]string{r[4], r[3], r[6], r[5]}, ","))
+			if err != nil {
+				return nil, fmt.Errorf("Gazetteer entry on line %d of %s: %v", i+1, path, err)
+			}
+			e.boundingBox = &b
+		}
+		if e.coordinates == nil && e.boundingBox == nil {
+			return nil, fmt.Errorf("Gazetteer entry on line %d of %s has neither coordinates nor a bounding box", i+1, path)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	earthRadiusKm  = 6371.0
+	gridCellDegree = 1.0
+)
+
+type coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// boundingBox may cross the antimeridian, in which case West is greater than East.
+type boundingBox struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+func (c coordinates) validate() error {
+	if math.IsNaN(c.Latitude) || c.Latitude < -90 || c.Latitude > 90 {
+		return fmt.Errorf("latitude %v is not between -90 and 90", c.Latitude)
+	}
+	if math.IsNaN(c.Longitude) || c.Longitude < -180 || c.Longitude > 180 {
+		return fmt.Errorf("longitude %v is not between -180 and 180", c.Longitude)
+	}
+	return nil
+}
+
+func (b boundingBox) validate() error {
+	if err := (coordinates{Latitude: b.South, Longitude: b.West}).validate(); err != nil {
+		return err
+	}
+	if err := (coordinates{Latitude: b.North, Longitude: b.East}).validate(); err != nil {
+		return err
+	}
+	if b.South > b.North {
+		return fmt.Errorf("south %v is north of north %v", b.South, b.North)
+	}
+	return nil
+}
+
+func (b boundingBox) contains(c coordinates) bool {
+	if c.Latitude < b.South || c.Latitude > b.North {
+		return false
+	}
+	if b.West <= b.East {
+		return c.Longitude >= b.West && c.Longitude <= b.East
+	}
+	return c.Longitude >= b.West || c.Longitude <= b.East
+}
+
+func (b boundingBox) centre() coordinates {
+	east := b.East
+	if b.West > b.East {
+		east += 360
+	}
+	lon := (b.West + east) / 2
+	if lon > 180 {
+		lon -= 360
+	}
+	return coordinates{Latitude: (b.South + b.North) / 2, Longitude: lon}
+}
+
+func parseCoordinates(lat string, lon string) (coordinates, error) {
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil {
+		return coordinates{}, fmt.Errorf("invalid latitude %q", lat)
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil {
+		return coordinates{}, fmt.Errorf("invalid longitude %q", lon)
+	}
+	c := coordinates{Latitude: latitude, Longitude: longitude}
+	return c, c.validate()
+}
+
+// parseBoundingBox parses west,south,east,north, the order used by GeoJSON.
+func parseBoundingBox(value string) (boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return boundingBox{}, fmt.Errorf("bbox must be west,south,east,north")
+	}
+	var edges [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return boundingBox{}, fmt.Errorf("bbox must be west,south,east,north")
+		}
+		edges[i] = f
+	}
+	b := boundingBox{West: edges[0], South: edges[1], East: edges[2], North: edges[3]}
+	return b, b.validate()
+}
+
+// position is where a location is placed in the spatial index: its coordinates, or else the centre of its bounding box.
+func position(l location) (coordinates, bool) {
+	if l.Coordinates != nil {
+		return *l.Coordinates, true
+	}
+	if l.BoundingBox != nil {
+		return l.BoundingBox.centre(), true
+	}
+	return coordinates{}, false
+}
+
+func distanceKm(a coordinates, b coordinates) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// roundKm rounds a distance to the nearest ten metres.
+func roundKm(d float64) float64 {
+	return math.Floor(d*100+0.5) / 100
+}
+
+type gridCell struct {
+	lat int
+	lon int
+}
+
+func cellOf(c coordinates) gridCell {
+	return gridCell{lat: latCell(c.Latitude), lon: lonCell(c.Longitude)}
+}
+
+func latCell(lat float64) int {
+	return int(math.Floor(math.Min(lat, 90-gridCellDegree/2) / gridCellDegree))
+}
+
+func lonCell(lon float64) int {
+	cells := int(360 / gridCellDegree)
+	i := int(math.Floor((lon + 180) / gridCellDegree))
+	return ((i % cells) + cells) % cells
+}
+
+// spatialIndex buckets locations into a grid of one degree cells, rebuilt with every snapshot.
+type spatialIndex struct {
+	cells     map[gridCell][]string
+	positions map[string]coordinates
+}
+
+type nearbyLocation struct {
+	location
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+type nearbyLocationRef struct {
+	uuid       string
+	distanceKm float64
+}
+
+func newSpatialIndex(locations locationsMap, order []string) *spatialIndex {
+	idx := &spatialIndex{cells: make(map[gridCell][]string), positions: make(map[string]coordinates)}
+	for _, uuid := range order {
+		c, found := position(locations[uuid])
+		if !found {
+			continue
+		}
+		idx.positions[uuid] = c
+		cell := cellOf(c)
+		idx.cells[cell] = append(idx.cells[cell], uuid)
+	}
+	return idx
+}
+
+// candidates returns the UUIDs in the cells covering the latitudes and the longitude cells from west to east inclusive.
+func (idx *spatialIndex) candidates(south float64, north float64, westCell int, eastCell int) []string {
+	var uuids []string
+	cells := int(360 / gridCellDegree)
+	span := ((eastCell-westCell)%cells + cells) % cells
+	for lat := latCell(south); lat <= latCell(north); lat++ {
+		for i := 0; i <= span; i++ {
+			uuids = append(uuids, idx.cells[gridCell{lat: lat, lon: (westCell + i) % cells}]...)
+		}
+	}
+	return uuids
+}
+
+func (idx *spatialIndex) near(c coordinates, radiusKm float64) []nearbyLocationRef {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+	south, north := math.Max(-90, c.Latitude-dLat), math.Min(90, c.Latitude+dLat)
+
+	westCell, eastCell := 0, int(360/gridCellDegree)-1
+	maxLat := math.Max(math.Abs(south), math.Abs(north))
+	if maxLat < 89 {
+		dLon := dLat / math.Cos(maxLat*math.Pi/180)
+		if dLon < 180 {
+			westCell, eastCell = lonCell(c.Longitude-dLon), lonCell(c.Longitude+dLon)
+			if westCell == eastCell && 2*dLon >= gridCellDegree {
+				westCell, eastCell = 0, int(360/gridCellDegree)-1
+			}
+		}
+	}
+
+	var hits []nearbyLocationRef
+	for _, uuid := range idx.candidates(south, north, westCell, eastCell) {
+		if d := distanceKm(c, idx.positions[uuid]); d <= radiusKm {
+			hits = append(hits, nearbyLocationRef{uuid: uuid, distanceKm: d})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].distanceKm < hits[j].distanceKm
+	})
+	return hits
+}
+
+func (idx *spatialIndex) within(b boundingBox) []string {
+	westCell, eastCell := lonCell(b.West), lonCell(b.East)
+	if b.West <= b.East && b.East-b.West >= 360-gridCellDegree {
+		westCell, eastCell = 0, int(360/gridCellDegree)-1
+	}
+	var uuids []string
+	for _, uuid := range idx.candidates(b.South, b.North, westCell, eastCell) {
+		if b.contains(idx.positions[uuid]) {
+			uuids = append(uuids, uuid)
+		}
+	}
+	return uuids
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func randomLocations(n int) (locationsMap, []string) {
+	r := rand.New(rand.NewSource(42))
+	locations := make(locationsMap, n)
+	order := make([]string, n)
+	for i := 0; i < n; i++ {
+		uuid := fmt.Sprintf("%08d-0000-0000-0000-000000000000", i)
+		c := coordinates{Latitude: r.Float64()*180 - 90, Longitude: r.Float64()*360 - 180}
+		locations[uuid] = location{UUID: uuid, Coordinates: &c}
+		order[i] = uuid
+	}
+	return locations, order
+}
+
+func TestSpatialIndexMatchesBruteForce(t *testing.T) {
+	locations, order := randomLocations(5000)
+	idx := newSpatialIndex(locations, order)
+
+	queries := []struct {
+		centre coordinates
+		radius float64
+	}{
+		{coordinates{Latitude: 51.5, Longitude: -0.12}, 500},
+		{coordinates{Latitude: 0, Longitude: 179.9}, 800},
+		{coordinates{Latitude: -10, Longitude: -179.5}, 300},
+		{coordinates{Latitude: 89.5, Longitude: 10}, 1000},
+		{coordinates{Latitude: -45, Longitude: 0}, 15000},
+		{coordinates{Latitude: 10, Longitude: 10}, 1},
+	}
+	for _, q := range queries {
+		var expected []string
+		for _, uuid := range order {
+			if distanceKm(q.centre, *locations[uuid].Coordinates) <= q.radius {
+				expected = append(expected, uuid)
+			}
+		}
+		var actual []string
+		for _, h := range idx.near(q.centre, q.radius) {
+			actual = append(actual, h.uuid)
+		}
+		assert.ElementsMatch(t, expected, actual, fmt.Sprintf("near %v within %vkm", q.centre, q.radius))
+	}
+
+	boxes := []boundingBox{
+		{South: 40, West: -10, North: 60, East: 20},
+		{South: -20, West: 170, North: 20, East: -170},
+		{South: -90, West: -180, North: 90, East: 180},
+		{South: 10.2, West: 10.2, North: 10.4, East: 10.4},
+	}
+	for _, b := range boxes {
+		var expected []string
+		for _, uuid := range order {
+			if b.contains(*locations[uuid].Coordinates) {
+				expected = append(expected, uuid)
+			}
+		}
+		assert.ElementsMatch(t, expected, idx.within(b), fmt.Sprintf("within %v", b))
+	}
+}
+
+func TestNearIsSortedByDistance(t *testing.T) {
+	locations, order := randomLocations(2000)
+	hits := newSpatialIndex(locations, order).near(coordinates{Latitude: 20, Longitude: 20}, 3000)
+	assert.NotEmpty(t, hits)
+	for i := 1; i < len(hits); i++ {
+		assert.True(t, hits[i-1].distanceKm <= hits[i].distanceKm)
+	}
+}
+
+func TestSpatialIndexUsesBoundingBoxCentre(t *testing.T) {
+	locations := locationsMap{
+		testUUID: {UUID: testUUID, BoundingBox: &boundingBox{South: -1, West: 179, North: 1, East: -179}},
+		"other":  {UUID: "other"},
+	}
+	idx := newSpatialIndex(locations, []string{testUUID, "other"})
+	assert.Equal(t, []string{testUUID}, idx.within(boundingBox{South: -1, West: 179.5, North: 1, East: -179.5}))
+	assert.Len(t, idx.near(coordinates{Latitude: 0, Longitude: -180}, 1), 1)
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	b, err := parseBoundingBox("-1.5, 50,1,52")
+	assert.NoError(t, err)
+	assert.Equal(t, boundingBox{West: -1.5, South: 50, East: 1, North: 52}, b)
+
+	for _, invalid := range []string{"", "1,2,3", "a,50,1,52", "-1,52,1,50", "-1,50,181,52"} {
+		_, err := parseBoundingBox(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCoordinatesFromAttributes(t *testing.T) {
+	c := coordinatesFromAttributes("id", []tmeAttribute{{Name: "Latitude", Value: "48.8566"}, {Name: "Longitude", Value: "2.3522"}})
+	assert.Equal(t, &coordinates{Latitude: 48.8566, Longitude: 2.3522}, c)
+	assert.Nil(t, coordinatesFromAttributes("id", []tmeAttribute{{Name: "latitude", Value: "48.8566"}}))
+	assert.Nil(t, coordinatesFromAttributes("id", []tmeAttribute{{Name: "latitude", Value: "148"}, {Name: "longitude", Value: "2"}}))
+}
+
+func TestGazetteer(t *testing.T) {
+	path := writeTempFile(t, "gazetteer*.csv", "tmeIdentifier,latitude,longitude,south,west,north,east\n"+
+		"MTE3-R0w=,48.8566,2.3522,48.81,2.22,48.90,2.47\n"+
+		"MTg-R0w=,51.5,-0.12,,,,\n")
+	defer os.Remove(path)
+	g, err := newGazetteer(path)
+	assert.NoError(t, err)
+
+	locations := locationsMap{testUUID: getDummyLocation(testUUID, "Paris", "MTE3-R0w=")}
+	issues := g.enrich(glTaxonomy, locations)
+	assert.Equal(t, &coordinates{Latitude: 48.8566, Longitude: 2.3522}, locations[testUUID].Coordinates)
+	assert.Equal(t, &boundingBox{South: 48.81, West: 2.22, North: 48.90, East: 2.47}, locations[testUUID].BoundingBox)
+	assert.Equal(t, []enrichmentIssue{{Source: "gazetteer", Key: "MTg-R0w=", Issue: "No location for gazetteer entry"}}, issues)
+
+	for _, invalid := range []string{"tmeIdentifier,latitude,longitude,south,west,north,east\nMTE3-R0w=,,,,,,\n", "tmeIdentifier,latitude,longitude,south,west,north,east\nMTE3-R0w=,91,0,,,,\n", "tmeIdentifier,latitude,longitude\nMTE3-R0w=,1,0\n"} {
+		path := writeTempFile(t, "gazetteer*.csv", invalid)
+		_, err := readGazetteer(path)
+		os.Remove(path)
+		assert.Error(t, err, invalid)
+	}
+}
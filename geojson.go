@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+const geoJSONContentType = "application/geo+json"
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	BBox       []float64         `json:"bbox,omitempty"`
+	Geometry   *geoJSONGeometry  `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	UUID       string   `json:"uuid"`
+	PrefLabel  string   `json:"prefLabel"`
+	Type       string   `json:"type"`
+	Broader    []string `json:"broader,omitempty"`
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+}
+
+// wantsGeoJSON tells whether the client asked for GeoJSON, through the Accept header or with format=geojson.
+func wantsGeoJSON(req *http.Request) bool {
+	return req.URL.Query().Get("format") == "geojson" || strings.Contains(req.Header.Get("Accept"), geoJSONContentType)
+}
+
+// toFeature places a location at its coordinates, or else covers its bounding box. Locations
+// without either, or whose bounding box crosses the antimeridian, get a null geometry.
+func toFeature(l location) geoJSONFeature {
+	f := geoJSONFeature{
+		Type:       "Feature",
+		ID:         l.UUID,
+		Properties: geoJSONProperties{UUID: l.UUID, PrefLabel: l.PrefLabel, Type: l.Type, Broader: l.BroaderUUIDs},
+	}
+	if b := l.BoundingBox; b != nil {
+		f.BBox = []float64{b.West, b.South, b.East, b.North}
+	}
+	switch {
+	case l.Coordinates != nil:
+		f.Geometry = &geoJSONGeometry{Type: "Point", Coordinates: []float64{l.Coordinates.Longitude, l.Coordinates.Latitude}}
+	case l.BoundingBox != nil && l.BoundingBox.West <= l.BoundingBox.East:
+		b := l.BoundingBox
+		ring := [][]float64{{b.West, b.South}, {b.East, b.South}, {b.East, b.North}, {b.West, b.North}, {b.West, b.South}}
+		f.Geometry = &geoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{ring}}
+	}
+	return f
+}
+
+func writeGeoJSONFeature(l location, found bool, writer http.ResponseWriter, req *http.Request) {
+	if !found {
+		writeProblem(writer, req, notFoundProblem, "")
+		return
+	}
+	writer.Header().Set("Content-Type", geoJSONContentType)
+	if err := json.NewEncoder(writer).Encode(toFeature(l)); err != nil {
+		log.Errorf("Error on geojson encoding=%v\n", err)
+	}
+}
+
+// geoJSONFlushEvery is the number of features streamed between flushes, so clients get large collections as they are
+// written.
+const geoJSONFlushEvery = 1000
+
+// writeGeoJSONCollection streams a FeatureCollection, encoding one feature at a time so large
+// collections are never held in memory as a whole. next returns false once there are no more features.
+func writeGeoJSONCollection(writer http.ResponseWriter, next func() (geoJSONFeature, bool)) {
+	writer.Header().Set("Content-Type", geoJSONContentType)
+	if _, err := fmt.Fprint(writer, `{"type":"FeatureCollection","features":[`); err != nil {
+		log.Warnf("Couldn't write geojson to HTTP response %v\n", err)
+		return
+	}
+	written := 0
+	for f, more := next(); more; f, more = next() {
+		b, err := json.Marshal(f)
+		if err != nil {
+			log.Warnf("Couldn't encode feature with uuid=%s %v\n", f.ID, err)
+			continue
+		}
+		if written > 0 {
+			b = append([]byte(","), b...)
+		}
+		written++
+		if _, err := writer.Write(b); err != nil {
+			log.Warnf("Couldn't write geojson to HTTP response %v\n", err)
+			return
+		}
+		if f, ok := writer.(http.Flusher); ok && written%geoJSONFlushEvery == 0 {
+			f.Flush()
+		}
+	}
+	fmt.Fprintln(writer, "]}")
+}
+
+func locationFeatures(locations []location) func() (geoJSONFeature, bool) {
+	i := 0
+	return func() (geoJSONFeature, bool) {
+		if i >= len(locations) {
+			return geoJSONFeature{}, false
+		}
+		i++
+		return toFeature(locations[i-1]), true
+	}
+}
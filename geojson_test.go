@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestToFeature(t *testing.T) {
+	tests := []struct {
+		name     string
+		location location
+		geometry *geoJSONGeometry
+		bbox     []float64
+	}{
+		{"Point", location{UUID: testUUID, Coordinates: &coordinates{Latitude: 48.8566, Longitude: 2.3522}, BoundingBox: &boundingBox{South: 48.81, West: 2.22, North: 48.90, East: 2.47}},
+			&geoJSONGeometry{Type: "Point", Coordinates: []float64{2.3522, 48.8566}}, []float64{2.22, 48.81, 2.47, 48.90}},
+		{"Polygon", location{UUID: testUUID, BoundingBox: &boundingBox{South: 1, West: 2, North: 3, East: 4}},
+			&geoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{{{2, 1}, {4, 1}, {4, 3}, {2, 3}, {2, 1}}}}, []float64{2, 1, 4, 3}},
+		{"Antimeridian", location{UUID: testUUID, BoundingBox: &boundingBox{South: -1, West: 179, North: 1, East: -179}},
+			nil, []float64{179, -1, -179, 1}},
+		{"No geography", location{UUID: testUUID}, nil, nil},
+	}
+	for _, test := range tests {
+		f := toFeature(test.location)
+		assert.Equal(t, "Feature", f.Type, test.name)
+		assert.Equal(t, testUUID, f.ID, test.name)
+		assert.Equal(t, test.geometry, f.Geometry, test.name)
+		assert.Equal(t, test.bbox, f.BBox, test.name)
+	}
+}
+
+func TestWriteGeoJSONCollectionIsValidJSON(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		locations := make([]location, n)
+		for i := range locations {
+			locations[i] = location{UUID: testUUID, Coordinates: &coordinates{Latitude: float64(i), Longitude: float64(i)}}
+		}
+		rec := httptest.NewRecorder()
+		writeGeoJSONCollection(rec, locationFeatures(locations))
+
+		var collection struct {
+			Type     string           `json:"type"`
+			Features []geoJSONFeature `json:"features"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+		assert.Equal(t, "FeatureCollection", collection.Type)
+		assert.Len(t, collection.Features, n)
+		assert.Equal(t, geoJSONContentType, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+)
+
+// snapshotGuard decides whether a freshly loaded snapshot is sane enough to replace the current one.
+// A zero value accepts everything.
+type snapshotGuard struct {
+	minCount       int
+	maxDropPercent int
+}
+
+func (g snapshotGuard) check(current int, candidate int) error {
+	if candidate < g.minCount {
+		return fmt.Errorf("Loaded %d locations, which is below the minimum of %d", candidate, g.minCount)
+	}
+	if g.maxDropPercent <= 0 || current == 0 || candidate >= current {
+		return nil
+	}
+	drop := (current - candidate) * 100 / current
+	if drop > g.maxDropPercent {
+		return fmt.Errorf("Loaded %d locations against %d currently served, a drop of %d%% which exceeds the maximum of %d%%", candidate, current, drop, g.maxDropPercent)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSnapshotGuard(t *testing.T) {
+	tests := []struct {
+		name      string
+		guard     snapshotGuard
+		current   int
+		candidate int
+		rejected  bool
+	}{
+		{"Disabled", snapshotGuard{}, 1000, 1, false},
+		{"Below minimum", snapshotGuard{minCount: 10}, 0, 9, true},
+		{"At minimum", snapshotGuard{minCount: 10}, 0, 10, false},
+		{"Drop within threshold", snapshotGuard{maxDropPercent: 20}, 1000, 800, false},
+		{"Drop over threshold", snapshotGuard{maxDropPercent: 20}, 1000, 790, true},
+		{"Growth", snapshotGuard{maxDropPercent: 20}, 1000, 5000, false},
+		{"Nothing served yet", snapshotGuard{maxDropPercent: 20}, 0, 1, false},
+	}
+
+	for _, test := range tests {
+		err := test.guard.check(test.current, test.candidate)
+		assert.Equal(t, test.rejected, err != nil, fmt.Sprintf("%s: Unexpected guard result %v", test.name, err))
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,31 +10,61 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	maxRadiusKm     = 20000
+	defaultGeoLimit = 100
+	maxGeoLimit     = 1000
 )
 
 type locationsHandler struct {
-	service locationService
+	service  locationService
+	taxonomy taxonomyConfig
+	auth     *authenticator
+	limits   *limiter
+	// health holds the healthPolicy, which may change on SIGHUP.
+	health atomic.Value
 }
 
 // HealthCheck does something
 func (h *locationsHandler) HealthCheck() v1a.Check {
 	return v1a.Check{
 		BusinessImpact:   "Unable to respond to request for the location data from TME",
-		Name:             "Check connectivity to TME",
-		PanicGuide:       "https://sites.google.com/a/ft.com/ft-technology-service-transition/home/run-book-library/locations-transfomer",
+		Name:             fmt.Sprintf("Check connectivity to TME for taxonomy %s", h.taxonomy.name),
+		PanicGuide:       panicGuide,
 		Severity:         1,
 		TechnicalSummary: "Cannot connect to TME to be able to supply locations",
 		Checker:          h.checker,
 	}
 }
 
+func (h *locationsHandler) SnapshotCheck() v1a.Check {
+	return v1a.Check{
+		BusinessImpact:   "Locations served may be out of date as the latest load from TME was rejected",
+		Name:             fmt.Sprintf("Check the size of the latest locations load for taxonomy %s", h.taxonomy.name),
+		PanicGuide:       panicGuide,
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("The latest load from TME contained far fewer locations than expected and was not applied. If the drop is genuine, force apply it with POST %s/__force-apply", h.taxonomy.routePrefix),
+		Checker:          h.snapshotChecker,
+	}
+}
+
 func (h *locationsHandler) G2GCheck() gtg.Status {
 	count := h.service.getLocationCount()
 	if count > 0 {
 		return gtg.Status{GoodToGo: true}
 	}
-	return gtg.Status{GoodToGo: false}
+	return gtg.Status{GoodToGo: false, Message: fmt.Sprintf("No locations loaded for taxonomy %s", h.taxonomy.name)}
+}
+
+// Checks returns every healthcheck of the taxonomy served by this handler.
+func (h *locationsHandler) Checks() []v1a.Check {
+	return append([]v1a.Check{h.HealthCheck(), h.SnapshotCheck()}, h.dataChecks()...)
 }
 
 func (h *locationsHandler) checker() (string, error) {
@@ -43,38 +74,130 @@ func (h *locationsHandler) checker() (string, error) {
 	return "Connectivity to TME is ok", nil
 }
 
-func newLocationsHandler(service locationService) locationsHandler {
-	return locationsHandler{service: service}
+func (h *locationsHandler) snapshotChecker() (string, error) {
+	if ls := h.service.getLoadStatus(); ls == RejectedData {
+		return "Latest load was rejected", errors.New(h.service.getRejection())
+	}
+	return "Latest load was applied", nil
+}
+
+func newLocationsHandler(service locationService, taxonomy taxonomyConfig, auth *authenticator, limits *limiter, health healthPolicy) locationsHandler {
+	h := locationsHandler{service: service, taxonomy: taxonomy, auth: auth, limits: limits}
+	h.health.Store(health)
+	return h
+}
+
+func (h *locationsHandler) registerRoutes(m *mux.Router) {
+	prefix := h.taxonomy.routePrefix
+	m.HandleFunc(prefix, h.route("list", readRole, h.whenLoaded(h.getLocations))).Methods("GET")
+	m.HandleFunc(prefix+"/__count", h.route("count", readRole, h.whenLoaded(h.getCount))).Methods("GET")
+	m.HandleFunc(prefix+"/__ids", h.route("ids", readRole, h.whenLoaded(h.getIds))).Methods("GET")
+	m.HandleFunc(prefix+"/__dump", h.route("dump", readRole, h.whenLoaded(h.getDump))).Methods("GET")
+	m.HandleFunc(prefix+"/__duplicates", h.route("duplicates", readRole, h.getDuplicates)).Methods("GET")
+	m.HandleFunc(prefix+"/__enrichment-issues", h.route("enrichment-issues", readRole, h.getEnrichmentIssues)).Methods("GET")
+	m.HandleFunc(prefix+"/__lookup", h.route("lookup", readRole, h.whenLoaded(h.lookup))).Methods("GET")
+	m.HandleFunc(prefix+"/__deprecated", h.route("deprecated", readRole, h.getDeprecations)).Methods("GET")
+	m.HandleFunc(prefix+"/near", h.route("near", readRole, h.whenLoaded(h.getLocationsNear))).Methods("GET")
+	m.HandleFunc(prefix+"/within", h.route("within", readRole, h.whenLoaded(h.getLocationsWithin))).Methods("GET")
+	m.HandleFunc(prefix+"/search", h.route("search", readRole, h.whenLoaded(h.search))).Methods("GET")
+	m.HandleFunc(prefix+"/__reload", h.route("reload", adminRole, h.reload)).Methods("POST")
+	m.HandleFunc(prefix+"/__force-apply", h.route("force-apply", adminRole, h.forceApply)).Methods("POST")
+	m.HandleFunc(prefix+"/{uuid:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}", h.route("location", readRole, h.whenLoaded(h.getLocationByUUID))).Methods("GET")
+}
+
+// route times a route and lets through only requests within the limits of the route, and then only those with the
+// given role. Limiting first means requests with a wrong key are limited too, so keys cannot be guessed at speed.
+func (h *locationsHandler) route(name string, r role, next http.HandlerFunc) http.HandlerFunc {
+	return timed("routes."+h.taxonomy.name+"."+name+".latency", h.limits.limit(name, h.auth.require(r, next)))
+}
+
+// whenLoaded answers requests for locations with a not-loaded problem until the first load from TME has completed,
+// so clients can tell a location that does not exist from one that is not there yet.
+func (h *locationsHandler) whenLoaded(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if !h.loaded() {
+			writeProblem(writer, req, notLoadedProblem, fmt.Sprintf("Locations of taxonomy %s have not been loaded from TME yet", h.taxonomy.name))
+			return
+		}
+		next(writer, req)
+	}
+}
+
+func (h *locationsHandler) loaded() bool {
+	switch h.service.getLoadStatus() {
+	case DataLoaded, RejectedData:
+		return true
+	}
+	return h.service.getLocationCount() > 0
 }
 
 func (h *locationsHandler) getLocations(writer http.ResponseWriter, req *http.Request) {
-	obj, found := h.service.getLocations()
-	writeJSONResponse(obj, found, writer)
+	if notModified(writer, req, h.service.getSnapshotVersion()) {
+		return
+	}
+	if wantsGeoJSON(req) {
+		h.getLocationsGeoJSON(writer, req)
+		return
+	}
+	locationType := req.URL.Query().Get("type")
+	if p, found := h.service.getPayload(listPayload); found && locationType == "" && h.service.getLocationCount() > 0 {
+		p.write(writer, req)
+		return
+	}
+	obj, found := h.service.getLocations(locationType)
+	writeJSONResponse(obj, found, writer, req)
+}
+
+func (h *locationsHandler) getLocationsGeoJSON(writer http.ResponseWriter, req *http.Request) {
+	ids := h.service.getLocationIds(req.URL.Query().Get("type"))
+	if len(ids) == 0 {
+		writeProblem(writer, req, notFoundProblem, "No locations of this type")
+		return
+	}
+	prefs := languages(writer, req)
+	i := 0
+	writeGeoJSONCollection(writer, func() (geoJSONFeature, bool) {
+		for i < len(ids) {
+			l, found := h.service.getLocationByUUID(ids[i])
+			i++
+			if found {
+				return toFeature(prefs.localise(l)), true
+			}
+		}
+		return geoJSONFeature{}, false
+	})
 }
 
 func (h *locationsHandler) getCount(writer http.ResponseWriter, req *http.Request) {
+	if notModified(writer, req, h.service.getSnapshotVersion()) {
+		return
+	}
 	count := h.service.getLocationCount()
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	_, err := writer.Write([]byte(strconv.Itoa(count)))
 	if err != nil {
 		log.Warnf("Couldn't write count to HTTP response. count=%d %v\n", count, err)
-		writer.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 func (h *locationsHandler) getIds(writer http.ResponseWriter, req *http.Request) {
-	ids := h.service.getLocationIds()
+	if notModified(writer, req, h.service.getSnapshotVersion()) {
+		return
+	}
+	locationType := req.URL.Query().Get("type")
+	if p, found := h.service.getPayload(idsPayload); found && locationType == "" {
+		p.write(writer, req)
+		return
+	}
+	ids := h.service.getLocationIds(locationType)
 	writer.Header().Add("Content-Type", "text/plain")
 	if len(ids) == 0 {
 		writer.WriteHeader(http.StatusOK)
 		return
 	}
 	enc := json.NewEncoder(writer)
-	type locationId struct {
-		ID string `json:"id"`
-	}
 	for _, id := range ids {
-		rID := locationId{ID: id}
-		err := enc.Encode(rID)
+		err := enc.Encode(locationID{ID: id})
 		if err != nil {
 			log.Warnf("Couldn't encode to HTTP response location with uuid=%s %v\n", id, err)
 			continue
@@ -82,24 +205,255 @@ func (h *locationsHandler) getIds(writer http.ResponseWriter, req *http.Request)
 	}
 }
 
+// getDump writes every location, or every location of a type, one JSON object per line in uuid order.
+func (h *locationsHandler) getDump(writer http.ResponseWriter, req *http.Request) {
+	if notModified(writer, req, h.service.getSnapshotVersion()) {
+		return
+	}
+	locationType := req.URL.Query().Get("type")
+	if p, found := h.service.getPayload(dumpPayload); found && locationType == "" {
+		p.write(writer, req)
+		return
+	}
+	uuids := h.service.getLocationIds(locationType)
+	sort.Strings(uuids)
+	writer.Header().Add("Content-Type", "text/plain")
+	enc := json.NewEncoder(writer)
+	for _, uuid := range uuids {
+		l, found := h.service.getLocationByUUID(uuid)
+		if !found {
+			continue
+		}
+		if err := enc.Encode(l); err != nil {
+			log.Warnf("Couldn't encode to HTTP response location with uuid=%s %v\n", uuid, err)
+		}
+	}
+}
+
+func (h *locationsHandler) getDuplicates(writer http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(h.service.getDuplicates(), true, writer, req)
+}
+
+func (h *locationsHandler) getEnrichmentIssues(writer http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(h.service.getEnrichmentIssues(), true, writer, req)
+}
+
+func (h *locationsHandler) getDeprecations(writer http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(h.service.getDeprecations(), true, writer, req)
+}
+
+func (h *locationsHandler) lookup(writer http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	obj, found := h.service.getLocationByIdentifier(q.Get("authority"), q.Get("identifierValue"))
+	if found && h.notModified(writer, req, obj.UUID) {
+		return
+	}
+	writeLocation(obj, found, writer, req)
+}
+
+func (h *locationsHandler) getLocationsNear(writer http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	c, err := parseCoordinates(q.Get("lat"), q.Get("lon"))
+	if err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	radius, err := strconv.ParseFloat(q.Get("radius"), 64)
+	if err != nil || radius <= 0 || radius > maxRadiusKm {
+		writeProblem(writer, req, invalidRequestProblem, fmt.Sprintf("radius must be a number of kilometres between 0 and %d", maxRadiusKm))
+		return
+	}
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	nearby := h.service.getLocationsNear(c, radius, limit)
+	prefs := languages(writer, req)
+	for i := range nearby {
+		nearby[i].location = prefs.localise(nearby[i].location)
+	}
+	if wantsGeoJSON(req) {
+		i := 0
+		writeGeoJSONCollection(writer, func() (geoJSONFeature, bool) {
+			if i >= len(nearby) {
+				return geoJSONFeature{}, false
+			}
+			f := toFeature(nearby[i].location)
+			f.Properties.DistanceKm = &nearby[i].DistanceKm
+			i++
+			return f, true
+		})
+		return
+	}
+	writeJSONResponse(nearby, true, writer, req)
+}
+
+func (h *locationsHandler) getLocationsWithin(writer http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	b, err := parseBoundingBox(q.Get("bbox"))
+	if err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	writeLocations(h.service.getLocationsWithin(b, limit), writer, req)
+}
+
+func (h *locationsHandler) search(writer http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		writeProblem(writer, req, invalidRequestProblem, "q must not be empty")
+		return
+	}
+	limit, err := parseLimit(q.Get("limit"))
+	if err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	writeLocations(h.service.searchLocations(query, q.Get("type"), limit), writer, req)
+}
+
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return defaultGeoLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxGeoLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxGeoLimit)
+	}
+	return limit, nil
+}
+
 func (h *locationsHandler) reload(writer http.ResponseWriter, req *http.Request) {
-	writer.Header().Add("Content-Type", "application/json")
 	st := h.service.getLoadStatus()
 	if st == NotInit {
-		writeJSONError(writer, "Service Unavailable", http.StatusServiceUnavailable)
+		writeProblem(writer, req, notLoadedProblem, fmt.Sprintf("The service for taxonomy %s is still starting", h.taxonomy.name))
 		return
 	}
 	if st == LoadingData {
-		writeJSONError(writer, "Currently Loading Data", http.StatusConflict)
+		writeProblem(writer, req, reloadInProgressProblem, fmt.Sprintf("Locations of taxonomy %s are being loaded from TME", h.taxonomy.name))
 		return
 	}
+	// The reload outlives the request, so it only keeps its transaction id.
+	ctx := withTransactionID(context.Background(), transactionID(writer, req))
 	go func() {
-		err := h.service.reload()
+		err := h.service.reloadContext(ctx)
 		if err != nil {
 			log.Warnf("Problem reloading terms from TME: %v", err)
 		}
 	}()
-	writeJSONError(writer, "Reloading people", http.StatusAccepted)
+	writeJSONMessage(writer, "Reloading locations", http.StatusAccepted)
+}
+
+func (h *locationsHandler) forceApply(writer http.ResponseWriter, req *http.Request) {
+	if err := h.service.forceApply(); err != nil {
+		writeProblem(writer, req, nothingToApplyProblem, err.Error())
+		return
+	}
+	writeJSONMessage(writer, "Rejected locations applied", http.StatusOK)
+}
+
+// overridesHandler serves the admin API of the overrides shared by every taxonomy. Changes are applied at once by
+// refreshing each service from the terms it last loaded. Reading the overrides needs the admin role too, as they name
+// their editors and give their reasons.
+type overridesHandler struct {
+	store    *overrideStore
+	services []locationService
+	auth     *authenticator
+	limits   *limiter
+}
+
+func newOverridesHandler(store *overrideStore, services []locationService, auth *authenticator, limits *limiter) overridesHandler {
+	return overridesHandler{store: store, services: services, auth: auth, limits: limits}
+}
+
+func (h *overridesHandler) registerRoutes(m *mux.Router) {
+	m.HandleFunc("/__overrides", h.limits.limit("overrides", h.auth.require(adminRole, h.list))).Methods("GET")
+	m.HandleFunc("/__overrides/__audit", h.limits.limit("overrides", h.auth.require(adminRole, h.audit))).Methods("GET")
+	m.HandleFunc("/__overrides/{uuid:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}", h.limits.limit("overrides", h.auth.require(adminRole, h.get))).Methods("GET")
+	m.HandleFunc("/__overrides/{uuid:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}", h.limits.limit("overrides", h.auth.require(adminRole, h.set))).Methods("PUT")
+	m.HandleFunc("/__overrides/{uuid:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}}", h.limits.limit("overrides", h.auth.require(adminRole, h.remove))).Methods("DELETE")
+}
+
+func (h *overridesHandler) list(writer http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(h.store.list(), true, writer, req)
+}
+
+func (h *overridesHandler) audit(writer http.ResponseWriter, req *http.Request) {
+	writeJSONResponse(h.store.history(), true, writer, req)
+}
+
+func (h *overridesHandler) get(writer http.ResponseWriter, req *http.Request) {
+	obj, found := h.store.get(mux.Vars(req)["uuid"])
+	writeJSONResponse(obj, found, writer, req)
+}
+
+func (h *overridesHandler) set(writer http.ResponseWriter, req *http.Request) {
+	var o override
+	if err := json.NewDecoder(req.Body).Decode(&o); err != nil {
+		writeProblem(writer, req, invalidRequestProblem, fmt.Sprintf("Invalid override: %v", err))
+		return
+	}
+	o.UUID = mux.Vars(req)["uuid"]
+	o.Author = h.author(req)
+	if err := o.validate(); err != nil {
+		writeProblem(writer, req, invalidRequestProblem, err.Error())
+		return
+	}
+	if err := h.store.set(o); err != nil {
+		log.Errorf("Could not save override of location with uuid=%s: %v", o.UUID, err)
+		writeProblem(writer, req, internalProblem, "Could not save override")
+		return
+	}
+	h.refresh()
+	obj, _ := h.store.get(o.UUID)
+	writeJSONResponse(obj, true, writer, req)
+}
+
+func (h *overridesHandler) remove(writer http.ResponseWriter, req *http.Request) {
+	var change overrideChange
+	if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+		writeProblem(writer, req, invalidRequestProblem, fmt.Sprintf("Invalid removal: %v", err))
+		return
+	}
+	if change.Reason == "" {
+		writeProblem(writer, req, invalidRequestProblem, "removing an override needs a reason")
+		return
+	}
+	change.Author = h.author(req)
+	uuid := mux.Vars(req)["uuid"]
+	err := h.store.remove(uuid, change)
+	if err == errNoOverride {
+		writeProblem(writer, req, notFoundProblem, err.Error())
+		return
+	}
+	if err != nil {
+		log.Errorf("Could not remove override of location with uuid=%s: %v", uuid, err)
+		writeProblem(writer, req, internalProblem, "Could not remove override")
+		return
+	}
+	h.refresh()
+	writeJSONMessage(writer, "Override removed", http.StatusOK)
+}
+
+// author names the API key of a change, so the audit trail records who made it whatever the body says.
+func (h *overridesHandler) author(req *http.Request) string {
+	c, _, _ := h.auth.identify(req)
+	return c.name
+}
+
+func (h *overridesHandler) refresh() {
+	for _, s := range h.services {
+		if err := s.refresh(); err != nil {
+			log.Warnf("Problem applying overrides: %v", err)
+		}
+	}
 }
 
 func (h *locationsHandler) getLocationByUUID(writer http.ResponseWriter, req *http.Request) {
@@ -107,26 +461,90 @@ func (h *locationsHandler) getLocationByUUID(writer http.ResponseWriter, req *ht
 	uuid := vars["uuid"]
 
 	obj, found := h.service.getLocationByUUID(uuid)
-	writeJSONResponse(obj, found, writer)
+	if !found {
+		if dl, deprecated := h.service.getDeprecation(uuid); deprecated {
+			h.writeDeprecation(dl, writer, req)
+			return
+		}
+	}
+	if found && h.notModified(writer, req, obj.UUID) {
+		return
+	}
+	writeLocation(obj, found, writer, req)
+}
+
+// notModified answers a conditional request for a single location, when the client already has its current version.
+func (h *locationsHandler) notModified(writer http.ResponseWriter, req *http.Request, uuid string) bool {
+	v, found := h.service.getLocationVersion(uuid)
+	return found && notModified(writer, req, v)
 }
 
-func writeJSONResponse(obj interface{}, found bool, writer http.ResponseWriter) {
+// writeDeprecation redirects requests for a merged location to the one it was merged into, describing the merge in the body,
+// and answers those for a retired location with a retired problem.
+func (h *locationsHandler) writeDeprecation(dl deprecatedLocation, writer http.ResponseWriter, req *http.Request) {
+	if dl.Status != mergedStatus {
+		p := newProblem(writer, req, retiredProblem, fmt.Sprintf("Location with uuid=%s was retired from TME", dl.UUID))
+		p.Deprecation = &dl
+		p.write(writer)
+		return
+	}
 	writer.Header().Add("Content-Type", "application/json")
+	writer.Header().Set("Location", h.taxonomy.baseURL+dl.MergedInto)
+	writer.WriteHeader(http.StatusMovedPermanently)
+	if err := json.NewEncoder(writer).Encode(dl); err != nil {
+		log.Errorf("Error on json encoding=%v\n", err)
+	}
+}
+
+// languages reads the languages the client prefers, and tells caches the response depends on them.
+func languages(writer http.ResponseWriter, req *http.Request) languagePreferences {
+	varyOnRepresentation(writer)
+	return requestedLanguages(req)
+}
+
+// varyOnRepresentation tells caches that responses depend on the headers choosing GeoJSON and the language of labels.
+func varyOnRepresentation(writer http.ResponseWriter) {
+	addVary(writer.Header(), "Accept")
+	addVary(writer.Header(), "Accept-Language")
+}
+
+// writeLocation writes a location as JSON or GeoJSON, with the prefLabel in the language the client prefers.
+func writeLocation(obj location, found bool, writer http.ResponseWriter, req *http.Request) {
+	prefs := languages(writer, req)
+	if found {
+		var language string
+		obj.PrefLabel, language = prefs.prefLabel(obj)
+		if language != "" {
+			writer.Header().Set("Content-Language", language)
+		}
+	}
+	if wantsGeoJSON(req) {
+		writeGeoJSONFeature(obj, found, writer, req)
+		return
+	}
+	writeJSONResponse(obj, found, writer, req)
+}
+
+func writeLocations(locations []location, writer http.ResponseWriter, req *http.Request) {
+	locations = languages(writer, req).localiseAll(locations)
+	if wantsGeoJSON(req) {
+		writeGeoJSONCollection(writer, locationFeatures(locations))
+		return
+	}
+	writeJSONResponse(locations, true, writer, req)
+}
 
+func writeJSONResponse(obj interface{}, found bool, writer http.ResponseWriter, req *http.Request) {
 	if !found {
-		writer.WriteHeader(http.StatusNotFound)
+		writeProblem(writer, req, notFoundProblem, "")
 		return
 	}
 
+	writer.Header().Add("Content-Type", "application/json")
 	enc := json.NewEncoder(writer)
 	if err := enc.Encode(obj); err != nil {
 		log.Errorf("Error on json encoding=%v\n", err)
-		writeJSONError(writer, err.Error(), http.StatusInternalServerError)
+		writeProblem(writer, req, internalProblem, "Could not encode the response")
 		return
 	}
 }
-
-func writeJSONError(w http.ResponseWriter, errorMsg string, statusCode int) {
-	w.WriteHeader(statusCode)
-	fmt.Fprintln(w, fmt.Sprintf("{\"message\": \"%s\"}", errorMsg))
-}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/Financial-Times/go-fthealth/v1a"
 	"github.com/Financial-Times/service-status-go/gtg"
@@ -12,14 +13,33 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
-	testUUID                  = "bba39990-c78d-3629-ae83-808c333c6dbc"
-	getLocationsResponse      = `[{"apiUrl":"http://localhost:8080/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc"}]`
-	getLocationByUUIDResponse = `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{"TME":["MTE3-U3ViamVjdHM="],"uuids":["bba39990-c78d-3629-ae83-808c333c6dbc"]},"prefLabel":"SomeLocation","type":"Location"}`
-	getLocationsCountResponse = `1`
-	getLocationsIdsResponse   = `{"id":"bba39990-c78d-3629-ae83-808c333c6dbc"}`
+	testAdminToken                 = "secret"
+	testReadKey                    = "reader-secret"
+	testTaxonomyName               = "GL"
+	testUUID                       = "bba39990-c78d-3629-ae83-808c333c6dbc"
+	getLocationsResponse           = `[{"apiUrl":"http://localhost:8080/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc"}]`
+	getLocationByUUIDResponse      = `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{"TME":["MTE3-U3ViamVjdHM="],"uuids":["bba39990-c78d-3629-ae83-808c333c6dbc"]},"prefLabel":"SomeLocation","type":"Location","types":["Thing","Concept","Location"]}`
+	getLocationsCountResponse      = `1`
+	getLocationsIdsResponse        = `{"id":"bba39990-c78d-3629-ae83-808c333c6dbc"}`
+	munichInFrench                 = `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{},"prefLabel":"Munich en Bavière","prefLabels":{"de":"München","fr":"Munich en Bavière"},"type":"City"}`
+	parisFeature                   = `{"type":"Feature","id":"bba39990-c78d-3629-ae83-808c333c6dbc","geometry":{"type":"Point","coordinates":[2.3522,48.8566]},"properties":{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","prefLabel":"Paris","type":"City"}}`
+	getLocationsDuplicatesResponse = `[{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","tmeIdentifiers":["MTE3-R0w=","MTE3-R0w="],"kept":"MTE3-R0w="}]`
+)
+
+var testTaxonomy = taxonomyConfig{name: testTaxonomyName, locationType: "Location", routePrefix: "/transformers/locations", baseURL: "http://localhost:8080/transformers/locations/"}
+
+var testAuth = &authenticator{credentials: []credential{{name: "reader", role: readRole, key: testReadKey}, {name: "admin-token", role: adminRole, key: testAdminToken}}, anonymousRead: true}
+var testLimits = &limiter{auth: testAuth, now: time.Now}
+
+var (
+	paris  = location{UUID: testUUID, PrefLabel: "Paris", Type: "City", Coordinates: &coordinates{Latitude: 48.8566, Longitude: 2.3522}}
+	munich = location{UUID: testUUID, PrefLabel: "Munich", PrefLabels: map[string]string{"de": "München", "fr": "Munich en Bavière"}, Type: "City"}
+	since  = time.Date(2016, 10, 19, 10, 0, 0, 0, time.UTC)
+	london = location{UUID: "e559b6c0-2241-35b9-b970-e55cb8be4cba", PrefLabel: "London", Type: "City", Coordinates: &coordinates{Latitude: 51.5074, Longitude: -0.1278}}
 )
 
 func TestHandlers(t *testing.T) {
@@ -32,24 +52,60 @@ func TestHandlers(t *testing.T) {
 		body         string
 	}{
 		{"Success - get location by uuid", newRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: true, locations: []location{getDummyLocation(testUUID, "SomeLocation", "MTE3-U3ViamVjdHM=")}}, http.StatusOK, "application/json", getLocationByUUIDResponse},
-		{"Not found - get location by uuid", newRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: false, locations: []location{{}}}, http.StatusNotFound, "application/json", ""},
+		{"Not found - get location by uuid", newRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: false, locations: []location{{}}}, http.StatusNotFound, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-found","title":"Not found","status":404,"instance":"/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc"}`},
 		{"Success - get locations", newRequest("GET", "/transformers/locations"), &dummyService{found: true, locations: []location{{UUID: testUUID}}}, http.StatusOK, "application/json", getLocationsResponse},
-		{"Not found - get locations", newRequest("GET", "/transformers/locations"), &dummyService{found: false, locations: []location{}}, http.StatusNotFound, "application/json", ""},
+		{"Not found - get locations", newRequest("GET", "/transformers/locations"), &dummyService{found: false, locations: []location{}}, http.StatusNotFound, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-found","title":"Not found","status":404,"instance":"/transformers/locations"}`},
+		{"Not loaded - get locations", newRequest("GET", "/transformers/locations"), &dummyService{found: false, dataLoaded: LoadingData}, http.StatusServiceUnavailable, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-loaded","title":"Locations not loaded yet","status":503,"detail":"Locations of taxonomy GL have not been loaded from TME yet","instance":"/transformers/locations"}`},
+		{"Not loaded - get location by uuid", newRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: false, dataLoaded: ErrorLoadingData}, http.StatusServiceUnavailable, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-loaded","title":"Locations not loaded yet","status":503,"detail":"Locations of taxonomy GL have not been loaded from TME yet","instance":"/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc"}`},
+		{"Success - get locations by type", newRequest("GET", "/transformers/locations?type=Country"), &dummyService{found: true, locations: []location{{UUID: testUUID, Types: []string{"Thing", "Concept", "Location", "Country"}}, {UUID: "e559b6c0-2241-35b9-b970-e55cb8be4cba", Types: []string{"Thing", "Concept", "Location", "City"}}}}, http.StatusOK, "application/json", getLocationsResponse},
+		{"Test Location Ids by type", newRequest("GET", "/transformers/locations/__ids?type=Country"), &dummyService{found: true, locations: []location{{UUID: testUUID, Types: []string{"Thing", "Concept", "Location", "Country"}}, {UUID: "e559b6c0-2241-35b9-b970-e55cb8be4cba", Types: []string{"Thing", "Concept", "Location", "City"}}}}, http.StatusOK, "text/plain", getLocationsIdsResponse},
 		{"Test Location Count", newRequest("GET", "/transformers/locations/__count"), &dummyService{found: true, locations: []location{{UUID: testUUID}}}, http.StatusOK, "text/plain", getLocationsCountResponse},
 		{"Test Location Ids", newRequest("GET", "/transformers/locations/__ids"), &dummyService{found: true, locations: []location{{UUID: testUUID}}}, http.StatusOK, "text/plain", getLocationsIdsResponse},
+		{"Test Location Duplicates", newRequest("GET", "/transformers/locations/__duplicates"), &dummyService{duplicates: []duplicateLocation{{UUID: testUUID, TMEIdentifiers: []string{"MTE3-R0w=", "MTE3-R0w="}, Kept: "MTE3-R0w="}}}, http.StatusOK, "application/json", getLocationsDuplicatesResponse},
+		{"Test Location Duplicates - None", newRequest("GET", "/transformers/locations/__duplicates"), &dummyService{duplicates: []duplicateLocation{}}, http.StatusOK, "application/json", "[]"},
+		{"Test Enrichment Issues", newRequest("GET", "/transformers/locations/__enrichment-issues"), &dummyService{issues: []enrichmentIssue{{Source: "concordance", Key: "MTE3-R0w=", Issue: "No location for GeoNames 2988507"}}}, http.StatusOK, "application/json", `[{"source":"concordance","key":"MTE3-R0w=","issue":"No location for GeoNames 2988507"}]`},
+		{"Lookup - Found", newRequest("GET", "/transformers/locations/__lookup?authority=GeoNames&identifierValue=2988507"), &dummyService{found: true, locations: []location{{UUID: testUUID, AlternativeIdentifiers: alternativeIdentifiers{GeoNames: []string{"2988507"}}}}}, http.StatusOK, "application/json", `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{"geonames":["2988507"]},"prefLabel":"","type":""}`},
+		{"Lookup - Not found", newRequest("GET", "/transformers/locations/__lookup?authority=GeoNames&identifierValue=1"), &dummyService{found: true, locations: []location{{UUID: testUUID, AlternativeIdentifiers: alternativeIdentifiers{GeoNames: []string{"2988507"}}}}}, http.StatusNotFound, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-found","title":"Not found","status":404,"instance":"/transformers/locations/__lookup"}`},
+		{"Near", newRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=10"), &dummyService{locations: []location{paris, london}}, http.StatusOK, "application/json", `[{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{},"prefLabel":"Paris","type":"City","coordinates":{"latitude":48.8566,"longitude":2.3522},"distanceKm":0.75}]`},
+		{"Near - Bad radius", newRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=-1"), &dummyService{}, http.StatusBadRequest, problemContentType, `{"type":"urn:ft:locations-transformer:problem:invalid-request","title":"Invalid request","status":400,"detail":"radius must be a number of kilometres between 0 and 20000","instance":"/transformers/locations/near"}`},
+		{"Near - Bad latitude", newRequest("GET", "/transformers/locations/near?lat=98.85&lon=2.35&radius=1"), &dummyService{}, http.StatusBadRequest, problemContentType, `{"type":"urn:ft:locations-transformer:problem:invalid-request","title":"Invalid request","status":400,"detail":"latitude 98.85 is not between -90 and 90","instance":"/transformers/locations/near"}`},
+		{"Within", newRequest("GET", "/transformers/locations/within?bbox=-1,50,1,52&limit=5"), &dummyService{locations: []location{paris, london}}, http.StatusOK, "application/json", `[{"uuid":"e559b6c0-2241-35b9-b970-e55cb8be4cba","alternativeIdentifiers":{},"prefLabel":"London","type":"City","coordinates":{"latitude":51.5074,"longitude":-0.1278}}]`},
+		{"Within - Bad limit", newRequest("GET", "/transformers/locations/within?bbox=-1,50,1,52&limit=0"), &dummyService{}, http.StatusBadRequest, problemContentType, `{"type":"urn:ft:locations-transformer:problem:invalid-request","title":"Invalid request","status":400,"detail":"limit must be between 1 and 1000","instance":"/transformers/locations/within"}`},
+		{"GeoJSON - get location by uuid", newGeoJSONRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: true, locations: []location{paris}}, http.StatusOK, geoJSONContentType, parisFeature},
+		{"GeoJSON - Not found", newGeoJSONRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)), &dummyService{found: false, locations: []location{{}}}, http.StatusNotFound, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-found","title":"Not found","status":404,"instance":"/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc"}`},
+		{"GeoJSON - get locations", newRequest("GET", "/transformers/locations?format=geojson"), &dummyService{found: true, locations: []location{paris}}, http.StatusOK, geoJSONContentType, `{"type":"FeatureCollection","features":[` + parisFeature + `]}`},
+		{"GeoJSON - Lookup", newGeoJSONRequest("GET", "/transformers/locations/__lookup?authority=GeoNames&identifierValue=2988507"), &dummyService{found: true, locations: []location{{UUID: testUUID, AlternativeIdentifiers: alternativeIdentifiers{GeoNames: []string{"2988507"}}}}}, http.StatusOK, geoJSONContentType, `{"type":"Feature","id":"bba39990-c78d-3629-ae83-808c333c6dbc","geometry":null,"properties":{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","prefLabel":"","type":""}}`},
+		{"GeoJSON - Near", newGeoJSONRequest("GET", "/transformers/locations/near?lat=48.85&lon=2.35&radius=10"), &dummyService{locations: []location{paris, london}}, http.StatusOK, geoJSONContentType, `{"type":"FeatureCollection","features":[{"type":"Feature","id":"bba39990-c78d-3629-ae83-808c333c6dbc","geometry":{"type":"Point","coordinates":[2.3522,48.8566]},"properties":{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","prefLabel":"Paris","type":"City","distanceKm":0.75}}]}`},
+		{"GeoJSON - Within nothing", newRequest("GET", "/transformers/locations/within?bbox=10,10,11,11&format=geojson"), &dummyService{locations: []location{paris, london}}, http.StatusOK, geoJSONContentType, `{"type":"FeatureCollection","features":[]}`},
+		{"Localised - lang", newRequest("GET", fmt.Sprintf("/transformers/locations/%s?lang=fr", testUUID)), &dummyService{found: true, locations: []location{munich}}, http.StatusOK, "application/json", munichInFrench},
+		{"Localised - Accept-Language", newLanguageRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID), "it, fr-CH;q=0.8"), &dummyService{found: true, locations: []location{munich}}, http.StatusOK, "application/json", munichInFrench},
+		{"Localised - No match", newLanguageRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID), "it"), &dummyService{found: true, locations: []location{munich}}, http.StatusOK, "application/json", `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{},"prefLabel":"Munich","prefLabels":{"de":"München","fr":"Munich en Bavière"},"type":"City"}`},
+		{"Search", newLanguageRequest("GET", "/transformers/locations/search?q=muni", "de"), &dummyService{locations: []location{munich, paris}}, http.StatusOK, "application/json", `[{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","alternativeIdentifiers":{},"prefLabel":"München","prefLabels":{"de":"München","fr":"Munich en Bavière"},"type":"City"}]`},
+		{"Search - Nothing found", newRequest("GET", "/transformers/locations/search?q=rome"), &dummyService{locations: []location{munich, paris}}, http.StatusOK, "application/json", `[]`},
+		{"Search - Empty query", newRequest("GET", "/transformers/locations/search?q=+"), &dummyService{}, http.StatusBadRequest, problemContentType, `{"type":"urn:ft:locations-transformer:problem:invalid-request","title":"Invalid request","status":400,"detail":"q must not be empty","instance":"/transformers/locations/search"}`},
+		{"Merged location", newRequest("GET", "/transformers/locations/"+testUUID), &dummyService{found: false, locations: []location{{}}, deprecated: []deprecatedLocation{{UUID: testUUID, Status: mergedStatus, MergedInto: london.UUID, Reason: "Listed in the merges file", Since: since}}}, http.StatusMovedPermanently, "application/json", `{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","status":"merged","mergedInto":"e559b6c0-2241-35b9-b970-e55cb8be4cba","reason":"Listed in the merges file","since":"2016-10-19T10:00:00Z"}`},
+		{"Retired location", newRequest("GET", "/transformers/locations/"+testUUID), &dummyService{found: false, locations: []location{{}}, deprecated: []deprecatedLocation{{UUID: testUUID, PrefLabel: "Atlantis", Status: retiredStatus, Reason: "No longer in TME", Since: since}}}, http.StatusGone, problemContentType, `{"type":"urn:ft:locations-transformer:problem:retired","title":"Location retired","status":410,"detail":"Location with uuid=bba39990-c78d-3629-ae83-808c333c6dbc was retired from TME","instance":"/transformers/locations/bba39990-c78d-3629-ae83-808c333c6dbc","deprecation":{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","prefLabel":"Atlantis","status":"retired","reason":"No longer in TME","since":"2016-10-19T10:00:00Z"}}`},
+		{"Deprecated locations", newRequest("GET", "/transformers/locations/__deprecated"), &dummyService{deprecated: []deprecatedLocation{{UUID: testUUID, Status: retiredStatus, Reason: "Deprecated in TME", Since: since}}}, http.StatusOK, "application/json", `[{"uuid":"bba39990-c78d-3629-ae83-808c333c6dbc","status":"retired","reason":"Deprecated in TME","since":"2016-10-19T10:00:00Z"}]`},
 		{"Test GTG - Pass", newRequest("GET", status.GTGPath), &dummyService{found: true, locations: []location{{UUID: testUUID}}}, http.StatusOK, "application/json", "OK"},
-		{"Test GTG - Fail", newRequest("GET", status.GTGPath), &dummyService{found: true, locations: []location(nil)}, http.StatusServiceUnavailable, "application/json", ""},
-		{"Reload - Good", newRequest("POST", "/transformers/locations/__reload"), &dummyService{dataLoaded: DataLoaded}, http.StatusAccepted, "application/json", "{\"message\": \"Reloading people\"}"},
-		{"Reload - Conflict", newRequest("POST", "/transformers/locations/__reload"), &dummyService{dataLoaded: LoadingData}, http.StatusConflict, "application/json", "{\"message\": \"Currently Loading Data\"}"},
-		{"Reload - Fail", newRequest("POST", "/transformers/locations/__reload"), &dummyService{dataLoaded: NotInit}, http.StatusServiceUnavailable, "application/json", "{\"message\": \"Service Unavailable\"}"},
-		{"Health - Good", newRequest("GET", "/__health"), &dummyService{dataLoaded: DataLoaded}, http.StatusOK, "application/json", "regex=Check connectivity to TME\",\"ok\":true"},
+		{"Test GTG - Fail", newRequest("GET", status.GTGPath), &dummyService{found: true, locations: []location(nil)}, http.StatusServiceUnavailable, "application/json", "No locations loaded for taxonomy GL"},
+		{"Reload - Good", newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), &dummyService{dataLoaded: DataLoaded}, http.StatusAccepted, "application/json", `{"message":"Reloading locations"}`},
+		{"Reload - Conflict", newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), &dummyService{dataLoaded: LoadingData}, http.StatusConflict, problemContentType, `{"type":"urn:ft:locations-transformer:problem:reload-in-progress","title":"Reload in progress","status":409,"detail":"Locations of taxonomy GL are being loaded from TME","instance":"/transformers/locations/__reload"}`},
+		{"Reload - Fail", newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken), &dummyService{dataLoaded: NotInit}, http.StatusServiceUnavailable, problemContentType, `{"type":"urn:ft:locations-transformer:problem:not-loaded","title":"Locations not loaded yet","status":503,"detail":"The service for taxonomy GL is still starting","instance":"/transformers/locations/__reload"}`},
+		{"Health - Good", newRequest("GET", "/__health"), &dummyService{dataLoaded: DataLoaded}, http.StatusOK, "application/json", "regex=Check connectivity to TME for taxonomy GL\",\"ok\":true"},
 		{"Health - Bad", newRequest("GET", "/__health"), &dummyService{dataLoaded: ErrorLoadingData}, http.StatusOK, "application/json", "regex=Got an error loading data from tme. Check logs"},
+		{"Health - Rejected", newRequest("GET", "/__health"), &dummyService{dataLoaded: RejectedData, rejection: "Loaded 1 locations"}, http.StatusOK, "application/json", "regex=Loaded 1 locations"},
+		{"Force apply - Good", newAdminRequest("POST", "/transformers/locations/__force-apply", testAdminToken), &dummyService{dataLoaded: RejectedData}, http.StatusOK, "application/json", `{"message":"Rejected locations applied"}`},
+		{"Force apply - Nothing rejected", newAdminRequest("POST", "/transformers/locations/__force-apply", testAdminToken), &dummyService{dataLoaded: DataLoaded}, http.StatusConflict, problemContentType, `{"type":"urn:ft:locations-transformer:problem:nothing-to-apply","title":"Nothing to apply","status":409,"detail":"No rejected snapshot to apply","instance":"/transformers/locations/__force-apply"}`},
+		{"Force apply - Unauthorized", newAdminRequest("POST", "/transformers/locations/__force-apply", "wrong"), &dummyService{dataLoaded: RejectedData}, http.StatusUnauthorized, problemContentType, `{"type":"urn:ft:locations-transformer:problem:unauthorised","title":"Unauthorised","status":401,"detail":"The API key is not valid","instance":"/transformers/locations/__force-apply"}`},
 	}
 
 	for _, test := range tests {
 		rec := httptest.NewRecorder()
 		router(test.dummyService).ServeHTTP(rec, test.req)
 		assert.True(t, test.statusCode == rec.Code, fmt.Sprintf("%s: Wrong response code, was %d, should be %d", test.name, rec.Code, test.statusCode))
+		if test.contentType == geoJSONContentType || test.contentType == problemContentType {
+			assert.Equal(t, test.contentType, rec.Header().Get("Content-Type"), fmt.Sprintf("%s: Wrong content type", test.name))
+		}
 
 		if strings.HasPrefix(test.body, "regex=") {
 			regex := strings.TrimPrefix(test.body, "regex=")
@@ -63,6 +119,51 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+func TestContentLanguage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	router(&dummyService{found: true, locations: []location{munich}}).ServeHTTP(rec, newLanguageRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID), "de-AT"))
+	assert.Equal(t, "de", rec.Header().Get("Content-Language"))
+	assert.Equal(t, []string{"Accept", "Accept-Language"}, rec.Header()["Vary"])
+
+	rec = httptest.NewRecorder()
+	router(&dummyService{found: true, locations: []location{munich}}).ServeHTTP(rec, newRequest("GET", fmt.Sprintf("/transformers/locations/%s", testUUID)))
+	assert.Empty(t, rec.Header().Get("Content-Language"))
+}
+
+func TestMergedLocationRedirect(t *testing.T) {
+	rec := httptest.NewRecorder()
+	s := &dummyService{found: false, locations: []location{{}}, deprecated: []deprecatedLocation{{UUID: testUUID, Status: mergedStatus, MergedInto: london.UUID}}}
+	router(s).ServeHTTP(rec, newRequest("GET", "/transformers/locations/"+testUUID))
+	assert.Equal(t, "http://localhost:8080/transformers/locations/"+london.UUID, rec.Header().Get("Location"))
+}
+
+func TestMultipleTaxonomies(t *testing.T) {
+	regions := taxonomyConfig{name: "ON", locationType: "Region", routePrefix: "/transformers/regions", baseURL: "http://localhost:8080/transformers/regions/"}
+	m := mux.NewRouter()
+	lh := newLocationsHandler(&dummyService{found: true, locations: []location{{UUID: testUUID}}, dataLoaded: DataLoaded}, testTaxonomy, testAuth, testLimits, healthPolicy{})
+	rh := newLocationsHandler(&dummyService{found: false, dataLoaded: ErrorLoadingData}, regions, testAuth, testLimits, healthPolicy{})
+	lh.registerRoutes(m)
+	rh.registerRoutes(m)
+	m.HandleFunc("/__health", v1a.Handler("Locations Transformer Healthchecks", "Checks for accessing TME", append(lh.Checks(), rh.Checks()...)...))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/transformers/locations/__count"))
+	assert.Equal(t, "1", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/transformers/regions/__count"))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, newRequest("GET", "/__health"))
+	assert.Contains(t, rec.Body.String(), `Check connectivity to TME for taxonomy GL","ok":true`)
+	assert.Contains(t, rec.Body.String(), `Check connectivity to TME for taxonomy ON","ok":false`)
+
+	st := allGoodToGo([]gtg.StatusChecker{lh.G2GCheck, rh.G2GCheck})()
+	assert.False(t, st.GoodToGo)
+	assert.Equal(t, "No locations loaded for taxonomy ON", st.Message)
+}
+
 func newRequest(method, url string) *http.Request {
 	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
@@ -71,17 +172,31 @@ func newRequest(method, url string) *http.Request {
 	return req
 }
 
+func newAdminRequest(method, url, token string) *http.Request {
+	req := newRequest(method, url)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func newGeoJSONRequest(method, url string) *http.Request {
+	req := newRequest(method, url)
+	req.Header.Set("Accept", geoJSONContentType)
+	return req
+}
+
+func newLanguageRequest(method, url, languages string) *http.Request {
+	req := newRequest(method, url)
+	req.Header.Set("Accept-Language", languages)
+	return req
+}
+
 func router(s locationService) *mux.Router {
 	m := mux.NewRouter()
-	h := newLocationsHandler(s)
-	m.HandleFunc("/transformers/locations", h.getLocations).Methods("GET")
-	m.HandleFunc("/transformers/locations/__ids", h.getIds).Methods("GET")
-	m.HandleFunc("/transformers/locations/__count", h.getCount).Methods("GET")
-	m.HandleFunc("/transformers/locations/__reload", h.reload).Methods("POST")
-	m.HandleFunc("/transformers/locations/{uuid}", h.getLocationByUUID).Methods("GET")
+	h := newLocationsHandler(s, testTaxonomy, testAuth, testLimits, healthPolicy{})
+	h.registerRoutes(m)
 	g2gHandler := status.NewGoodToGoHandler(gtg.StatusChecker(h.G2GCheck))
 	m.HandleFunc(status.GTGPath, g2gHandler)
-	m.HandleFunc("/__health", v1a.Handler("Locations Transformer Healthchecks", "Checks for accessing TME", h.HealthCheck()))
+	m.HandleFunc("/__health", v1a.Handler("Locations Transformer Healthchecks", "Checks for accessing TME", h.Checks()...))
 	return m
 }
 
@@ -90,11 +205,37 @@ type dummyService struct {
 	locations   []location
 	initialised bool
 	dataLoaded  loadStatus
+	rejection   string
+	duplicates  []duplicateLocation
+	issues      []enrichmentIssue
+	deprecated  []deprecatedLocation
+	version     contentVersion
+	stats       loadStats
+	reloads     chan context.Context
+}
+
+func (s *dummyService) getDeprecation(uuid string) (deprecatedLocation, bool) {
+	for _, dl := range s.deprecated {
+		if dl.UUID == uuid {
+			return dl, true
+		}
+	}
+	return deprecatedLocation{}, false
+}
+
+func (s *dummyService) getDeprecations() []deprecatedLocation {
+	if s.deprecated == nil {
+		return []deprecatedLocation{}
+	}
+	return s.deprecated
 }
 
-func (s *dummyService) getLocations() ([]locationLink, bool) {
+func (s *dummyService) getLocations(locationType string) ([]locationLink, bool) {
 	var locationLinks []locationLink
 	for _, sub := range s.locations {
+		if locationType != "" && !contains(sub.Types, locationType) {
+			continue
+		}
 		locationLinks = append(locationLinks, locationLink{APIURL: "http://localhost:8080/transformers/locations/" + sub.UUID})
 	}
 	return locationLinks, s.found
@@ -108,13 +249,14 @@ func (s *dummyService) getLocationCount() int {
 	return len(s.locations)
 }
 
-func (s *dummyService) getLocationIds() []string {
-	i := 0
-	keys := make([]string, len(s.locations))
+func (s *dummyService) getLocationIds(locationType string) []string {
+	keys := make([]string, 0, len(s.locations))
 
 	for _, t := range s.locations {
-		keys[i] = t.UUID
-		i++
+		if locationType != "" && !contains(t.Types, locationType) {
+			continue
+		}
+		keys = append(keys, t.UUID)
 	}
 	return keys
 }
@@ -123,6 +265,106 @@ func (s *dummyService) reload() error {
 	return nil
 }
 
+func (s *dummyService) reloadContext(ctx context.Context) error {
+	if s.reloads != nil {
+		s.reloads <- ctx
+	}
+	return nil
+}
+
+func (s *dummyService) refresh() error {
+	return nil
+}
+
+// getLoadStatus reports data as loaded unless a test sets another status.
 func (s *dummyService) getLoadStatus() loadStatus {
+	if s.dataLoaded == "" {
+		return DataLoaded
+	}
 	return s.dataLoaded
 }
+
+func (s *dummyService) forceApply() error {
+	if s.dataLoaded != RejectedData {
+		return errNoRejectedSnapshot
+	}
+	return nil
+}
+
+func (s *dummyService) getRejection() string {
+	return s.rejection
+}
+
+func (s *dummyService) getDuplicates() []duplicateLocation {
+	return s.duplicates
+}
+
+func (s *dummyService) getLocationByIdentifier(authority string, value string) (location, bool) {
+	for _, l := range s.locations {
+		if contains(l.AlternativeIdentifiers.byAuthority()[authority], value) {
+			return l, true
+		}
+	}
+	return location{}, false
+}
+
+func (s *dummyService) getEnrichmentIssues() []enrichmentIssue {
+	return s.issues
+}
+
+func (s *dummyService) getLoadStats() loadStats {
+	return s.stats
+}
+
+func (s *dummyService) setGuard(guard snapshotGuard) {
+}
+
+func (s *dummyService) stop() {
+}
+
+func (s *dummyService) getLocationsNear(c coordinates, radiusKm float64, limit int) []nearbyLocation {
+	nearby := []nearbyLocation{}
+	for _, l := range s.locations {
+		if l.Coordinates != nil {
+			if d := distanceKm(c, *l.Coordinates); d <= radiusKm && len(nearby) < limit {
+				nearby = append(nearby, nearbyLocation{location: l, DistanceKm: roundKm(d)})
+			}
+		}
+	}
+	return nearby
+}
+
+func (s *dummyService) searchLocations(query string, locationType string, limit int) []location {
+	var found []location
+	for _, l := range s.locations {
+		if strings.Contains(strings.ToLower(l.PrefLabel), strings.ToLower(query)) {
+			found = append(found, l)
+		}
+	}
+	return found
+}
+
+func (s *dummyService) getLocationsWithin(b boundingBox, limit int) []location {
+	within := []location{}
+	for _, l := range s.locations {
+		if l.Coordinates != nil && b.contains(*l.Coordinates) && len(within) < limit {
+			within = append(within, l)
+		}
+	}
+	return within
+}
+
+func (s *dummyService) getLocationVersion(uuid string) (contentVersion, bool) {
+	if !s.found || s.version.Hash == "" {
+		return contentVersion{}, false
+	}
+	return s.version, true
+}
+
+func (s *dummyService) getSnapshotVersion() contentVersion {
+	return s.version
+}
+
+func (s *dummyService) getPayload(name string) (*payload, bool) {
+	return nil, false
+}
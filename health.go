@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Financial-Times/go-fthealth/v1a"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const panicGuide = "https://sites.google.com/a/ft.com/ft-technology-service-transition/home/run-book-library/locations-transfomer"
+
+// healthPolicy sets when the data health checks fail. Zero limits disable their checks.
+type healthPolicy struct {
+	maxStaleness              time.Duration
+	maxCountDeviationPercent  int
+	maxValidationErrorPercent int
+	// ping makes a lightweight request to TME for a taxonomy. Without it there is no TME ping check.
+	ping func(taxonomy string) error
+}
+
+func (h *locationsHandler) policy() healthPolicy {
+	return h.health.Load().(healthPolicy)
+}
+
+// setHealthPolicy changes the limits of the data health checks. Checks disabled at startup stay disabled.
+func (h *locationsHandler) setHealthPolicy(health healthPolicy) {
+	h.health.Store(health)
+}
+
+// dataChecks lists the data health checks enabled at startup. Their limits are read on every check, so they can be
+// changed while running.
+func (h *locationsHandler) dataChecks() []v1a.Check {
+	health := h.policy()
+	var checks []v1a.Check
+	if health.ping != nil {
+		checks = append(checks, v1a.Check{
+			BusinessImpact:   "Locations cannot be reloaded, so changes made in TME will not be published",
+			Name:             fmt.Sprintf("Check TME answers requests for taxonomy %s", h.taxonomy.name),
+			PanicGuide:       panicGuide + "#tme-ping",
+			Severity:         2,
+			TechnicalSummary: "A request for a single term from TME failed. Locations are still served from the latest load. Check TME is up and the credentials are valid",
+			Checker:          h.pingChecker,
+		})
+	}
+	if health.maxStaleness > 0 {
+		checks = append(checks, v1a.Check{
+			BusinessImpact:   "Locations served may be out of date",
+			Name:             fmt.Sprintf("Check the age of the locations served for taxonomy %s", h.taxonomy.name),
+			PanicGuide:       panicGuide + "#stale-locations",
+			Severity:         2,
+			TechnicalSummary: fmt.Sprintf("The locations served were fetched from TME longer ago than the maximum staleness. Check why reloads fail or are rejected, then POST %s/__reload", h.taxonomy.routePrefix),
+			Checker:          h.stalenessChecker,
+		})
+	}
+	if health.maxCountDeviationPercent > 0 {
+		checks = append(checks, v1a.Check{
+			BusinessImpact:   "Locations may have been added or removed in TME by mistake",
+			Name:             fmt.Sprintf("Check the number of locations loaded for taxonomy %s against the previous load", h.taxonomy.name),
+			PanicGuide:       panicGuide + "#count-deviation",
+			Severity:         3,
+			TechnicalSummary: "The latest load from TME changed the number of locations by more than the maximum deviation. Check with the TME editors the change is intended",
+			Checker:          h.countDeviationChecker,
+		})
+	}
+	if health.maxValidationErrorPercent > 0 {
+		checks = append(checks, v1a.Check{
+			BusinessImpact:   "Some locations may be served without their supplementary data, or in place of others",
+			Name:             fmt.Sprintf("Check the validation errors of the latest load for taxonomy %s", h.taxonomy.name),
+			PanicGuide:       panicGuide + "#validation-errors",
+			Severity:         3,
+			TechnicalSummary: fmt.Sprintf("Too many of the terms of the latest load were duplicates or had supplementary data that could not be applied. See %s/__duplicates and %s/__enrichment-issues", h.taxonomy.routePrefix, h.taxonomy.routePrefix),
+			Checker:          h.validationChecker,
+		})
+	}
+	return checks
+}
+
+func (h *locationsHandler) pingChecker() (string, error) {
+	if err := h.policy().ping(h.taxonomy.name); err != nil {
+		return "TME did not answer", err
+	}
+	return "TME answered", nil
+}
+
+func (h *locationsHandler) stalenessChecker() (string, error) {
+	loadedAt := h.service.getLoadStats().loadedAt
+	if loadedAt.IsZero() {
+		return "No locations loaded", errors.New("No locations have been loaded from TME yet")
+	}
+	age := time.Since(loadedAt)
+	if limit := h.policy().maxStaleness; age > limit {
+		return "Locations are stale", fmt.Errorf("Locations were fetched from TME %v ago, at %s, against a maximum of %v", age/time.Second*time.Second, loadedAt.UTC().Format(time.RFC3339), limit)
+	}
+	return fmt.Sprintf("Locations were fetched from TME %v ago", age/time.Second*time.Second), nil
+}
+
+func (h *locationsHandler) countDeviationChecker() (string, error) {
+	st := h.service.getLoadStats()
+	if st.previousCount == 0 {
+		return "No previous load to compare with", nil
+	}
+	deviation := (st.count - st.previousCount) * 100 / st.previousCount
+	limit := h.policy().maxCountDeviationPercent
+	if deviation > limit || -deviation > limit {
+		return "Number of locations changed", fmt.Errorf("Loaded %d locations against %d in the previous load, a change of %d%% against a maximum of %d%%", st.count, st.previousCount, deviation, limit)
+	}
+	return fmt.Sprintf("Loaded %d locations against %d in the previous load", st.count, st.previousCount), nil
+}
+
+func (h *locationsHandler) validationChecker() (string, error) {
+	st := h.service.getLoadStats()
+	if st.terms == 0 {
+		return "No terms loaded", nil
+	}
+	rate := st.invalid * 100 / st.terms
+	if limit := h.policy().maxValidationErrorPercent; rate > limit {
+		return "Too many validation errors", fmt.Errorf("Found %d validation errors in %d terms, %d%% against a maximum of %d%%", st.invalid, st.terms, rate, limit)
+	}
+	return fmt.Sprintf("Found %d validation errors in %d terms", st.invalid, st.terms), nil
+}
+
+// newTMEPing asks TME for the first term of a taxonomy, as the TME reader would, without retrying. The client
+// authenticates the request, as a credentialsClient does.
+func newTMEPing(client httpClient, baseURL string) func(string) error {
+	return func(taxonomy string) error {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/rs/authorityfiles/%s/terms?maximumRecords=1&startRecord=0", baseURL, taxonomy), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("TME answered %s", resp.Status)
+		}
+		return nil
+	}
+}
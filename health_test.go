@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadStats(t *testing.T) {
+	repo := dummyRepo{
+		terms: []term{
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "NGQ2MWQZ2VucmVz"}},
+		err: nil}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{maxDropPercent: 40}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	st := service.getLoadStats()
+	assert.Equal(t, loadStats{loadedAt: st.loadedAt, terms: 3, count: 2, previousCount: 0, invalid: 1}, st)
+	assert.False(t, st.loadedAt.IsZero())
+	firstLoad := st.loadedAt
+
+	repo.terms = repo.terms[2:]
+	assert.Error(t, service.reload())
+	assert.Equal(t, loadStats{loadedAt: firstLoad, terms: 3, count: 2, previousCount: 0, invalid: 1}, service.getLoadStats(), "A rejected load should leave the stats of the locations served alone")
+
+	assert.NoError(t, service.forceApply())
+	st = service.getLoadStats()
+	assert.True(t, st.loadedAt.After(firstLoad))
+	assert.Equal(t, loadStats{loadedAt: st.loadedAt, terms: 1, count: 1, previousCount: 2, invalid: 0}, st)
+}
+
+func TestLoadStatsAfterRejectedLoad(t *testing.T) {
+	terms := []term{
+		{CanonicalName: "Paris", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+		{CanonicalName: "Berlin", RawID: "NGQ2MWQZ2VucmVz"},
+		{CanonicalName: "Munich", RawID: "MTE3-R0w="},
+		{CanonicalName: "Madrid", RawID: "MTE4-R0w="}}
+	repo := dummyRepo{terms: terms}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{maxDropPercent: 40}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+
+	repo.terms = terms[:1]
+	assert.Error(t, service.reload())
+	repo.terms = terms[:3]
+	assert.NoError(t, service.reload())
+	st := service.getLoadStats()
+	assert.Equal(t, loadStats{loadedAt: st.loadedAt, terms: 3, count: 3, previousCount: 4, invalid: 0}, st, "The previous count should be the one served, not the one rejected")
+
+	assert.NoError(t, service.refresh())
+	assert.Equal(t, st, service.getLoadStats(), "Refreshing the locations served should keep the count of the load before them")
+}
+
+func TestDataChecks(t *testing.T) {
+	policy := healthPolicy{maxStaleness: time.Hour, maxCountDeviationPercent: 10, maxValidationErrorPercent: 5}
+	tests := []struct {
+		name    string
+		checker func(h *locationsHandler) func() (string, error)
+		stats   loadStats
+		healthy bool
+	}{
+		{"Fresh", stalenessChecker, loadStats{loadedAt: time.Now().Add(-time.Minute)}, true},
+		{"Stale", stalenessChecker, loadStats{loadedAt: time.Now().Add(-2 * time.Hour)}, false},
+		{"Never loaded", stalenessChecker, loadStats{}, false},
+		{"First load", countDeviationChecker, loadStats{count: 100}, true},
+		{"Count within deviation", countDeviationChecker, loadStats{count: 109, previousCount: 100}, true},
+		{"Count grew", countDeviationChecker, loadStats{count: 111, previousCount: 100}, false},
+		{"Count dropped", countDeviationChecker, loadStats{count: 89, previousCount: 100}, false},
+		{"No terms", validationChecker, loadStats{}, true},
+		{"Few validation errors", validationChecker, loadStats{terms: 100, invalid: 5}, true},
+		{"Many validation errors", validationChecker, loadStats{terms: 100, invalid: 6}, false},
+	}
+	for _, test := range tests {
+		h := newLocationsHandler(&dummyService{stats: test.stats}, testTaxonomy, testAuth, testLimits, policy)
+		_, err := test.checker(&h)()
+		assert.Equal(t, test.healthy, err == nil, fmt.Sprintf("%s: Unexpected error %v", test.name, err))
+	}
+}
+
+func stalenessChecker(h *locationsHandler) func() (string, error) {
+	return h.stalenessChecker
+}
+
+func countDeviationChecker(h *locationsHandler) func() (string, error) {
+	return h.countDeviationChecker
+}
+
+func validationChecker(h *locationsHandler) func() (string, error) {
+	return h.validationChecker
+}
+
+func TestDataChecksCanBeDisabled(t *testing.T) {
+	h := newLocationsHandler(&dummyService{}, testTaxonomy, testAuth, testLimits, healthPolicy{})
+	assert.Len(t, h.Checks(), 2)
+
+	h = newLocationsHandler(&dummyService{}, testTaxonomy, testAuth, testLimits, healthPolicy{maxStaleness: time.Hour, maxCountDeviationPercent: 10, maxValidationErrorPercent: 5, ping: func(string) error { return errors.New("TME is down") }})
+	assert.Len(t, h.Checks(), 6)
+}
+
+func TestTMEPing(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		assert.Equal(t, "/rs/authorityfiles/GL/terms", req.URL.Path)
+		assert.Equal(t, "1", req.URL.Query().Get("maximumRecords"))
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "pass", password)
+		assert.Equal(t, "token", req.Header.Get("X-Coco-Auth"))
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+	ping := newTMEPing(credentialsClient{next: http.DefaultClient, provider: staticCredentials{credentials{username: "user", password: "pass", token: "token"}}}, server.URL)
+
+	assert.NoError(t, ping("GL"))
+	status = http.StatusUnauthorized
+	assert.EqualError(t, ping("GL"), "TME answered 401 Unauthorized")
+	server.Close()
+	assert.Error(t, ping("GL"))
+}
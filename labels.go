@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// canonicalLanguage formats a BCP 47 language tag the way it is keyed in prefLabels and altLabels:
+// the language in lower case, a script in title case and a region in upper case, as in zh-Hant-TW.
+func canonicalLanguage(tag string) (string, error) {
+	tag = strings.Replace(strings.TrimSpace(tag), "_", "-", -1)
+	if !languageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("invalid language tag %q", tag)
+	}
+	parts := strings.Split(strings.ToLower(tag), "-")
+	for i := 1; i < len(parts); i++ {
+		switch {
+		case len(parts[i]) == 4 && isAlpha(parts[i]):
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		case len(parts[i]) == 2 && isAlpha(parts[i]), len(parts[i]) == 3 && !isAlpha(parts[i]):
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+func isAlpha(s string) bool {
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// withLabel returns a copy of the location with a label in the given language, leaving the receiver's maps untouched.
+// A preferred label replaces any previous one in that language.
+func (l location) withLabel(language string, value string, preferred bool) location {
+	if preferred {
+		labels := make(map[string]string, len(l.PrefLabels)+1)
+		for k, v := range l.PrefLabels {
+			labels[k] = v
+		}
+		labels[language] = value
+		l.PrefLabels = labels
+		return l
+	}
+	if contains(l.AltLabels[language], value) {
+		return l
+	}
+	labels := make(map[string][]string, len(l.AltLabels)+1)
+	for k, v := range l.AltLabels {
+		labels[k] = v
+	}
+	labels[language] = append(append([]string{}, l.AltLabels[language]...), value)
+	l.AltLabels = labels
+	return l
+}
+
+// labelsFromAttributes adds the labels TME holds as prefLabel:<language> and altLabel:<language> attributes.
+func labelsFromAttributes(tmeIdentifier string, l location, attributes []tmeAttribute) location {
+	for _, a := range attributes {
+		i := strings.Index(a.Name, ":")
+		if i < 0 || strings.TrimSpace(a.Value) == "" {
+			continue
+		}
+		kind := strings.ToLower(a.Name[:i])
+		if kind != "preflabel" && kind != "altlabel" {
+			continue
+		}
+		language, err := canonicalLanguage(a.Name[i+1:])
+		if err != nil {
+			log.Warnf("Ignoring label of TME term %s: %v", tmeIdentifier, err)
+			continue
+		}
+		l = l.withLabel(language, strings.TrimSpace(a.Value), kind == "preflabel")
+	}
+	return l
+}
+
+type labelEntry struct {
+	tmeIdentifier string
+	language      string
+	value         string
+	preferred     bool
+}
+
+// labels is an enricher adding language-tagged labels from a CSV file reread on every reload.
+type labels struct {
+	file *supplementaryFile
+}
+
+func newLabels(path string) (*labels, error) {
+	file, err := newSupplementaryFile(path, func(path string) (interface{}, error) {
+		return readLabels(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &labels{file: file}, nil
+}
+
+func (lb *labels) enrich(taxonomy taxonomyConfig, locations locationsMap) []enrichmentIssue {
+	uuids := byTmeIdentifier(locations)
+	var issues []enrichmentIssue
+	for _, e := range lb.file.current().([]labelEntry) {
+		if !inTaxonomy(e.tmeIdentifier, taxonomy) {
+			continue
+		}
+		uuid, found := uuids[e.tmeIdentifier]
+		if !found {
+			issues = append(issues, enrichmentIssue{Source: "labels", Key: e.tmeIdentifier, Issue: fmt.Sprintf("No location for %s label %q", e.language, e.value)})
+			continue
+		}
+		locations[uuid] = locations[uuid].withLabel(e.language, e.value, e.preferred)
+	}
+	return issues
+}
+
+// readLabels reads rows of tmeIdentifier,language,label,kind after a header row, where kind is pref or alt.
+func readLabels(path string) ([]labelEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse labels file %s: %v", path, err)
+	}
+
+	entries := make([]labelEntry, 0, len(records))
+	for i, r := range records {
+		if i == 0 {
+			continue
+		}
+		language, err := canonicalLanguage(r[1])
+		if err != nil {
+			return nil, fmt.Errorf("Label on line %d of %s: %v", i+1, path, err)
+		}
+		if strings.TrimSpace(r[2]) == "" {
+			return nil, fmt.Errorf("Label on line %d of %s is empty", i+1, path)
+		}
+		kind := strings.ToLower(strings.TrimSpace(r[3]))
+		if kind != "pref" && kind != "alt" {
+			return nil, fmt.Errorf("Label on line %d of %s: kind must be pref or alt, not %q", i+1, path, r[3])
+		}
+		entries = append(entries, labelEntry{tmeIdentifier: r[0], language: language, value: strings.TrimSpace(r[2]), preferred: kind == "pref"})
+	}
+	return entries, nil
+}
+
+// languagePreferences are the language ranges a client asked for, most preferred first.
+type languagePreferences []string
+
+// requestedLanguages reads the lang parameter, a comma separated list of languages, or else the Accept-Language header.
+func requestedLanguages(req *http.Request) languagePreferences {
+	if lang := req.URL.Query().Get("lang"); lang != "" {
+		var prefs languagePreferences
+		for _, tag := range strings.Split(lang, ",") {
+			if language, err := canonicalLanguage(tag); err == nil {
+				prefs = append(prefs, language)
+			}
+		}
+		return prefs
+	}
+	return parseAcceptLanguage(req.Header.Get("Accept-Language"))
+}
+
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityValues reads a header such as Accept-Language or Accept-Encoding, ordering its values by quality
+// and leaving out those with q=0.
+func parseQualityValues(header string) []qualityValue {
+	var values []qualityValue
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		value := strings.TrimSpace(fields[0])
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if value != "" && q > 0 {
+			values = append(values, qualityValue{value: strings.ToLower(value), q: q})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].q > values[j].q
+	})
+	return values
+}
+
+// parseAcceptLanguage orders the ranges of an Accept-Language header by quality, ignoring invalid ranges and those with q=0.
+func parseAcceptLanguage(header string) languagePreferences {
+	var prefs languagePreferences
+	for _, v := range parseQualityValues(header) {
+		if v.value == "*" {
+			prefs = append(prefs, v.value)
+			continue
+		}
+		if language, err := canonicalLanguage(v.value); err == nil {
+			prefs = append(prefs, language)
+		}
+	}
+	return prefs
+}
+
+// prefLabel picks the label to show. For each requested language in turn it tries an exact match, then the language with its
+// last subtags removed one at a time, then any more specific label of the same base language. When nothing matches, or a
+// wildcard is reached first, the untagged prefLabel from TME is used.
+func (prefs languagePreferences) prefLabel(l location) (string, string) {
+	for _, requested := range prefs {
+		if requested == "*" {
+			break
+		}
+		for tag := requested; tag != ""; tag = parentLanguage(tag) {
+			if label, found := l.PrefLabels[tag]; found {
+				return label, tag
+			}
+		}
+		base := strings.Split(requested, "-")[0]
+		var specific []string
+		for tag := range l.PrefLabels {
+			if strings.HasPrefix(tag, base+"-") {
+				specific = append(specific, tag)
+			}
+		}
+		if len(specific) > 0 {
+			sort.Strings(specific)
+			return l.PrefLabels[specific[0]], specific[0]
+		}
+	}
+	return l.PrefLabel, ""
+}
+
+func parentLanguage(tag string) string {
+	i := strings.LastIndex(tag, "-")
+	if i < 0 {
+		return ""
+	}
+	return tag[:i]
+}
+
+// localise returns the location with the prefLabel chosen for these preferences.
+func (prefs languagePreferences) localise(l location) location {
+	l.PrefLabel, _ = prefs.prefLabel(l)
+	return l
+}
+
+func (prefs languagePreferences) localiseAll(locations []location) []location {
+	localised := make([]location, len(locations))
+	for i, l := range locations {
+		localised[i] = prefs.localise(l)
+	}
+	return localised
+}
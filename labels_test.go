@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCanonicalLanguage(t *testing.T) {
+	tests := []struct {
+		tag      string
+		expected string
+	}{
+		{"fr", "fr"},
+		{"EN-gb", "en-GB"},
+		{"zh_hant_tw", "zh-Hant-TW"},
+		{"es-419", "es-419"},
+		{"sr-Latn", "sr-Latn"},
+	}
+	for _, test := range tests {
+		actual, err := canonicalLanguage(test.tag)
+		assert.NoError(t, err, test.tag)
+		assert.Equal(t, test.expected, actual, test.tag)
+	}
+	for _, invalid := range []string{"", "*", "f", "fr--FR", "français"} {
+		_, err := canonicalLanguage(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	assert.Equal(t, languagePreferences{"fr-CH", "fr", "en", "*"}, parseAcceptLanguage("fr-CH, fr;q=0.9, en;q=0.8, de;q=0, *;q=0.5"))
+	assert.Equal(t, languagePreferences{"de", "en"}, parseAcceptLanguage("en;q=0.5, de, not a tag"))
+	assert.Empty(t, parseAcceptLanguage(""))
+}
+
+func TestPrefLabelFallback(t *testing.T) {
+	munich := location{PrefLabel: "Munich", PrefLabels: map[string]string{"de": "München", "en-GB": "Munich", "zh-Hans": "慕尼黑", "zh-Hant": "慕尼黑市"}}
+	tests := []struct {
+		prefs    languagePreferences
+		label    string
+		language string
+	}{
+		{nil, "Munich", ""},
+		{languagePreferences{"de"}, "München", "de"},
+		{languagePreferences{"de-AT"}, "München", "de"},
+		{languagePreferences{"en"}, "Munich", "en-GB"},
+		{languagePreferences{"zh-Hant-TW"}, "慕尼黑市", "zh-Hant"},
+		{languagePreferences{"zh"}, "慕尼黑", "zh-Hans"},
+		{languagePreferences{"it", "de"}, "München", "de"},
+		{languagePreferences{"it", "*", "de"}, "Munich", ""},
+		{languagePreferences{"it"}, "Munich", ""},
+	}
+	for _, test := range tests {
+		label, language := test.prefs.prefLabel(munich)
+		assert.Equal(t, test.label, label, fmt.Sprintf("%v", test.prefs))
+		assert.Equal(t, test.language, language, fmt.Sprintf("%v", test.prefs))
+	}
+}
+
+func TestLabelsFromAttributes(t *testing.T) {
+	l := labelsFromAttributes("id", location{PrefLabel: "Munich"}, []tmeAttribute{
+		{Name: "prefLabel:de", Value: "München"},
+		{Name: "altLabel:de", Value: "Muenchen"},
+		{Name: "AltLabel:DE", Value: "Minga"},
+		{Name: "altLabel:de", Value: "Minga"},
+		{Name: "prefLabel:not a tag", Value: "Ignored"},
+		{Name: "type", Value: "City"},
+	})
+	assert.Equal(t, map[string]string{"de": "München"}, l.PrefLabels)
+	assert.Equal(t, map[string][]string{"de": {"Muenchen", "Minga"}}, l.AltLabels)
+}
+
+func TestWithLabelLeavesOriginalUntouched(t *testing.T) {
+	original := location{PrefLabels: map[string]string{"de": "München"}, AltLabels: map[string][]string{"de": {"Muenchen"}}}
+	changed := original.withLabel("de", "Minga", false).withLabel("fr", "Munich", true)
+	assert.Equal(t, map[string]string{"de": "München"}, original.PrefLabels)
+	assert.Equal(t, map[string][]string{"de": {"Muenchen"}}, original.AltLabels)
+	assert.Equal(t, map[string]string{"de": "München", "fr": "Munich"}, changed.PrefLabels)
+	assert.Equal(t, map[string][]string{"de": {"Muenchen", "Minga"}}, changed.AltLabels)
+}
+
+func TestLabels(t *testing.T) {
+	path := writeTempFile(t, "labels*.csv", "tmeIdentifier,language,label,kind\n"+
+		"MTE3-R0w=,fr,Paris,pref\n"+
+		"MTE3-R0w=,ru,Париж,pref\n"+
+		"MTE3-R0w=,fr,Ville Lumière,alt\n"+
+		"MTg-R0w=,fr,Londres,pref\n")
+	defer os.Remove(path)
+	lb, err := newLabels(path)
+	assert.NoError(t, err)
+
+	locations := locationsMap{testUUID: getDummyLocation(testUUID, "Paris", "MTE3-R0w=")}
+	issues := lb.enrich(glTaxonomy, locations)
+	assert.Equal(t, map[string]string{"fr": "Paris", "ru": "Париж"}, locations[testUUID].PrefLabels)
+	assert.Equal(t, map[string][]string{"fr": {"Ville Lumière"}}, locations[testUUID].AltLabels)
+	assert.Equal(t, []enrichmentIssue{{Source: "labels", Key: "MTg-R0w=", Issue: `No location for fr label "Londres"`}}, issues)
+
+	for _, invalid := range []string{"tmeIdentifier,language,label,kind\nMTE3-R0w=,français,Paris,pref\n", "tmeIdentifier,language,label,kind\nMTE3-R0w=,fr,,pref\n", "tmeIdentifier,language,label,kind\nMTE3-R0w=,fr,Paris,hidden\n"} {
+		path := writeTempFile(t, "labels*.csv", invalid)
+		_, err := readLabels(path)
+		os.Remove(path)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/Financial-Times/service-status-go/gtg"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readyPath is where load balancers ask whether to route requests to the instance. Unlike __gtg, it fails as soon as
+// the instance starts shutting down.
+const readyPath = "/__ready"
+
+// lifecycle makes the first loads of the instance once it is serving, and drains it on shutdown: it stops being ready,
+// waits for load balancers to notice, then lets requests in flight finish while cancelling reloads.
+type lifecycle struct {
+	draining     int32
+	services     []locationService
+	drainDelay   time.Duration
+	drainTimeout time.Duration
+	// ctx is cancelled on shutdown, cancelling the first loads.
+	ctx    context.Context
+	cancel context.CancelFunc
+	loaded chan struct{}
+	done   chan struct{}
+}
+
+func newLifecycle(services []locationService, drainDelay time.Duration, drainTimeout time.Duration) *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{services: services, drainDelay: drainDelay, drainTimeout: drainTimeout, ctx: ctx, cancel: cancel, loaded: make(chan struct{}), done: make(chan struct{})}
+}
+
+// load makes the first load of each taxonomy in turn, under a context cancelled on shutdown, and returns once they have
+// all finished. A failed first load is left to later reloads.
+func (l *lifecycle) load(taxonomies []string, services []locationService) {
+	defer close(l.loaded)
+	for i, s := range services {
+		if err := s.reloadContext(l.ctx); err != nil && err != errShuttingDown && err != errReloadCancelled {
+			log.Errorf("First load of taxonomy %s failed: %v", taxonomies[i], err)
+		}
+	}
+}
+
+// ready is good to go once the first loads have finished, while the instance is not draining and the given checker is.
+func (l *lifecycle) ready(checker gtg.StatusChecker) gtg.StatusChecker {
+	return func() gtg.Status {
+		if atomic.LoadInt32(&l.draining) == 1 {
+			return gtg.Status{GoodToGo: false, Message: "Shutting down"}
+		}
+		select {
+		case <-l.loaded:
+		default:
+			return gtg.Status{GoodToGo: false, Message: "Loading locations from TME"}
+		}
+		return checker()
+	}
+}
+
+// shutdown drains the server. Requests still running after the drain timeout are cut off.
+func (l *lifecycle) shutdown(server *http.Server) error {
+	defer close(l.done)
+	atomic.StoreInt32(&l.draining, 1)
+	log.Infof("Shutting down, waiting %v for load balancers to stop routing requests", l.drainDelay)
+	time.Sleep(l.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.drainTimeout)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		l.cancel()
+		for _, s := range l.services {
+			s.stop()
+		}
+		close(stopped)
+	}()
+	log.Infof("Waiting up to %v for requests in flight to finish", l.drainTimeout)
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Warnf("Cutting off requests still running after %v: %v", l.drainTimeout, err)
+		server.Close()
+	}
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warnf("A reload from TME was still running after %v", l.drainTimeout)
+	}
+	return err
+}
+
+// wait blocks until shutdown has finished draining, as the server stops listening as soon as it starts.
+func (l *lifecycle) wait() {
+	<-l.done
+}
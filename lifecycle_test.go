@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/Financial-Times/service-status-go/gtg"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	lc := newLifecycle(nil, 0, time.Second)
+	server := httptest.NewServer(http.NotFoundHandler())
+	ready := lc.ready(func() gtg.Status { return gtg.Status{GoodToGo: true} })
+	assert.Equal(t, gtg.Status{GoodToGo: false, Message: "Loading locations from TME"}, ready())
+	lc.load(nil, nil)
+	assert.True(t, ready().GoodToGo)
+
+	assert.NoError(t, lc.shutdown(server.Config))
+	assert.Equal(t, gtg.Status{GoodToGo: false, Message: "Shutting down"}, ready())
+	lc.wait()
+}
+
+func TestShutdownWaitsForRequestsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		writer.Write([]byte("dump"))
+	}))
+	lc := newLifecycle(nil, 0, 5*time.Second)
+
+	responses := make(chan int)
+	go func() {
+		resp, err := http.Get(server.URL + "/transformers/locations/__dump")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+	<-started
+	shutdown := make(chan error)
+	go func() { shutdown <- lc.shutdown(server.Config) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-responses)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestShutdownCutsOffRequestsAfterTimeout(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+	}))
+	lc := newLifecycle(nil, 0, 50*time.Millisecond)
+
+	go http.Get(server.URL)
+	<-started
+	assert.Error(t, lc.shutdown(server.Config))
+}
+
+func TestStopCancelsReload(t *testing.T) {
+	repo := dummyLockRepo{terms: []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+
+	repo.Add(1)
+	reloaded := make(chan error)
+	go func() { reloaded <- service.reload() }()
+	for service.getLoadStatus() != LoadingData {
+		time.Sleep(time.Millisecond)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		service.stop()
+		close(stopped)
+	}()
+	for !service.(*locationServiceImpl).stopped() {
+		time.Sleep(time.Millisecond)
+	}
+	repo.Done()
+
+	assert.Equal(t, errReloadCancelled, <-reloaded)
+	<-stopped
+	assert.Equal(t, DataLoaded, service.getLoadStatus(), "A cancelled reload should leave the status as it was")
+	assert.Equal(t, 1, service.getLocationCount())
+	assert.Equal(t, errShuttingDown, service.reload())
+}
+
+func TestFirstLoads(t *testing.T) {
+	repo := dummyLockRepo{terms: []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}}
+	service, err := createLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	assert.Equal(t, NotInit, service.getLoadStatus(), "Creating a service should not load it")
+	lc := newLifecycle([]locationService{service}, 0, time.Second)
+	ready := lc.ready(func() gtg.Status { return gtg.Status{GoodToGo: true} })
+
+	repo.Add(1)
+	loaded := make(chan struct{})
+	go func() {
+		lc.load([]string{glTaxonomy.name}, []locationService{service})
+		close(loaded)
+	}()
+	for service.getLoadStatus() != LoadingData {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, gtg.Status{GoodToGo: false, Message: "Loading locations from TME"}, ready())
+	repo.Done()
+	<-loaded
+	assert.True(t, ready().GoodToGo)
+	assert.Equal(t, 1, service.getLocationCount())
+}
+
+func TestShutdownCancelsFirstLoads(t *testing.T) {
+	fetching := make(chan struct{})
+	tme := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		close(fetching)
+		<-req.Context().Done()
+	}))
+	defer tme.Close()
+	repo := newTracedRepository(glTaxonomy.name)
+	repo.Repository = &httpRepository{client: repo.client(http.DefaultClient), url: tme.URL}
+	service, err := createLocationService(repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	lc := newLifecycle([]locationService{service}, 0, 5*time.Second)
+
+	loaded := make(chan struct{})
+	go func() {
+		lc.load([]string{glTaxonomy.name}, []locationService{service})
+		close(loaded)
+	}()
+	<-fetching
+	server := httptest.NewServer(http.NotFoundHandler())
+	assert.NoError(t, lc.shutdown(server.Config))
+	select {
+	case <-loaded:
+	case <-time.After(time.Second):
+		t.Fatal("The first load should be cancelled by the shutdown")
+	}
+	assert.Equal(t, NotInit, service.getLoadStatus(), "A cancelled first load should leave the service not loaded")
+}
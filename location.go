@@ -4,14 +4,57 @@ type location struct {
 	UUID                   string                 `json:"uuid"`
 	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers,omitempty"`
 	PrefLabel              string                 `json:"prefLabel"`
+	PrefLabels             map[string]string      `json:"prefLabels,omitempty"`
+	AltLabels              map[string][]string    `json:"altLabels,omitempty"`
 	Type                   string                 `json:"type"`
+	Types                  []string               `json:"types,omitempty"`
+	BroaderUUIDs           []string               `json:"broaderUUIDs,omitempty"`
+	Coordinates            *coordinates           `json:"coordinates,omitempty"`
+	BoundingBox            *boundingBox           `json:"boundingBox,omitempty"`
 }
 
 type alternativeIdentifiers struct {
-	TME   []string `json:"TME,omitempty"`
-	Uuids []string `json:"uuids,omitempty"`
+	TME      []string `json:"TME,omitempty"`
+	Uuids    []string `json:"uuids,omitempty"`
+	GeoNames []string `json:"geonames,omitempty"`
+	Wikidata []string `json:"wikidata,omitempty"`
+	ISO3166  []string `json:"iso3166,omitempty"`
+}
+
+// with returns a copy of the identifiers including the given external one, leaving the receiver untouched.
+func (ids alternativeIdentifiers) with(authority string, value string) alternativeIdentifiers {
+	add := func(values []string) []string {
+		if contains(values, value) {
+			return values
+		}
+		return append(append([]string{}, values...), value)
+	}
+	switch authority {
+	case geoNamesAuthority:
+		ids.GeoNames = add(ids.GeoNames)
+	case wikidataAuthority:
+		ids.Wikidata = add(ids.Wikidata)
+	case iso3166Authority:
+		ids.ISO3166 = add(ids.ISO3166)
+	}
+	return ids
+}
+
+// byAuthority lists the identifiers keyed by the authority names accepted by the identifier lookup.
+func (ids alternativeIdentifiers) byAuthority() map[string][]string {
+	return map[string][]string{
+		"TME":             ids.TME,
+		"UUID":            ids.Uuids,
+		geoNamesAuthority: ids.GeoNames,
+		wikidataAuthority: ids.Wikidata,
+		iso3166Authority:  ids.ISO3166,
+	}
 }
 
 type locationLink struct {
 	APIURL string `json:"apiUrl"`
 }
+
+type locationID struct {
+	ID string `json:"id"`
+}
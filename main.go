@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
@@ -14,9 +15,15 @@ import (
 	"github.com/jawher/mow.cli"
 	"github.com/rcrowley/go-metrics"
 	"github.com/sethgrid/pester"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -26,130 +33,610 @@ func init() {
 
 func main() {
 	app := cli.App("locations-transformer", "A RESTful API for transforming TME locations to UP json")
-	username := app.String(cli.StringOpt{
+	cfg, err := newSettings(app, os.Args[1:])
+	if err != nil {
+		log.Fatalf("Error while reading the config file: [%v]", err.Error())
+	}
+	username := cfg.String(cli.StringOpt{
 		Name:   "tme-username",
 		Value:  "",
 		Desc:   "TME username used for http basic authentication",
 		EnvVar: "TME_USERNAME",
 	})
-	password := app.String(cli.StringOpt{
+	password := cfg.String(cli.StringOpt{
 		Name:   "tme-password",
 		Value:  "",
 		Desc:   "TME password used for http basic authentication",
 		EnvVar: "TME_PASSWORD",
 	})
-	token := app.String(cli.StringOpt{
+	token := cfg.String(cli.StringOpt{
 		Name:   "token",
 		Value:  "",
 		Desc:   "Token to be used for accessing TME",
 		EnvVar: "TOKEN",
 	})
-	baseURL := app.String(cli.StringOpt{
+	credentialsProviderName := cfg.String(cli.StringOpt{
+		Name:   "tme-credentials-provider",
+		Value:  "env",
+		Desc:   "Where TME credentials come from: env, for tme-username, tme-password and token, file, for files in tme-credentials-dir, or http, for tme-credentials-url. File and http credentials are rotated without a restart",
+		EnvVar: "TME_CREDENTIALS_PROVIDER",
+	})
+	credentialsDir := cfg.String(cli.StringOpt{
+		Name:   "tme-credentials-dir",
+		Value:  "",
+		Desc:   "Directory holding the files username, password and token, such as a mounted secret, read again whenever they change",
+		EnvVar: "TME_CREDENTIALS_DIR",
+	})
+	credentialsURL := cfg.String(cli.StringOpt{
+		Name:   "tme-credentials-url",
+		Value:  "",
+		Desc:   "Secrets endpoint answering a JSON object with username, password and token fields",
+		EnvVar: "TME_CREDENTIALS_URL",
+	})
+	credentialsURLToken := cfg.String(cli.StringOpt{
+		Name:   "tme-credentials-url-token",
+		Value:  "",
+		Desc:   "Bearer token presented to the secrets endpoint",
+		EnvVar: "TME_CREDENTIALS_URL_TOKEN",
+	})
+	credentialsRefresh := cfg.String(cli.StringOpt{
+		Name:   "tme-credentials-refresh",
+		Value:  "5m",
+		Desc:   "Age after which credentials are fetched from the secrets endpoint again",
+		EnvVar: "TME_CREDENTIALS_REFRESH",
+	})
+	baseURL := cfg.String(cli.StringOpt{
 		Name:   "base-url",
 		Value:  "http://localhost:8080/transformers/locations/",
 		Desc:   "Base url",
 		EnvVar: "BASE_URL",
 	})
-	tmeBaseURL := app.String(cli.StringOpt{
+	tmeBaseURL := cfg.String(cli.StringOpt{
 		Name:   "tme-base-url",
 		Value:  "https://tme.ft.com",
 		Desc:   "TME base url",
 		EnvVar: "TME_BASE_URL",
 	})
-	port := app.Int(cli.IntOpt{
+	tmeCAFile := cfg.String(cli.StringOpt{
+		Name:   "tme-ca-file",
+		Value:  "",
+		Desc:   "Path to PEM certificates trusted for TME on top of the system ones",
+		EnvVar: "TME_CA_FILE",
+	})
+	tmeClientCertFile := cfg.String(cli.StringOpt{
+		Name:   "tme-client-cert-file",
+		Value:  "",
+		Desc:   "Path to a PEM client certificate presented to TME, with tme-client-key-file",
+		EnvVar: "TME_CLIENT_CERT_FILE",
+	})
+	tmeClientKeyFile := cfg.String(cli.StringOpt{
+		Name:   "tme-client-key-file",
+		Value:  "",
+		Desc:   "Path to the PEM key of the client certificate presented to TME",
+		EnvVar: "TME_CLIENT_KEY_FILE",
+	})
+	tmeTLSMinVersion := cfg.String(cli.StringOpt{
+		Name:   "tme-tls-min-version",
+		Value:  "1.2",
+		Desc:   "Minimum TLS version used with TME, 1.2 or 1.3",
+		EnvVar: "TME_TLS_MIN_VERSION",
+	})
+	tmePins := cfg.Strings(cli.StringsOpt{
+		Name:   "tme-pins",
+		Value:  []string{},
+		Desc:   "Public keys TME may present, each as sha256/<base64 SHA-256 hash of the key>. Connections to TME fail unless a certificate of its chain matches one",
+		EnvVar: "TME_PINS",
+	})
+	tmeTLSInsecure := cfg.Bool(cli.BoolOpt{
+		Name:   "tme-tls-insecure-skip-verify",
+		Value:  false,
+		Desc:   "Do not verify the TLS certificate of TME. Only for local fake servers, as credentials may be sent to anyone",
+		EnvVar: "TME_TLS_INSECURE_SKIP_VERIFY",
+	})
+	breakerFailures := cfg.Int(cli.IntOpt{
+		Name:   "tme-breaker-failures",
+		Value:  5,
+		Desc:   "Calls to TME in a row, each after its retries, that must fail for the circuit to open, refusing further calls for a while. 0 never opens it",
+		EnvVar: "TME_BREAKER_FAILURES",
+	})
+	breakerOpenFor := cfg.String(cli.StringOpt{
+		Name:   "tme-breaker-open-for",
+		Value:  "30s",
+		Desc:   "Time the circuit to TME stays open before a call is let through to probe TME. It doubles with each failed probe",
+		EnvVar: "TME_BREAKER_OPEN_FOR",
+	})
+	breakerMaxOpenFor := cfg.String(cli.StringOpt{
+		Name:   "tme-breaker-max-open-for",
+		Value:  "10m",
+		Desc:   "Longest time the circuit to TME stays open between probes",
+		EnvVar: "TME_BREAKER_MAX_OPEN_FOR",
+	})
+	port := cfg.Int(cli.IntOpt{
 		Name:   "port",
 		Value:  8080,
 		Desc:   "Port to listen on",
 		EnvVar: "PORT",
 	})
-	maxRecords := app.Int(cli.IntOpt{
+	maxRecords := cfg.Int(cli.IntOpt{
 		Name:   "maxRecords",
 		Value:  int(10000),
 		Desc:   "Maximum records to be queried to TME",
 		EnvVar: "MAX_RECORDS",
 	})
-	slices := app.Int(cli.IntOpt{
+	slices := cfg.Int(cli.IntOpt{
 		Name:   "slices",
 		Value:  int(10),
 		Desc:   "Number of requests to be executed in parallel to TME",
 		EnvVar: "SLICES",
 	})
-	graphiteTCPAddress := app.String(cli.StringOpt{
+	graphiteTCPAddress := cfg.String(cli.StringOpt{
 		Name:   "graphiteTCPAddress",
 		Value:  "",
 		Desc:   "Graphite TCP address, e.g. graphite.ft.com:2003. Leave as default if you do NOT want to output to graphite (e.g. if running locally)",
 		EnvVar: "GRAPHITE_ADDRESS",
 	})
-	graphitePrefix := app.String(cli.StringOpt{
+	graphitePrefix := cfg.String(cli.StringOpt{
 		Name:   "graphitePrefix",
 		Value:  "",
 		Desc:   "Prefix to use. Should start with content, include the environment, and the host name. e.g. content.test.public.content.by.concept.api.ftaps59382-law1a-eu-t",
 		EnvVar: "GRAPHITE_PREFIX",
 	})
-	logMetrics := app.Bool(cli.BoolOpt{
+	cfg.Int(cli.IntOpt{
+		Name:   "minLocations",
+		Value:  0,
+		Desc:   "Minimum number of locations a load from TME must contain to be applied",
+		EnvVar: "MIN_LOCATIONS",
+	})
+	cfg.Int(cli.IntOpt{
+		Name:   "maxDropPercent",
+		Value:  50,
+		Desc:   "Maximum percentage drop in the number of locations versus the ones currently served for a load from TME to be applied. 0 disables the check",
+		EnvVar: "MAX_DROP_PERCENT",
+	})
+	adminToken := cfg.String(cli.StringOpt{
+		Name:   "admin-token",
+		Value:  "",
+		Desc:   "API key with the admin role, kept for existing deployments. Prefer api-keys",
+		EnvVar: "ADMIN_TOKEN",
+	})
+	apiKeys := cfg.Strings(cli.StringsOpt{
+		Name:   "api-keys",
+		Value:  []string{},
+		Desc:   "API keys, each as name:role:key where role is read or admin, presented as a bearer token or in the X-Api-Key header. Admin endpoints are disabled without an admin key",
+		EnvVar: "API_KEYS",
+	})
+	anonymousRead := cfg.Bool(cli.BoolOpt{
+		Name:   "anonymous-read",
+		Value:  true,
+		Desc:   "Serve read endpoints to clients without an API key",
+		EnvVar: "ANONYMOUS_READ",
+	})
+	rateLimits := cfg.Strings(cli.StringsOpt{
+		Name:   "rate-limits",
+		Value:  []string{},
+		Desc:   "Requests a second and burst each client may make to a route, each as route:rate:burst, e.g. location:20:40. A limit for the default route applies to every route without one of its own. Clients are told apart by API key, or else by address",
+		EnvVar: "RATE_LIMITS",
+	})
+	trustedProxies := cfg.Strings(cli.StringsOpt{
+		Name:   "trusted-proxies",
+		Value:  []string{},
+		Desc:   "Addresses or CIDR networks of the proxies in front of the service, whose X-Forwarded-For headers give the address of clients for rate limits",
+		EnvVar: "TRUSTED_PROXIES",
+	})
+	concurrencyLimits := cfg.Strings(cli.StringsOpt{
+		Name:   "concurrency-limits",
+		Value:  []string{"dump:4", "search:16"},
+		Desc:   "Requests to a route served at once across all clients, each as route:max",
+		EnvVar: "CONCURRENCY_LIMITS",
+	})
+	cfg.String(cli.StringOpt{
+		Name:   "max-staleness",
+		Value:  "48h",
+		Desc:   "Age of the locations served, since they were fetched from TME, above which the staleness health check fails, e.g. 36h. 0 disables the check",
+		EnvVar: "MAX_STALENESS",
+	})
+	cfg.Int(cli.IntOpt{
+		Name:   "max-count-deviation-percent",
+		Value:  10,
+		Desc:   "Percentage change in the number of locations from one load to the next above which the count deviation health check fails. 0 disables the check",
+		EnvVar: "MAX_COUNT_DEVIATION_PERCENT",
+	})
+	cfg.Int(cli.IntOpt{
+		Name:   "max-validation-error-percent",
+		Value:  5,
+		Desc:   "Percentage of the terms of a load that are duplicates or have supplementary data that cannot be applied above which the validation health check fails. 0 disables the check",
+		EnvVar: "MAX_VALIDATION_ERROR_PERCENT",
+	})
+	cfg.String(cli.StringOpt{
+		Name:   "reload-interval",
+		Value:  "0",
+		Desc:   "Interval between scheduled reloads from TME, e.g. 6h. 0 leaves reloads to POST __reload",
+		EnvVar: "RELOAD_INTERVAL",
+	})
+	drainDelay := cfg.String(cli.StringOpt{
+		Name:   "drain-delay",
+		Value:  "5s",
+		Desc:   "Time between failing readiness on SIGTERM and no longer accepting requests, for load balancers to stop routing to the instance",
+		EnvVar: "DRAIN_DELAY",
+	})
+	drainTimeout := cfg.String(cli.StringOpt{
+		Name:   "drain-timeout",
+		Value:  "30s",
+		Desc:   "Time requests in flight, such as dumps, are given to finish on shutdown before being cut off",
+		EnvVar: "DRAIN_TIMEOUT",
+	})
+	tracingExporter := cfg.String(cli.StringOpt{
+		Name:   "tracing-exporter",
+		Value:  "none",
+		Desc:   "Where to send OpenTelemetry spans: none, stdout, or otlp, an OTLP collector set by the standard OTEL_EXPORTER_OTLP_ENDPOINT variables",
+		EnvVar: "TRACING_EXPORTER",
+	})
+	tracingSampleRatio := cfg.String(cli.StringOpt{
+		Name:   "tracing-sample-ratio",
+		Value:  "1",
+		Desc:   "Share of traces started by the service that are recorded, between 0 and 1. Traces started by callers follow their sampling decision",
+		EnvVar: "TRACING_SAMPLE_RATIO",
+	})
+	uuidStrategyName := cfg.String(cli.StringOpt{
+		Name:   "uuid-strategy",
+		Value:  "md5",
+		Desc:   "How location UUIDs are derived from TME identifiers, md5 or sha1. Changing it changes every location UUID",
+		EnvVar: "UUID_STRATEGY",
+	})
+	uuidNamespace := cfg.String(cli.StringOpt{
+		Name:   "uuid-namespace",
+		Value:  "",
+		Desc:   "Namespace UUID used by the sha1 strategy. Defaults to the nil UUID 00000000-0000-0000-0000-000000000000",
+		EnvVar: "UUID_NAMESPACE",
+	})
+	uuidOverridesFile := cfg.String(cli.StringOpt{
+		Name:   "uuid-overrides-file",
+		Value:  "",
+		Desc:   "Path to a JSON file mapping TME identifiers to hand curated UUIDs",
+		EnvVar: "UUID_OVERRIDES_FILE",
+	})
+	classificationRulesFile := cfg.String(cli.StringOpt{
+		Name:   "classification-rules-file",
+		Value:  "",
+		Desc:   "Path to a JSON file of rules classifying locations into types such as Country or City, tried before the built in ones",
+		EnvVar: "CLASSIFICATION_RULES_FILE",
+	})
+	concordancesFile := cfg.String(cli.StringOpt{
+		Name:   "concordances-file",
+		Value:  "",
+		Desc:   "Path to a CSV or JSON file mapping TME identifiers to GeoNames, Wikidata and ISO3166 identifiers. It is reread on every reload",
+		EnvVar: "CONCORDANCES_FILE",
+	})
+	gazetteerFile := cfg.String(cli.StringOpt{
+		Name:   "gazetteer-file",
+		Value:  "",
+		Desc:   "Path to a CSV file of coordinates and bounding boxes by TME identifier, taking precedence over TME. It is reread on every reload",
+		EnvVar: "GAZETTEER_FILE",
+	})
+	labelsFile := cfg.String(cli.StringOpt{
+		Name:   "labels-file",
+		Value:  "",
+		Desc:   "Path to a CSV file of language tagged labels by TME identifier, adding to those held in TME. It is reread on every reload",
+		EnvVar: "LABELS_FILE",
+	})
+	mergesFile := cfg.String(cli.StringOpt{
+		Name:   "merges-file",
+		Value:  "",
+		Desc:   "Path to a CSV file of TME identifiers merged into other TME identifiers. It is reread on every reload",
+		EnvVar: "MERGES_FILE",
+	})
+	overridesFile := cfg.String(cli.StringOpt{
+		Name:   "overrides-file",
+		Value:  "",
+		Desc:   "Path to the JSON file where manual overrides of locations and their audit trail are kept. Defaults to overrides.json in state-dir",
+		EnvVar: "OVERRIDES_FILE",
+	})
+	stateDir := cfg.String(cli.StringOpt{
+		Name:   "state-dir",
+		Value:  "",
+		Desc:   "Directory where state that must survive restarts, such as deprecated locations, is kept. Without it that state is held in memory only",
+		EnvVar: "STATE_DIR",
+	})
+	logMetrics := cfg.Bool(cli.BoolOpt{
 		Name:   "logMetrics",
 		Value:  false,
 		Desc:   "Whether to log metrics. Set to true if running locally and you want metrics output",
 		EnvVar: "LOG_METRICS",
 	})
 
-	tmeTaxonomyName := "GL"
+	taxonomyEntries := cfg.Strings(cli.StringsOpt{
+		Name:   "taxonomies",
+		Value:  []string{"GL:Location:/transformers/locations"},
+		Desc:   "TME taxonomies to serve, each as name:type:/route/prefix[:baseURL]. Without a base url, links use base-url with its route prefix swapped for the taxonomy's",
+		EnvVar: "TAXONOMIES",
+	})
 
 	app.Action = func() {
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("Error while configuring the service: [%v]", err.Error())
+		}
 		baseftrwapp.OutputMetricsIfRequired(*graphiteTCPAddress, *graphitePrefix, *logMetrics)
-		client := getResilientClient()
+		ratio, err := strconv.ParseFloat(*tracingSampleRatio, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			log.Fatalf("Error while configuring tracing: [invalid sample ratio %s]", *tracingSampleRatio)
+		}
+		tp, err := newTracerProvider(*tracingExporter, ratio)
+		if err != nil {
+			log.Fatalf("Error while configuring tracing: [%v]", err.Error())
+		}
+		tlsConfig, err := newTLSConfig(tlsOptions{
+			caFile:     *tmeCAFile,
+			certFile:   *tmeClientCertFile,
+			keyFile:    *tmeClientKeyFile,
+			minVersion: *tmeTLSMinVersion,
+			pins:       *tmePins,
+			insecure:   *tmeTLSInsecure,
+		})
+		if err != nil {
+			log.Fatalf("Error while configuring TLS for TME: [%v]", err.Error())
+		}
+		transport := getTMETransport(tlsConfig)
+		client := getResilientClient(transport)
+		taxonomies, err := parseTaxonomies(*taxonomyEntries, *baseURL)
+		if err != nil {
+			log.Fatalf("Error while configuring taxonomies: [%v]", err.Error())
+		}
+		auth, err := newAuthenticator(*apiKeys, *adminToken, *anonymousRead)
+		if err != nil {
+			log.Fatalf("Error while configuring API keys: [%v]", err.Error())
+		}
+		limits, err := newLimiter(*rateLimits, *concurrencyLimits, *trustedProxies, auth)
+		if err != nil {
+			log.Fatalf("Error while configuring limits: [%v]", err.Error())
+		}
+		refresh, err := time.ParseDuration(*credentialsRefresh)
+		if err != nil {
+			log.Fatalf("Error while configuring TME credentials: [%v]", err.Error())
+		}
+		secretsClient := &http.Client{Timeout: 10 * time.Second}
+		provider, err := newCredentialsProvider(*credentialsProviderName, credentials{username: *username, password: *password, token: *token}, *credentialsDir, *credentialsURL, *credentialsURLToken, refresh, secretsClient)
+		if err != nil {
+			log.Fatalf("Error while configuring TME credentials: [%v]", err.Error())
+		}
+		openFor, err := time.ParseDuration(*breakerOpenFor)
+		if err != nil {
+			log.Fatalf("Error while configuring the TME circuit breaker: [%v]", err.Error())
+		}
+		maxOpenFor, err := time.ParseDuration(*breakerMaxOpenFor)
+		if err != nil {
+			log.Fatalf("Error while configuring the TME circuit breaker: [%v]", err.Error())
+		}
+		breaker := newCircuitBreaker(*breakerFailures, openFor, maxOpenFor)
+		// The ping goes through the breaker, so health checks do not call TME while the circuit is open.
+		ping := newTMEPing(credentialsClient{next: breaker.client(&http.Client{Transport: transport, Timeout: 10 * time.Second}), provider: provider}, *tmeBaseURL)
+		health := func() healthPolicy {
+			return healthPolicy{
+				maxStaleness:              cfg.duration("max-staleness"),
+				maxCountDeviationPercent:  cfg.int("max-count-deviation-percent"),
+				maxValidationErrorPercent: cfg.int("max-validation-error-percent"),
+				ping:                      ping,
+			}
+		}
+		guard := func() snapshotGuard {
+			return snapshotGuard{minCount: cfg.int("minLocations"), maxDropPercent: cfg.int("maxDropPercent")}
+		}
+		delay, err := time.ParseDuration(*drainDelay)
+		if err != nil {
+			log.Fatalf("Error while configuring shutdown: [%v]", err.Error())
+		}
+		timeout, err := time.ParseDuration(*drainTimeout)
+		if err != nil {
+			log.Fatalf("Error while configuring shutdown: [%v]", err.Error())
+		}
+		uuids, err := newUUIDStrategy(*uuidStrategyName, *uuidNamespace, *uuidOverridesFile)
+		if err != nil {
+			log.Fatalf("Error while configuring UUID strategy: [%v]", err.Error())
+		}
 
-		mf := new(locationTransformer)
-		s, err := newLocationService(tmereader.NewTmeRepository(client, *tmeBaseURL, *username, *password, *token, *maxRecords, *slices, tmeTaxonomyName, &tmereader.AuthorityFiles{}, mf), *baseURL, tmeTaxonomyName, *maxRecords)
+		classifier, err := newClassifier(*classificationRulesFile)
+		if err != nil {
+			log.Fatalf("Error while configuring classification: [%v]", err.Error())
+		}
+
+		var enrichers []enricher
+		if *concordancesFile != "" {
+			c, err := newConcordances(*concordancesFile)
+			if err != nil {
+				log.Fatalf("Error while reading concordances: [%v]", err.Error())
+			}
+			enrichers = append(enrichers, c)
+		}
+		if *gazetteerFile != "" {
+			g, err := newGazetteer(*gazetteerFile)
+			if err != nil {
+				log.Fatalf("Error while reading gazetteer: [%v]", err.Error())
+			}
+			enrichers = append(enrichers, g)
+		}
+		if *labelsFile != "" {
+			l, err := newLabels(*labelsFile)
+			if err != nil {
+				log.Fatalf("Error while reading labels: [%v]", err.Error())
+			}
+			enrichers = append(enrichers, l)
+		}
+		overridesPath := *overridesFile
+		if overridesPath == "" && *stateDir != "" {
+			overridesPath = filepath.Join(*stateDir, "overrides.json")
+		}
+		overrides, err := newOverrideStore(overridesPath)
 		if err != nil {
-			log.Errorf("Error while creating LocationsService: [%v]", err.Error())
+			log.Fatalf("Error while reading overrides: [%v]", err.Error())
 		}
+		enrichers = append(enrichers, overrides)
 
-		h := newLocationsHandler(s)
+		var merges *supplementaryFile
+		if *mergesFile != "" {
+			merges, err = newMerges(*mergesFile)
+			if err != nil {
+				log.Fatalf("Error while reading merges: [%v]", err.Error())
+			}
+		}
+
+		mf := new(locationTransformer)
 		m := mux.NewRouter()
+		var checks []v1a.Check
+		var g2gCheckers []gtg.StatusChecker
+		var services []locationService
+		var handlers []*locationsHandler
+		var loadNames []string
+		var loads []locationService
+		sched := newScheduler()
+		sched.unavailable = breaker.isOpen
+		for _, taxonomy := range taxonomies {
+			repo := newTracedRepository(taxonomy.name)
+			repo.Repository = tmereader.NewTmeRepository(repo.client(credentialsClient{next: breaker.client(client), provider: provider}), *tmeBaseURL, "", "", "", *maxRecords, *slices, taxonomy.name, &tmereader.AuthorityFiles{}, mf)
+			var deprecationsPath string
+			if *stateDir != "" {
+				deprecationsPath = filepath.Join(*stateDir, "deprecations-"+taxonomy.name+".json")
+			}
+			s, err := createLocationService(repo, taxonomy, *maxRecords, guard(), uuids, classifier, enrichers, newDeprecations(merges, deprecationsPath))
+			if err != nil {
+				log.Errorf("Error while creating LocationsService for taxonomy %s: [%v]", taxonomy.name, err.Error())
+			} else {
+				loadNames = append(loadNames, taxonomy.name)
+				loads = append(loads, s)
+			}
 
-		m.HandleFunc("/transformers/locations", h.getLocations).Methods("GET")
-		m.HandleFunc("/transformers/locations/__count", h.getCount).Methods("GET")
-		m.HandleFunc("/transformers/locations/__ids", h.getIds).Methods("GET")
-		m.HandleFunc("/transformers/locations/__reload", h.reload).Methods("POST")
-		m.HandleFunc("/transformers/locations/{uuid:([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})}", h.getLocationByUUID).Methods("GET")
+			h := newLocationsHandler(s, taxonomy, auth, limits, health())
+			h.registerRoutes(m)
+			checks = append(checks, h.Checks()...)
+			g2gCheckers = append(g2gCheckers, h.G2GCheck)
+			services = append(services, s)
+			handlers = append(handlers, &h)
+			sched.add(taxonomy.name, s)
+		}
+		checks = append(checks, breaker.healthCheck())
+		oh := newOverridesHandler(overrides, services, auth, limits)
+		oh.registerRoutes(m)
+		m.Use(routeSpans)
+		ah, err := newAPIHandler(taxonomies)
+		if err != nil {
+			log.Fatalf("Error while building the API document: [%v]", err.Error())
+		}
+		ah.registerRoutes(m)
+		lc := newLifecycle(services, delay, timeout)
+		registerInstanceRoutes(m, cfg, auth, limits, lc.ready(allGoodToGo(g2gCheckers)))
 
 		var monitoringRouter http.Handler = m
+		monitoringRouter = compressionHandler(monitoringRouter)
 		monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), monitoringRouter)
 		monitoringRouter = httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry, monitoringRouter)
+		monitoringRouter = tracingHandler(monitoringRouter)
 
 		http.HandleFunc(status.PingPath, status.PingHandler)
 		http.HandleFunc(status.PingPathDW, status.PingHandler)
 		http.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
 		http.HandleFunc(status.BuildInfoPathDW, status.BuildInfoHandler)
 
-		http.HandleFunc("/__health", v1a.Handler("Locations Transformer Healthchecks", "Checks for accessing TME", h.HealthCheck()))
-		g2gHandler := status.NewGoodToGoHandler(gtg.StatusChecker(h.G2GCheck))
+		http.HandleFunc("/__health", v1a.Handler("Locations Transformer Healthchecks", "Checks for accessing TME", checks...))
+		g2gHandler := status.NewGoodToGoHandler(allGoodToGo(g2gCheckers))
 		http.HandleFunc(status.GTGPath, g2gHandler)
-
 		http.Handle("/", monitoringRouter)
 
-		log.Printf("listening on %d", *port)
-		err = http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+		server := &http.Server{Addr: fmt.Sprintf(":%d", *port)}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		go func() {
+			<-signals
+			sched.stop()
+			lc.shutdown(server)
+		}()
+		go sched.run(cfg.duration("reload-interval"))
+		go func() {
+			hangups := make(chan os.Signal, 1)
+			signal.Notify(hangups, syscall.SIGHUP)
+			for range hangups {
+				changed, err := cfg.reload()
+				if err != nil {
+					log.Errorf("Error while reloading the config file, keeping the current settings: [%v]", err.Error())
+					continue
+				}
+				if len(changed) == 0 {
+					log.Info("Reloaded the config file, no settings changed")
+					continue
+				}
+				log.Infof("Reloaded the config file, changing [%s]", strings.Join(changed, ", "))
+				for _, s := range services {
+					s.setGuard(guard())
+				}
+				for _, h := range handlers {
+					h.setHealthPolicy(health())
+				}
+				for _, name := range changed {
+					if name == "reload-interval" {
+						sched.setInterval(cfg.duration("reload-interval"))
+					}
+				}
+			}
+		}()
+
+		listener, err := net.Listen("tcp", server.Addr)
 		if err != nil {
 			log.Errorf("Error by listen and serve: %v", err.Error())
+			return
 		}
+		log.Printf("listening on %d", *port)
+		// The first loads run once the instance is serving, so it can be health checked and shut down while they do.
+		go lc.load(loadNames, loads)
+		err = server.Serve(listener)
+		if err != http.ErrServerClosed {
+			log.Errorf("Error by listen and serve: %v", err.Error())
+			return
+		}
+		lc.wait()
+		if tp != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Warnf("Could not send the last spans: %v", err)
+			}
+		}
+		log.Info("Shut down")
 
 	}
 	app.Run(os.Args)
 }
 
-func getResilientClient() *pester.Client {
-	tr := &http.Transport{
+// registerInstanceRoutes registers the endpoints about the instance rather than a taxonomy on the router, so they are
+// traced and logged like the others.
+func registerInstanceRoutes(m *mux.Router, cfg *settings, auth *authenticator, limits *limiter, ready gtg.StatusChecker) {
+	m.HandleFunc("/metrics", prometheusHandler(metrics.DefaultRegistry)).Methods("GET")
+	m.HandleFunc(readyPath, status.NewGoodToGoHandler(ready)).Methods("GET")
+	m.HandleFunc(configPath, timed("routes.config.latency", configHandler(cfg, auth, limits))).Methods("GET")
+}
+
+// allGoodToGo is good to go only when every taxonomy is.
+func allGoodToGo(checkers []gtg.StatusChecker) gtg.StatusChecker {
+	return func() gtg.Status {
+		for _, c := range checkers {
+			if st := c(); !st.GoodToGo {
+				return st
+			}
+		}
+		return gtg.Status{GoodToGo: true}
+	}
+}
+
+func getTMETransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
 		MaxIdleConnsPerHost: 128,
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig:     tlsConfig,
 		Dial: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
 		}).Dial,
 	}
+}
+
+func getResilientClient(tr http.RoundTripper) *pester.Client {
 	c := &http.Client{
-		Transport: tr,
+		Transport: otelhttp.NewTransport(timedTransport{next: tr}),
 		Timeout:   time.Duration(30 * time.Second),
 	}
 	client := pester.NewExtendedClient(c)
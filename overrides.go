@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errNoOverride = errors.New("No override for this uuid")
+
+// override patches fields of a location, by UUID, until TME is corrected. Empty fields are left as they are in TME,
+// while labels are set for each language given, and alternative labels added to those from TME. The author is the
+// name of the API key that set it; the editor is whoever the client says made the change, if it says.
+type override struct {
+	UUID        string              `json:"uuid"`
+	PrefLabel   string              `json:"prefLabel,omitempty"`
+	PrefLabels  map[string]string   `json:"prefLabels,omitempty"`
+	AltLabels   map[string][]string `json:"altLabels,omitempty"`
+	Type        string              `json:"type,omitempty"`
+	Coordinates *coordinates        `json:"coordinates,omitempty"`
+	Author      string              `json:"author"`
+	Editor      string              `json:"editor,omitempty"`
+	Reason      string              `json:"reason"`
+	UpdatedAt   time.Time           `json:"updatedAt"`
+}
+
+// overrideStatus tells whether an override was applied by the latest load, and whether TME already holds what it sets.
+type overrideStatus struct {
+	Taxonomy      string    `json:"taxonomy"`
+	MatchesSource bool      `json:"matchesSource"`
+	CheckedAt     time.Time `json:"checkedAt"`
+}
+
+type overrideView struct {
+	override
+	Status *overrideStatus `json:"status,omitempty"`
+}
+
+// overrideChange is an entry of the audit trail kept with the overrides.
+type overrideChange struct {
+	UUID     string    `json:"uuid"`
+	Action   string    `json:"action"`
+	Author   string    `json:"author"`
+	Editor   string    `json:"editor,omitempty"`
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+	Override *override `json:"override,omitempty"`
+}
+
+// validate normalises the language tags of an override and checks it changes something and says who changed it and why.
+func (o *override) validate() error {
+	if o.Reason == "" {
+		return errors.New("an override needs a reason")
+	}
+	if o.Author == "" {
+		return errors.New("an override needs an author")
+	}
+	if o.PrefLabel == "" && len(o.PrefLabels) == 0 && len(o.AltLabels) == 0 && o.Type == "" && o.Coordinates == nil {
+		return errors.New("an override must set prefLabel, prefLabels, altLabels, type or coordinates")
+	}
+	if o.Coordinates != nil {
+		if err := o.Coordinates.validate(); err != nil {
+			return err
+		}
+	}
+	prefLabels := make(map[string]string, len(o.PrefLabels))
+	for tag, label := range o.PrefLabels {
+		language, err := canonicalLanguage(tag)
+		if err != nil {
+			return err
+		}
+		prefLabels[language] = label
+	}
+	altLabels := make(map[string][]string, len(o.AltLabels))
+	for tag, labels := range o.AltLabels {
+		language, err := canonicalLanguage(tag)
+		if err != nil {
+			return err
+		}
+		altLabels[language] = append(altLabels[language], labels...)
+	}
+	if len(prefLabels) > 0 {
+		o.PrefLabels = prefLabels
+	}
+	if len(altLabels) > 0 {
+		o.AltLabels = altLabels
+	}
+	return nil
+}
+
+func (o override) apply(l location) location {
+	if o.PrefLabel != "" {
+		l.PrefLabel = o.PrefLabel
+	}
+	for language, label := range o.PrefLabels {
+		l = l.withLabel(language, label, true)
+	}
+	for language, labels := range o.AltLabels {
+		for _, label := range labels {
+			l = l.withLabel(language, label, false)
+		}
+	}
+	if o.Type != "" {
+		l.Type = o.Type
+		l.Types = typeAncestry(o.Type)
+	}
+	if o.Coordinates != nil {
+		c := *o.Coordinates
+		l.Coordinates = &c
+	}
+	return l
+}
+
+// matches tells whether the location already holds everything the override sets, in which case it can be removed.
+func (o override) matches(l location) bool {
+	return reflect.DeepEqual(o.apply(l), l)
+}
+
+// overrideStore keeps the overrides and their audit trail, persisting both to a JSON file on every change.
+// It is an enricher applied after every other one, so overrides win over TME and supplementary files alike.
+type overrideStore struct {
+	sync.Mutex
+	path      string
+	overrides map[string]override
+	audit     []overrideChange
+	status    map[string]overrideStatus
+}
+
+type overridesFile struct {
+	Overrides []override       `json:"overrides"`
+	Audit     []overrideChange `json:"audit"`
+}
+
+// newOverrideStore reads the overrides persisted at path, if any. With an empty path overrides are held in memory only.
+func newOverrideStore(path string) (*overrideStore, error) {
+	store := &overrideStore{path: path, overrides: make(map[string]override), audit: []overrideChange{}, status: make(map[string]overrideStatus)}
+	if path == "" {
+		return store, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f overridesFile
+	if err := json.Unmarshal(contents, &f); err != nil {
+		return nil, fmt.Errorf("Could not parse overrides file %s: %v", path, err)
+	}
+	for _, o := range f.Overrides {
+		if err := o.validate(); err != nil {
+			return nil, fmt.Errorf("Override for %s in %s: %v", o.UUID, path, err)
+		}
+		store.overrides[o.UUID] = o
+	}
+	if f.Audit != nil {
+		store.audit = f.Audit
+	}
+	return store, nil
+}
+
+func (s *overrideStore) enrich(taxonomy taxonomyConfig, locations locationsMap) []enrichmentIssue {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now().UTC()
+	for uuid, o := range s.overrides {
+		l, found := locations[uuid]
+		if !found {
+			continue
+		}
+		matches := o.matches(l)
+		if matches {
+			log.Warnf("Override of location with uuid=%s matches TME and can be removed", uuid)
+		}
+		s.status[uuid] = overrideStatus{Taxonomy: taxonomy.name, MatchesSource: matches, CheckedAt: now}
+		locations[uuid] = o.apply(l)
+	}
+	s.updateGauge()
+	return nil
+}
+
+func (s *overrideStore) updateGauge() {
+	matching := 0
+	for uuid := range s.overrides {
+		if s.status[uuid].MatchesSource {
+			matching++
+		}
+	}
+	metrics.GetOrRegisterGauge("overrides.count", metrics.DefaultRegistry).Update(int64(len(s.overrides)))
+	metrics.GetOrRegisterGauge("overrides.matching_source", metrics.DefaultRegistry).Update(int64(matching))
+}
+
+func (s *overrideStore) list() []overrideView {
+	s.Lock()
+	defer s.Unlock()
+	views := make([]overrideView, 0, len(s.overrides))
+	for uuid, o := range s.overrides {
+		views = append(views, s.view(uuid, o))
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].UUID < views[j].UUID
+	})
+	return views
+}
+
+func (s *overrideStore) get(uuid string) (overrideView, bool) {
+	s.Lock()
+	defer s.Unlock()
+	o, found := s.overrides[uuid]
+	if !found {
+		return overrideView{}, false
+	}
+	return s.view(uuid, o), true
+}
+
+func (s *overrideStore) view(uuid string, o override) overrideView {
+	v := overrideView{override: o}
+	if st, found := s.status[uuid]; found {
+		v.Status = &st
+	}
+	return v
+}
+
+func (s *overrideStore) history() []overrideChange {
+	s.Lock()
+	defer s.Unlock()
+	return append([]overrideChange{}, s.audit...)
+}
+
+// set adds or replaces the override of a location. Nothing changes unless it could be persisted.
+func (s *overrideStore) set(o override) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	o.UpdatedAt = time.Now().UTC()
+	previous, existed := s.overrides[o.UUID]
+	s.overrides[o.UUID] = o
+	saved := o
+	s.audit = append(s.audit, overrideChange{UUID: o.UUID, Action: "set", Author: o.Author, Editor: o.Editor, Reason: o.Reason, At: o.UpdatedAt, Override: &saved})
+	if err := s.save(); err != nil {
+		s.audit = s.audit[:len(s.audit)-1]
+		if existed {
+			s.overrides[o.UUID] = previous
+		} else {
+			delete(s.overrides, o.UUID)
+		}
+		return err
+	}
+	delete(s.status, o.UUID)
+	log.Infof("Override of location with uuid=%s set by %s: %s", o.UUID, o.Author, o.Reason)
+	return nil
+}
+
+// remove deletes the override of a location, recording who removed it and why. Nothing changes unless it could be persisted.
+func (s *overrideStore) remove(uuid string, change overrideChange) error {
+	if change.Author == "" || change.Reason == "" {
+		return errors.New("removing an override needs an author and a reason")
+	}
+	s.Lock()
+	defer s.Unlock()
+	previous, found := s.overrides[uuid]
+	if !found {
+		return errNoOverride
+	}
+	delete(s.overrides, uuid)
+	s.audit = append(s.audit, overrideChange{UUID: uuid, Action: "remove", Author: change.Author, Editor: change.Editor, Reason: change.Reason, At: time.Now().UTC()})
+	if err := s.save(); err != nil {
+		s.audit = s.audit[:len(s.audit)-1]
+		s.overrides[uuid] = previous
+		return err
+	}
+	delete(s.status, uuid)
+	log.Infof("Override of location with uuid=%s removed by %s: %s", uuid, change.Author, change.Reason)
+	return nil
+}
+
+// save persists the overrides and their audit trail. Callers must hold the lock.
+func (s *overrideStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	f := overridesFile{Overrides: make([]override, 0, len(s.overrides)), Audit: s.audit}
+	for _, o := range s.overrides {
+		f.Overrides = append(f.Overrides, o)
+	}
+	sort.Slice(f.Overrides, func(i, j int) bool {
+		return f.Overrides[i].UUID < f.Overrides[j].UUID
+	})
+	contents, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.path, contents)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverrideValidate(t *testing.T) {
+	o := override{PrefLabels: map[string]string{"zh_hant": "慕尼黑"}, Author: "editor", Reason: "Missing label"}
+	assert.NoError(t, o.validate())
+	assert.Equal(t, map[string]string{"zh-Hant": "慕尼黑"}, o.PrefLabels)
+
+	for _, invalid := range []override{
+		{PrefLabel: "Munich", Reason: "No author"},
+		{PrefLabel: "Munich", Author: "editor"},
+		{Author: "editor", Reason: "Nothing to change"},
+		{AltLabels: map[string][]string{"not a tag": {"Minga"}}, Author: "editor", Reason: "Bad language"},
+		{Coordinates: &coordinates{Latitude: 91}, Author: "editor", Reason: "Bad coordinates"},
+	} {
+		assert.Error(t, invalid.validate(), "%+v", invalid)
+	}
+}
+
+func TestOverrideApply(t *testing.T) {
+	tme := location{UUID: testUUID, PrefLabel: "Munchen", Type: "Location", Types: typeAncestry("Location"), AltLabels: map[string][]string{"de": {"Muenchen"}}}
+	o := override{UUID: testUUID, PrefLabel: "Munich", AltLabels: map[string][]string{"de": {"Minga"}}, Type: "City", Coordinates: &coordinates{Latitude: 48.1351, Longitude: 11.582}}
+
+	patched := o.apply(tme)
+	assert.Equal(t, "Munich", patched.PrefLabel)
+	assert.Equal(t, map[string][]string{"de": {"Muenchen", "Minga"}}, patched.AltLabels)
+	assert.Equal(t, []string{"Thing", "Concept", "Location", "City"}, patched.Types)
+	assert.Equal(t, map[string][]string{"de": {"Muenchen"}}, tme.AltLabels)
+
+	assert.False(t, o.matches(tme))
+	assert.True(t, o.matches(patched))
+}
+
+func TestOverrideStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overrides")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.json")
+
+	store, err := newOverrideStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.set(override{UUID: testUUID, PrefLabel: "Paris", Author: "editor", Reason: "Typo in TME"}))
+	assert.NoError(t, store.set(override{UUID: london.UUID, PrefLabel: "London", Author: "editor", Reason: "Typo in TME"}))
+	assert.NoError(t, store.remove(london.UUID, overrideChange{Author: "editor", Reason: "Fixed in TME"}))
+	assert.Equal(t, errNoOverride, store.remove(london.UUID, overrideChange{Author: "editor", Reason: "Again"}))
+
+	locations := locationsMap{testUUID: {UUID: testUUID, PrefLabel: "Pariss"}}
+	store.enrich(glTaxonomy, locations)
+	assert.Equal(t, "Paris", locations[testUUID].PrefLabel)
+	v, found := store.get(testUUID)
+	assert.True(t, found)
+	assert.Equal(t, &overrideStatus{Taxonomy: "GL", MatchesSource: false, CheckedAt: v.Status.CheckedAt}, v.Status)
+
+	locations = locationsMap{testUUID: {UUID: testUUID, PrefLabel: "Paris"}}
+	store.enrich(glTaxonomy, locations)
+	v, _ = store.get(testUUID)
+	assert.True(t, v.Status.MatchesSource)
+
+	reopened, err := newOverrideStore(path)
+	assert.NoError(t, err)
+	assert.Len(t, reopened.list(), 1)
+	history := reopened.history()
+	assert.Len(t, history, 3)
+	assert.Equal(t, []string{"set", "set", "remove"}, []string{history[0].Action, history[1].Action, history[2].Action})
+	assert.Equal(t, "Fixed in TME", history[2].Reason)
+}
+
+func TestOverrideStoreKeepsStateWhenSaveFails(t *testing.T) {
+	store, err := newOverrideStore(filepath.Join(os.TempDir(), "missing-directory", "overrides.json"))
+	assert.NoError(t, err)
+	assert.Error(t, store.set(override{UUID: testUUID, PrefLabel: "Paris", Author: "editor", Reason: "Typo in TME"}))
+	assert.Empty(t, store.list())
+	assert.Empty(t, store.history())
+}
+
+func TestOverridesRefreshServices(t *testing.T) {
+	store, err := newOverrideStore("")
+	assert.NoError(t, err)
+	repo := dummyRepo{terms: []term{{CanonicalName: "Pariss", RawID: "paris"}}}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, []enricher{store}, &deprecations{})
+	assert.NoError(t, err)
+	uuid := service.getLocationIds("")[0]
+	repo.err = errors.New("TME is not asked again")
+
+	m := mux.NewRouter()
+	h := newOverridesHandler(store, []locationService{service}, testAuth, testLimits)
+	h.registerRoutes(m)
+
+	put := func(token string, body string) *httptest.ResponseRecorder {
+		req := newAdminRequest("PUT", "/__overrides/"+uuid, token)
+		req.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusUnauthorized, put("wrong", `{"prefLabel":"Paris","author":"editor","reason":"Typo"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put(testAdminToken, `{"prefLabel":"Paris"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, put(testAdminToken, `not json`).Code)
+	assert.Equal(t, http.StatusOK, put(testAdminToken, `{"prefLabel":"Paris","author":"someone else","editor":"jane.doe","reason":"Typo"}`).Code)
+
+	paris, _ := service.getLocationByUUID(uuid)
+	assert.Equal(t, "Paris", paris.PrefLabel)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, newAdminRequest("GET", "/__overrides", testAdminToken))
+	assert.Contains(t, rec.Body.String(), `"prefLabel":"Paris","author":"admin-token","editor":"jane.doe","reason":"Typo"`)
+
+	req := newAdminRequest("DELETE", "/__overrides/"+uuid, testAdminToken)
+	req.Body = ioutil.NopCloser(bytes.NewBufferString(`{"reason":"Fixed in TME"}`))
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	paris, _ = service.getLocationByUUID(uuid)
+	assert.Equal(t, "Pariss", paris.PrefLabel)
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, newAdminRequest("GET", "/__overrides/__audit", testAdminToken))
+	assert.Contains(t, rec.Body.String(), `"action":"remove","author":"admin-token","reason":"Fixed in TME"`)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	// problemTypeBase prefixes the type of every problem. The types identify problems without being meant to be dereferenced.
+	problemTypeBase     = "urn:ft:locations-transformer:problem:"
+	transactionIDHeader = "X-Request-Id"
+)
+
+// problemType is a kind of error, which clients tell apart by its type rather than by its status or wording.
+type problemType struct {
+	name   string
+	title  string
+	status int
+}
+
+var (
+	notLoadedProblem        = problemType{name: "not-loaded", title: "Locations not loaded yet", status: http.StatusServiceUnavailable}
+	notFoundProblem         = problemType{name: "not-found", title: "Not found", status: http.StatusNotFound}
+	retiredProblem          = problemType{name: "retired", title: "Location retired", status: http.StatusGone}
+	reloadInProgressProblem = problemType{name: "reload-in-progress", title: "Reload in progress", status: http.StatusConflict}
+	nothingToApplyProblem   = problemType{name: "nothing-to-apply", title: "Nothing to apply", status: http.StatusConflict}
+	invalidRequestProblem   = problemType{name: "invalid-request", title: "Invalid request", status: http.StatusBadRequest}
+	unauthorisedProblem     = problemType{name: "unauthorised", title: "Unauthorised", status: http.StatusUnauthorized}
+	forbiddenProblem        = problemType{name: "forbidden", title: "Forbidden", status: http.StatusForbidden}
+	tooManyRequestsProblem  = problemType{name: "too-many-requests", title: "Too many requests", status: http.StatusTooManyRequests}
+	internalProblem         = problemType{name: "internal-error", title: "Internal server error", status: http.StatusInternalServerError}
+)
+
+// problem is an error response in the application/problem+json format of RFC 7807.
+type problem struct {
+	Type          string              `json:"type"`
+	Title         string              `json:"title"`
+	Status        int                 `json:"status"`
+	Detail        string              `json:"detail,omitempty"`
+	Instance      string              `json:"instance,omitempty"`
+	TransactionID string              `json:"transactionId,omitempty"`
+	Deprecation   *deprecatedLocation `json:"deprecation,omitempty"`
+}
+
+func newProblem(writer http.ResponseWriter, req *http.Request, pt problemType, detail string) problem {
+	return problem{
+		Type:          problemTypeBase + pt.name,
+		Title:         pt.title,
+		Status:        pt.status,
+		Detail:        detail,
+		Instance:      req.URL.Path,
+		TransactionID: transactionID(writer, req),
+	}
+}
+
+func writeProblem(writer http.ResponseWriter, req *http.Request, pt problemType, detail string) {
+	newProblem(writer, req, pt, detail).write(writer)
+}
+
+func (p problem) write(writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", problemContentType)
+	writer.WriteHeader(p.Status)
+	if err := json.NewEncoder(writer).Encode(p); err != nil {
+		log.Errorf("Error on json encoding=%v\n", err)
+	}
+}
+
+// transactionID is the id the request logging handler read from the request, or made up and set on the response.
+func transactionID(writer http.ResponseWriter, req *http.Request) string {
+	if tid := req.Header.Get(transactionIDHeader); tid != "" {
+		return tid
+	}
+	return writer.Header().Get(transactionIDHeader)
+}
+
+// writeJSONMessage acknowledges an admin request with a message.
+func writeJSONMessage(writer http.ResponseWriter, message string, statusCode int) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	if err := json.NewEncoder(writer).Encode(struct {
+		Message string `json:"message"`
+	}{message}); err != nil {
+		log.Errorf("Error on json encoding=%v\n", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	tests := []struct {
+		name          string
+		requestID     string
+		responseID    string
+		transactionID string
+	}{
+		{"Transaction id from the request", "tid_request", "", "tid_request"},
+		{"Transaction id made up by the logging handler", "", "tid_response", "tid_response"},
+		{"No transaction id", "", "", ""},
+	}
+	for _, test := range tests {
+		req := newRequest("GET", "/transformers/locations/search?q=%22")
+		if test.requestID != "" {
+			req.Header.Set(transactionIDHeader, test.requestID)
+		}
+		rec := httptest.NewRecorder()
+		if test.responseID != "" {
+			rec.Header().Set(transactionIDHeader, test.responseID)
+		}
+		writeProblem(rec, req, invalidRequestProblem, `no location called "Atlantis"`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code, test.name)
+		assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"), test.name)
+		var p problem
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p), test.name)
+		assert.Equal(t, problem{
+			Type:          "urn:ft:locations-transformer:problem:invalid-request",
+			Title:         "Invalid request",
+			Status:        http.StatusBadRequest,
+			Detail:        `no location called "Atlantis"`,
+			Instance:      "/transformers/locations/search",
+			TransactionID: test.transactionID,
+		}, p, test.name)
+	}
+}
+
+func TestWriteJSONMessage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSONMessage(rec, `Override of "Paris" removed`, http.StatusOK)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message":"Override of \"Paris\" removed"}`, rec.Body.String())
+}
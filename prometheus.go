@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// prometheusTemplates turn go-metrics names into labelled Prometheus families. A {label} segment becomes a label, and
+// any other segment, matched literally or by *, part of the family name.
+var prometheusTemplates = []string{
+	"routes.{taxonomy}.{route}.latency",
+	"routes.{route}.latency",
+	"locations.{taxonomy}.*",
+	"ratelimit.{route}.*",
+	"concurrency.{route}.*",
+}
+
+var summaryQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// latencyBuckets and reloadBuckets are the upper bounds, in seconds, of the buckets of the latency histograms of
+// requests and of reloads, which take minutes rather than milliseconds.
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	reloadBuckets  = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800}
+)
+
+var invalidPrometheusName = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// prometheusFamily is the samples of one metric family, which Prometheus wants written together under a single type.
+type prometheusFamily struct {
+	kind    string
+	samples []prometheusSample
+}
+
+// prometheusSample is a line of a family, written in the order of its key.
+type prometheusSample struct {
+	key  string
+	line string
+}
+
+type bySampleKey []prometheusSample
+
+func (s bySampleKey) Len() int           { return len(s) }
+func (s bySampleKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySampleKey) Less(i, j int) bool { return s[i].key < s[j].key }
+
+// bucketedTimer is a timer that also counts its durations in buckets, for Prometheus histograms that can be aggregated
+// across instances. Graphite still gets the percentiles of the timer.
+type bucketedTimer struct {
+	metrics.Timer
+	sync.Mutex
+	bounds []float64
+	counts []int64
+	sum    float64
+}
+
+// getOrRegisterBucketedTimer returns the bucketed timer of a name, registering it with the given bucket bounds, in
+// seconds, if there is none.
+func getOrRegisterBucketedTimer(name string, bounds []float64, registry metrics.Registry) *bucketedTimer {
+	return registry.GetOrRegister(name, func() *bucketedTimer {
+		return &bucketedTimer{Timer: metrics.NewTimer(), bounds: bounds, counts: make([]int64, len(bounds)+1)}
+	}).(*bucketedTimer)
+}
+
+func (t *bucketedTimer) Update(d time.Duration) {
+	t.Timer.Update(d)
+	seconds := d.Seconds()
+	t.Lock()
+	defer t.Unlock()
+	t.counts[sort.SearchFloat64s(t.bounds, seconds)]++
+	t.sum += seconds
+}
+
+func (t *bucketedTimer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
+
+func (t *bucketedTimer) Time(f func()) {
+	start := time.Now()
+	f()
+	t.UpdateSince(start)
+}
+
+// buckets returns the cumulative count of each bucket, the last one being +Inf, and the sum of the durations.
+func (t *bucketedTimer) buckets() ([]int64, float64) {
+	t.Lock()
+	defer t.Unlock()
+	cumulative := make([]int64, len(t.counts))
+	var total int64
+	for i, count := range t.counts {
+		total += count
+		cumulative[i] = total
+	}
+	return cumulative, t.sum
+}
+
+// prometheusHandler serves the metrics of a registry in the Prometheus text format, so Prometheus sees the same
+// numbers as Graphite. Bucketed timers are written as histograms, and other timers and histograms as summaries with
+// the percentiles Graphite is sent.
+func prometheusHandler(registry metrics.Registry) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		families := make(map[string]*prometheusFamily)
+		registry.Each(func(name string, i interface{}) {
+			family, labels := prometheusName(name)
+			switch m := i.(type) {
+			case metrics.Counter:
+				addSample(families, family, "counter", labels, "", float64(m.Count()))
+			case metrics.Gauge:
+				addSample(families, family, "gauge", labels, "", float64(m.Value()))
+			case metrics.GaugeFloat64:
+				addSample(families, family, "gauge", labels, "", m.Value())
+			case metrics.Meter:
+				addSample(families, family, "counter", labels, "", float64(m.Count()))
+			case *bucketedTimer:
+				addHistogram(families, family+"_seconds", labels, m)
+			case metrics.Histogram:
+				s := m.Snapshot()
+				addSummary(families, family, labels, s.Percentiles(summaryQuantiles), s.Mean()*float64(s.Count()), s.Count(), 1)
+			case metrics.Timer:
+				s := m.Snapshot()
+				// The sum is estimated from the mean of the sample, as go-metrics keeps no running total.
+				addSummary(families, family+"_seconds", labels, s.Percentiles(summaryQuantiles), s.Mean()*float64(s.Count()), s.Count(), float64(time.Second))
+			}
+		})
+		names := make([]string, 0, len(families))
+		for name := range families {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		for _, name := range names {
+			f := families[name]
+			sort.Sort(bySampleKey(f.samples))
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.kind)
+			for _, s := range f.samples {
+				buf.WriteString(s.line)
+			}
+		}
+		writer.Header().Set("Content-Type", prometheusContentType)
+		writer.Write(buf.Bytes())
+	}
+}
+
+// prometheusName finds the family and labels of a go-metrics name from the first template it matches.
+func prometheusName(name string) (string, []string) {
+	segments := strings.Split(name, ".")
+	for _, template := range prometheusTemplates {
+		parts := strings.Split(template, ".")
+		if len(parts) != len(segments) {
+			continue
+		}
+		var family, labels []string
+		matched := true
+		for i, part := range parts {
+			switch {
+			case strings.HasPrefix(part, "{"):
+				labels = append(labels, fmt.Sprintf("%s=%q", strings.Trim(part, "{}"), segments[i]))
+			case part == "*" || part == segments[i]:
+				family = append(family, segments[i])
+			default:
+				matched = false
+			}
+		}
+		if matched {
+			return sanitisePrometheusName(strings.Join(family, "_")), labels
+		}
+	}
+	return sanitisePrometheusName(name), nil
+}
+
+func sanitisePrometheusName(name string) string {
+	name = invalidPrometheusName.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func addSample(families map[string]*prometheusFamily, name string, kind string, labels []string, suffix string, value float64) {
+	addSortedSample(families, name, kind, labels, suffix, value, "")
+}
+
+// addSortedSample adds a sample, sorted by its line unless it is given a key.
+func addSortedSample(families map[string]*prometheusFamily, name string, kind string, labels []string, suffix string, value float64, key string) {
+	f, found := families[name]
+	if !found {
+		f = &prometheusFamily{kind: kind}
+		families[name] = f
+	}
+	var l string
+	if len(labels) > 0 {
+		l = "{" + strings.Join(labels, ",") + "}"
+	}
+	line := fmt.Sprintf("%s%s%s %v\n", name, suffix, l, value)
+	if key == "" {
+		key = line
+	}
+	f.samples = append(f.samples, prometheusSample{key: key, line: line})
+}
+
+// addSummary writes a summary, dividing values by unit.
+func addSummary(families map[string]*prometheusFamily, name string, labels []string, percentiles []float64, sum float64, count int64, unit float64) {
+	for i, q := range summaryQuantiles {
+		addSample(families, name, "summary", append(labels[:len(labels):len(labels)], fmt.Sprintf("quantile=\"%v\"", q)), "", percentiles[i]/unit)
+	}
+	addSample(families, name, "summary", labels, "_sum", sum/unit)
+	addSample(families, name, "summary", labels, "_count", float64(count))
+}
+
+// addHistogram writes the buckets of a timer in seconds, in increasing order, followed by their count and sum.
+func addHistogram(families map[string]*prometheusFamily, name string, labels []string, t *bucketedTimer) {
+	cumulative, sum := t.buckets()
+	series := strings.Join(labels, ",")
+	for i, count := range cumulative {
+		le := "+Inf"
+		if i < len(t.bounds) {
+			le = fmt.Sprintf("%v", t.bounds[i])
+		}
+		key := fmt.Sprintf("%s_bucket{%s} %03d", name, series, i)
+		addSortedSample(families, name, "histogram", append(labels[:len(labels):len(labels)], fmt.Sprintf("le=%q", le)), "_bucket", float64(count), key)
+	}
+	addSample(families, name, "histogram", labels, "_count", float64(cumulative[len(cumulative)-1]))
+	addSample(families, name, "histogram", labels, "_sum", sum)
+}
+
+// timed records how long next takes to serve requests in a histogram.
+func timed(name string, next http.HandlerFunc) http.HandlerFunc {
+	t := getOrRegisterBucketedTimer(name, latencyBuckets, metrics.DefaultRegistry)
+	return func(writer http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		next(writer, req)
+		t.UpdateSince(start)
+	}
+}
+
+// timedTransport records the latency of requests to TME, and counts those that fail.
+type timedTransport struct {
+	next http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	getOrRegisterBucketedTimer("tme.fetch.latency", latencyBuckets, metrics.DefaultRegistry).UpdateSince(start)
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		metrics.GetOrRegisterCounter("tme.fetch.errors", metrics.DefaultRegistry).Inc(1)
+	}
+	return resp, err
+}
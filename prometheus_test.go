@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		name   string
+		family string
+		labels []string
+	}{
+		{"routes.GL.location.latency", "routes_latency", []string{`taxonomy="GL"`, `route="location"`}},
+		{"routes.config.latency", "routes_latency", []string{`route="config"`}},
+		{"locations.ON.reload_duration", "locations_reload_duration", []string{`taxonomy="ON"`}},
+		{"ratelimit.default.limited", "ratelimit_limited", []string{`route="default"`}},
+		{"concurrency.dump.inflight", "concurrency_inflight", []string{`route="dump"`}},
+		{"auth.denied", "auth_denied", nil},
+		{"routes.GL.location", "routes_GL_location", nil},
+		{"200.http-requests", "_200_http_requests", nil},
+	}
+	for _, test := range tests {
+		family, labels := prometheusName(test.name)
+		assert.Equal(t, test.family, family, test.name)
+		assert.Equal(t, test.labels, labels, test.name)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("ratelimit.search.limited", r).Inc(3)
+	metrics.GetOrRegisterCounter("ratelimit.default.limited", r).Inc(1)
+	metrics.GetOrRegisterGauge("locations.GL.terms", r).Update(42)
+	metrics.GetOrRegisterTimer("snapshots.build", r).Update(2 * time.Second)
+	latency := getOrRegisterBucketedTimer("routes.GL.search.latency", []float64{0.1, 1, 10}, r)
+	latency.Update(30 * time.Millisecond)
+	latency.Update(2 * time.Second)
+
+	rec := httptest.NewRecorder()
+	prometheusHandler(r)(rec, newRequest("GET", "/metrics"))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, prometheusContentType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# TYPE locations_terms gauge
+locations_terms{taxonomy="GL"} 42
+# TYPE ratelimit_limited counter
+ratelimit_limited{route="default"} 1
+ratelimit_limited{route="search"} 3
+# TYPE routes_latency_seconds histogram
+routes_latency_seconds_bucket{taxonomy="GL",route="search",le="0.1"} 1
+routes_latency_seconds_bucket{taxonomy="GL",route="search",le="1"} 1
+routes_latency_seconds_bucket{taxonomy="GL",route="search",le="10"} 2
+routes_latency_seconds_bucket{taxonomy="GL",route="search",le="+Inf"} 2
+routes_latency_seconds_count{taxonomy="GL",route="search"} 2
+routes_latency_seconds_sum{taxonomy="GL",route="search"} 2.03
+# TYPE snapshots_build_seconds summary
+snapshots_build_seconds_count 1
+snapshots_build_seconds_sum 2
+snapshots_build_seconds{quantile="0.5"} 2
+snapshots_build_seconds{quantile="0.75"} 2
+snapshots_build_seconds{quantile="0.95"} 2
+snapshots_build_seconds{quantile="0.99"} 2
+`, rec.Body.String())
+}
+
+func TestTimedTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/broken" {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: timedTransport{next: http.DefaultTransport}}
+	latency := getOrRegisterBucketedTimer("tme.fetch.latency", latencyBuckets, metrics.DefaultRegistry)
+	failures := metrics.GetOrRegisterCounter("tme.fetch.errors", metrics.DefaultRegistry)
+	requests, errors := latency.Count(), failures.Count()
+
+	for _, path := range []string{"/terms", "/broken"} {
+		resp, err := client.Get(server.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, requests+2, latency.Count())
+	assert.Equal(t, errors+1, failures.Count())
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRoute names the rate limit policy of routes without one of their own. Those routes share its buckets.
+const defaultRoute = "default"
+
+// limitedRoutes are the names of the routes limits can be set for.
+var limitedRoutes = map[string]bool{
+	defaultRoute: true, "list": true, "count": true, "ids": true, "dump": true, "duplicates": true, "enrichment-issues": true,
+	"lookup": true, "deprecated": true, "near": true, "within": true, "search": true, "reload": true, "force-apply": true, "location": true,
+	"overrides": true, "config": true,
+}
+
+// rateLimitPolicy lets each client make rate requests a second on a route, in bursts of up to burst requests.
+type rateLimitPolicy struct {
+	route string
+	rate  float64
+	burst float64
+}
+
+// tokenBucket holds the requests a client may still make, refilled at the rate of its policy.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter protects routes from clients making too many requests, and from too many heavy requests at once.
+type limiter struct {
+	sync.Mutex
+	policies    map[string]rateLimitPolicy
+	buckets     map[string]map[string]*tokenBucket
+	concurrency map[string]chan struct{}
+	auth        *authenticator
+	proxies     []*net.IPNet
+	now         func() time.Time
+	swept       time.Time
+}
+
+// newLimiter parses rate limits of the form route:rate:burst and concurrency limits of the form route:max. A rate
+// limit for the default route applies to every route without one of its own. Trusted proxies are addresses or CIDR
+// networks.
+func newLimiter(rateLimits []string, concurrencyLimits []string, trustedProxies []string, auth *authenticator) (*limiter, error) {
+	l := &limiter{
+		policies:    make(map[string]rateLimitPolicy),
+		buckets:     make(map[string]map[string]*tokenBucket),
+		concurrency: make(map[string]chan struct{}),
+		auth:        auth,
+		now:         time.Now,
+	}
+	for _, entry := range rateLimits {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid rate limit %q, expected route:rate:burst", entry)
+		}
+		if !limitedRoutes[parts[0]] {
+			return nil, fmt.Errorf("Unknown route %s in rate limit %q", parts[0], entry)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("Invalid rate %q for route %s, expected a positive number of requests a second", parts[1], parts[0])
+		}
+		burst, err := strconv.Atoi(parts[2])
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("Invalid burst %q for route %s, expected a positive number of requests", parts[2], parts[0])
+		}
+		if _, found := l.policies[parts[0]]; found {
+			return nil, fmt.Errorf("Route %s has two rate limits", parts[0])
+		}
+		l.policies[parts[0]] = rateLimitPolicy{route: parts[0], rate: rate, burst: float64(burst)}
+		l.buckets[parts[0]] = make(map[string]*tokenBucket)
+	}
+	for _, entry := range concurrencyLimits {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid concurrency limit %q, expected route:max", entry)
+		}
+		if !limitedRoutes[parts[0]] || parts[0] == defaultRoute {
+			return nil, fmt.Errorf("Unknown route %s in concurrency limit %q", parts[0], entry)
+		}
+		max, err := strconv.Atoi(parts[1])
+		if err != nil || max < 1 {
+			return nil, fmt.Errorf("Invalid concurrency limit %q for route %s, expected a positive number of requests", parts[1], parts[0])
+		}
+		if _, found := l.concurrency[parts[0]]; found {
+			return nil, fmt.Errorf("Route %s has two concurrency limits", parts[0])
+		}
+		l.concurrency[parts[0]] = make(chan struct{}, max)
+	}
+	for _, entry := range trustedProxies {
+		cidr := entry
+		if !strings.Contains(entry, "/") {
+			cidr += "/128"
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				cidr = entry + "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %q, expected an address or a CIDR network", entry)
+		}
+		l.proxies = append(l.proxies, network)
+	}
+	return l, nil
+}
+
+// limit lets requests through to next while the client is within the rate limit of the route, and the route within
+// its concurrency limit. Other requests are answered with a too-many-requests problem and a Retry-After header.
+func (l *limiter) limit(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if wait, allowed := l.take(route, l.client(req)); !allowed {
+			seconds := int(math.Ceil(wait.Seconds()))
+			writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeProblem(writer, req, tooManyRequestsProblem, fmt.Sprintf("Too many requests to %s, retry after %d seconds", route, seconds))
+			return
+		}
+		slots, capped := l.concurrency[route]
+		if !capped {
+			next(writer, req)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+			metrics.GetOrRegisterGauge("concurrency."+route+".inflight", metrics.DefaultRegistry).Update(int64(len(slots)))
+			defer func() {
+				<-slots
+				metrics.GetOrRegisterGauge("concurrency."+route+".inflight", metrics.DefaultRegistry).Update(int64(len(slots)))
+			}()
+			next(writer, req)
+		default:
+			metrics.GetOrRegisterCounter("concurrency."+route+".rejected", metrics.DefaultRegistry).Inc(1)
+			writer.Header().Set("Retry-After", "1")
+			writeProblem(writer, req, tooManyRequestsProblem, fmt.Sprintf("Too many %s requests are being served, retry shortly", route))
+		}
+	}
+}
+
+// client identifies who is making a request: the name of its API key, or else its address. Behind trusted proxies,
+// the address is the right-most one of X-Forwarded-For that is not a trusted proxy, as the ones left of it are set by
+// the client and could be anything.
+func (l *limiter) client(req *http.Request) string {
+	if c, found, _ := l.auth.identify(req); found {
+		return "key:" + c.name
+	}
+	addr := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		addr = host
+	}
+	if !l.trusted(addr) {
+		return addr
+	}
+	hops := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if net.ParseIP(hop) == nil {
+			return addr
+		}
+		if !l.trusted(hop) {
+			return hop
+		}
+		addr = hop
+	}
+	return addr
+}
+
+// trusted tells whether an address is one of a trusted proxy.
+func (l *limiter) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// take spends a token from the client's bucket for the policy of a route. When it is empty, it tells how long until
+// the next token.
+func (l *limiter) take(route string, client string) (time.Duration, bool) {
+	policy, found := l.policies[route]
+	if !found {
+		if policy, found = l.policies[defaultRoute]; !found {
+			return 0, true
+		}
+	}
+	l.Lock()
+	defer l.Unlock()
+	now := l.now()
+	l.sweep(now)
+	buckets := l.buckets[policy.route]
+	b, found := buckets[client]
+	if !found {
+		b = &tokenBucket{tokens: policy.burst, last: now}
+		buckets[client] = b
+		metrics.GetOrRegisterGauge("ratelimit."+policy.route+".clients", metrics.DefaultRegistry).Update(int64(len(buckets)))
+	}
+	b.tokens = math.Min(policy.burst, b.tokens+now.Sub(b.last).Seconds()*policy.rate)
+	b.last = now
+	if b.tokens < 1 {
+		metrics.GetOrRegisterCounter("ratelimit."+policy.route+".limited", metrics.DefaultRegistry).Inc(1)
+		return time.Duration((1 - b.tokens) / policy.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	metrics.GetOrRegisterCounter("ratelimit."+policy.route+".allowed", metrics.DefaultRegistry).Inc(1)
+	return 0, true
+}
+
+// sweep forgets, once a minute, the buckets that have refilled, as their clients are back where they started.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for route, buckets := range l.buckets {
+		policy := l.policies[route]
+		for client, b := range buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*policy.rate >= policy.burst {
+				delete(buckets, client)
+			}
+		}
+		metrics.GetOrRegisterGauge("ratelimit."+route+".clients", metrics.DefaultRegistry).Update(int64(len(buckets)))
+	}
+}
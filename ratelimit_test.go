@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewLimiter(t *testing.T) {
+	tests := []struct {
+		name              string
+		rateLimits        []string
+		concurrencyLimits []string
+		policies          map[string]rateLimitPolicy
+		valid             bool
+	}{
+		{"None", []string{}, []string{}, map[string]rateLimitPolicy{}, true},
+		{"Limits", []string{"default:50:100", "location:0.5:2"}, []string{"dump:4"}, map[string]rateLimitPolicy{"default": {route: "default", rate: 50, burst: 100}, "location": {route: "location", rate: 0.5, burst: 2}}, true},
+		{"Unknown route", []string{"everything:1:1"}, []string{}, nil, false},
+		{"Zero rate", []string{"search:0:1"}, []string{}, nil, false},
+		{"No burst", []string{"search:1"}, []string{}, nil, false},
+		{"Fractional burst", []string{"search:1:1.5"}, []string{}, nil, false},
+		{"Two rate limits", []string{"search:1:1", "search:2:2"}, []string{}, nil, false},
+		{"Zero concurrency", []string{}, []string{"dump:0"}, nil, false},
+		{"Default concurrency", []string{}, []string{"default:4"}, nil, false},
+		{"Two concurrency limits", []string{}, []string{"dump:4", "dump:8"}, nil, false},
+	}
+	for _, test := range tests {
+		l, err := newLimiter(test.rateLimits, test.concurrencyLimits, []string{}, testAuth)
+		assert.Equal(t, test.valid, err == nil, fmt.Sprintf("%s: Unexpected error %v", test.name, err))
+		if err == nil {
+			assert.Equal(t, test.policies, l.policies, test.name)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	type call struct {
+		route      string
+		key        string
+		address    string
+		after      time.Duration
+		status     int
+		retryAfter string
+	}
+	tests := []struct {
+		name  string
+		calls []call
+	}{
+		{"Burst then limited", []call{
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.1:1235", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.1:1236", 0, http.StatusTooManyRequests, "2"},
+		}},
+		{"Refilled at the rate", []call{
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.1:1234", time.Second, http.StatusTooManyRequests, "1"},
+			{"location", "", "10.0.0.1:1234", time.Second, http.StatusOK, ""},
+		}},
+		{"Clients limited apart", []call{
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", "", "10.0.0.2:1234", 0, http.StatusOK, ""},
+			{"location", testReadKey, "10.0.0.1:1234", 0, http.StatusOK, ""},
+		}},
+		{"Keys limited wherever they come from", []call{
+			{"location", testReadKey, "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"location", testReadKey, "10.0.0.2:1234", 0, http.StatusOK, ""},
+			{"location", testReadKey, "10.0.0.3:1234", 0, http.StatusTooManyRequests, "2"},
+		}},
+		{"Default policy shared by routes", []call{
+			{"count", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+			{"ids", "", "10.0.0.1:1234", 0, http.StatusTooManyRequests, "1"},
+			{"location", "", "10.0.0.1:1234", 0, http.StatusOK, ""},
+		}},
+	}
+	for _, test := range tests {
+		l, err := newLimiter([]string{"default:2:1", "location:0.5:2"}, []string{}, []string{}, testAuth)
+		assert.NoError(t, err)
+		now := time.Date(2016, 11, 1, 12, 0, 0, 0, time.UTC)
+		l.now = func() time.Time { return now }
+		for i, c := range test.calls {
+			now = now.Add(c.after)
+			handler := l.limit(c.route, func(writer http.ResponseWriter, req *http.Request) {})
+			req := newRequest("GET", "/transformers/locations/"+testUUID)
+			req.RemoteAddr = c.address
+			if c.key != "" {
+				req.Header.Set("X-Api-Key", c.key)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			assert.Equal(t, c.status, rec.Code, fmt.Sprintf("%s: call %d", test.name, i))
+			assert.Equal(t, c.retryAfter, rec.Header().Get("Retry-After"), fmt.Sprintf("%s: call %d", test.name, i))
+			if c.status == http.StatusTooManyRequests {
+				assert.Equal(t, problemContentType, rec.Header().Get("Content-Type"), test.name)
+			}
+		}
+	}
+}
+
+func TestRateLimitForwardedClients(t *testing.T) {
+	l, err := newLimiter([]string{"location:1:1"}, []string{}, []string{"10.0.0.0/8", "192.0.2.1"}, testAuth)
+	assert.NoError(t, err)
+	tests := []struct {
+		name      string
+		address   string
+		forwarded []string
+		client    string
+	}{
+		{"Direct", "198.51.100.4:1234", nil, "198.51.100.4"},
+		{"Forwarded by a client", "198.51.100.4:1234", []string{"192.0.2.7"}, "198.51.100.4"},
+		{"Behind a trusted proxy", "10.0.0.1:1234", []string{"192.0.2.7"}, "192.0.2.7"},
+		{"Spoofed by the client", "10.0.0.1:1234", []string{"203.0.113.9, 192.0.2.7"}, "192.0.2.7"},
+		{"Behind a chain of proxies", "10.0.0.1:1234", []string{"192.0.2.7, 192.0.2.1", "10.0.0.9"}, "192.0.2.7"},
+		{"Trusted proxy without the header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Only proxies", "10.0.0.1:1234", []string{"10.0.0.9"}, "10.0.0.9"},
+		{"Garbage", "10.0.0.1:1234", []string{"192.0.2.7, unknown"}, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		req := newRequest("GET", "/transformers/locations/"+testUUID)
+		req.RemoteAddr = test.address
+		for _, forwarded := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", forwarded)
+		}
+		assert.Equal(t, test.client, l.client(req), test.name)
+	}
+}
+
+func TestTrustedProxiesErrors(t *testing.T) {
+	for _, proxy := range []string{"proxy.ft.com", "10.0.0.0/33", ""} {
+		_, err := newLimiter([]string{}, []string{}, []string{proxy}, testAuth)
+		assert.EqualError(t, err, fmt.Sprintf("Invalid trusted proxy %q, expected an address or a CIDR network", proxy))
+	}
+}
+
+func TestWrongKeysAreRateLimited(t *testing.T) {
+	limits, err := newLimiter([]string{"location:1:2"}, []string{}, []string{}, testAuth)
+	assert.NoError(t, err)
+	m := mux.NewRouter()
+	h := newLocationsHandler(&dummyService{found: true, locations: []location{paris}, dataLoaded: DataLoaded}, testTaxonomy, testAuth, limits, healthPolicy{})
+	h.registerRoutes(m)
+
+	for i, status := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := newRequest("GET", "/transformers/locations/"+testUUID)
+		req.RemoteAddr = "198.51.100.4:1234"
+		req.Header.Set("X-Api-Key", fmt.Sprintf("guess-%d", i))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, "Guess %d", i)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	l, err := newLimiter([]string{}, []string{"dump:1"}, []string{}, testAuth)
+	assert.NoError(t, err)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := l.limit("dump", func(writer http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(first, newRequest("GET", "/transformers/locations/__dump"))
+		close(done)
+	}()
+	<-started
+
+	rejected := httptest.NewRecorder()
+	handler(rejected, newRequest("GET", "/transformers/locations/__dump"))
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusOK, first.Code)
+
+	go func() { <-started }()
+	again := httptest.NewRecorder()
+	handler(again, newRequest("GET", "/transformers/locations/__dump"))
+	assert.Equal(t, http.StatusOK, again.Code, "The slot should be free once the first request is served")
+}
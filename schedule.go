@@ -0,0 +1,86 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+)
+
+// scheduler reloads every taxonomy from TME at an interval, which may be changed while it runs. With a zero interval
+// reloads are only made on POST __reload.
+type scheduler struct {
+	names    []string
+	services []locationService
+	// unavailable tells whether TME is known to be down, to skip reloads meant to fail.
+	unavailable func() bool
+	updates     chan time.Duration
+	stopping    chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{updates: make(chan time.Duration), stopping: make(chan struct{})}
+}
+
+func (s *scheduler) add(taxonomy string, service locationService) {
+	s.names = append(s.names, taxonomy)
+	s.services = append(s.services, service)
+}
+
+// run reloads at the interval until stopped. The interval is counted from the end of the previous reloads, or from
+// when it was last changed.
+func (s *scheduler) run(interval time.Duration) {
+	for {
+		var tick <-chan time.Time
+		var timer *time.Timer
+		if interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		}
+		select {
+		case <-tick:
+			s.reloadAll()
+		case interval = <-s.updates:
+			log.Infof("Reloading from TME every %v", interval)
+		case <-s.stopping:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		select {
+		case <-s.stopping:
+			return
+		default:
+		}
+	}
+}
+
+func (s *scheduler) reloadAll() {
+	if s.unavailable != nil && s.unavailable() {
+		log.Warn("Skipping the scheduled reloads as the circuit to TME is open, keeping the locations served")
+		return
+	}
+	for i, service := range s.services {
+		switch service.getLoadStatus() {
+		case NotInit:
+			log.Warnf("Skipping the scheduled reload of taxonomy %s as its service could not be created", s.names[i])
+			continue
+		case LoadingData:
+			log.Infof("Skipping the scheduled reload of taxonomy %s as a reload is in progress", s.names[i])
+			continue
+		}
+		if err := service.reload(); err != nil && err != errShuttingDown && err != errReloadCancelled {
+			log.Errorf("Scheduled reload of taxonomy %s failed: %v", s.names[i], err)
+		}
+	}
+}
+
+// setInterval changes the interval. It waits for scheduled reloads in progress to finish.
+func (s *scheduler) setInterval(interval time.Duration) {
+	select {
+	case s.updates <- interval:
+	case <-s.stopping:
+	}
+}
+
+func (s *scheduler) stop() {
+	close(s.stopping)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingService struct {
+	dummyService
+	reloads int32
+}
+
+func (s *countingService) reload() error {
+	atomic.AddInt32(&s.reloads, 1)
+	return nil
+}
+
+func TestScheduledReloads(t *testing.T) {
+	gl := &countingService{}
+	loading := &countingService{dummyService: dummyService{dataLoaded: LoadingData}}
+	sched := newScheduler()
+	sched.add("GL", gl)
+	sched.add("ON", loading)
+	go sched.run(0)
+	defer sched.stop()
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&gl.reloads), "A zero interval should not schedule reloads")
+
+	sched.setInterval(10 * time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	reloads := atomic.LoadInt32(&gl.reloads)
+	assert.True(t, reloads >= 2, "Expected several reloads, got %d", reloads)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loading.reloads), "A taxonomy already reloading should be skipped")
+
+	sched.setInterval(time.Hour)
+	reloads = atomic.LoadInt32(&gl.reloads)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, reloads, atomic.LoadInt32(&gl.reloads))
+}
+
+func TestScheduledReloadAfterFailedStartup(t *testing.T) {
+	repo := dummyRepo{err: errors.New("TME is down")}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.Error(t, err)
+	uninitialised := &countingService{dummyService: dummyService{dataLoaded: NotInit}}
+	sched := newScheduler()
+	sched.add("GL", service)
+	sched.add("ON", uninitialised)
+
+	sched.reloadAll()
+	assert.Equal(t, ErrorLoadingData, service.getLoadStatus(), "A failed reload should be logged, not crash the scheduler")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&uninitialised.reloads), "A service that could not be created should be skipped")
+
+	repo.err = nil
+	repo.terms = []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}
+	sched.reloadAll()
+	assert.Equal(t, DataLoaded, service.getLoadStatus(), "A scheduled reload should bring back a taxonomy whose first load failed")
+	assert.Equal(t, 1, service.getLocationCount())
+}
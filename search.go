@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	exactMatch = iota
+	prefixMatch
+	wordPrefixMatch
+	substringMatch
+	noMatch
+)
+
+type searchEntry struct {
+	key  string
+	uuid string
+}
+
+// searchIndex holds every label of every location, in all languages, folded for case-insensitive matching.
+type searchIndex struct {
+	entries []searchEntry
+	order   map[string]int
+}
+
+// searchHit is a location matching a query, with how well its best label matched.
+type searchHit struct {
+	uuid  string
+	rank  int
+	label string
+}
+
+func newSearchIndex(locations locationsMap, order []string) *searchIndex {
+	idx := &searchIndex{order: make(map[string]int, len(order))}
+	for i, uuid := range order {
+		idx.order[uuid] = i
+		l := locations[uuid]
+		add := func(label string) {
+			if key := foldLabel(label); key != "" {
+				idx.entries = append(idx.entries, searchEntry{key: key, uuid: uuid})
+			}
+		}
+		add(l.PrefLabel)
+		for _, label := range l.PrefLabels {
+			add(label)
+		}
+		for _, labels := range l.AltLabels {
+			for _, label := range labels {
+				add(label)
+			}
+		}
+	}
+	return idx
+}
+
+// foldLabel lower-cases a label and collapses its whitespace. Accents are kept, so Zürich does not match Zurich.
+func foldLabel(label string) string {
+	return strings.Join(strings.Fields(strings.ToLower(label)), " ")
+}
+
+func matchRank(key string, query string) int {
+	switch {
+	case key == query:
+		return exactMatch
+	case strings.HasPrefix(key, query):
+		return prefixMatch
+	case strings.Contains(key, " "+query) || strings.Contains(key, "-"+query):
+		return wordPrefixMatch
+	case strings.Contains(key, query):
+		return substringMatch
+	}
+	return noMatch
+}
+
+// search returns the UUIDs of the locations with a label matching the query, exact matches first, then prefixes, then
+// word prefixes, then any substring. Within a rank shorter labels come first, then the order the locations were loaded in.
+func (idx *searchIndex) search(query string) []string {
+	query = foldLabel(query)
+	if query == "" {
+		return []string{}
+	}
+	best := make(map[string]*searchHit)
+	for _, e := range idx.entries {
+		rank := matchRank(e.key, query)
+		if rank == noMatch {
+			continue
+		}
+		h, found := best[e.uuid]
+		if !found || rank < h.rank || (rank == h.rank && len(e.key) < len(h.label)) {
+			best[e.uuid] = &searchHit{uuid: e.uuid, rank: rank, label: e.key}
+		}
+	}
+	hits := make([]*searchHit, 0, len(best))
+	for _, h := range best {
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].rank != hits[j].rank {
+			return hits[i].rank < hits[j].rank
+		}
+		if len(hits[i].label) != len(hits[j].label) {
+			return len(hits[i].label) < len(hits[j].label)
+		}
+		return idx.order[hits[i].uuid] < idx.order[hits[j].uuid]
+	})
+	uuids := make([]string, len(hits))
+	for i, h := range hits {
+		uuids[i] = h.uuid
+	}
+	return uuids
+}
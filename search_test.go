@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	locations := locationsMap{
+		"new-york":    {UUID: "new-york", PrefLabel: "New York"},
+		"york":        {UUID: "york", PrefLabel: "York"},
+		"yorkshire":   {UUID: "yorkshire", PrefLabel: "Yorkshire"},
+		"new-jersey":  {UUID: "new-jersey", PrefLabel: "New Jersey"},
+		"munich":      {UUID: "munich", PrefLabel: "Munich", PrefLabels: map[string]string{"de": "München"}, AltLabels: map[string][]string{"bar": {"Minga"}}},
+		"new-orleans": {UUID: "new-orleans", PrefLabel: "New  Orleans"},
+	}
+	idx := newSearchIndex(locations, []string{"new-york", "york", "yorkshire", "new-jersey", "munich", "new-orleans"})
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"york", []string{"york", "yorkshire", "new-york"}},
+		{"NEW", []string{"new-york", "new-jersey", "new-orleans"}},
+		{"new orleans", []string{"new-orleans"}},
+		{"münchen", []string{"munich"}},
+		{"minga", []string{"munich"}},
+		{"ork", []string{"york", "new-york", "yorkshire"}},
+		{"paris", []string{}},
+		{"  ", []string{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, idx.search(test.query), test.query)
+	}
+}
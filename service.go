@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/Financial-Times/tme-reader/tmereader"
 	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type httpClient interface {
@@ -13,12 +20,41 @@ type httpClient interface {
 }
 
 type locationService interface {
-	getLocations() ([]locationLink, bool)
+	getLocations(locationType string) ([]locationLink, bool)
 	getLocationByUUID(uuid string) (location, bool)
+	getLocationByIdentifier(authority string, value string) (location, bool)
+	getLocationsNear(c coordinates, radiusKm float64, limit int) []nearbyLocation
+	getLocationsWithin(b boundingBox, limit int) []location
+	searchLocations(query string, locationType string, limit int) []location
+	getDeprecation(uuid string) (deprecatedLocation, bool)
+	getDeprecations() []deprecatedLocation
+	getLocationVersion(uuid string) (contentVersion, bool)
+	getSnapshotVersion() contentVersion
+	getPayload(name string) (*payload, bool)
 	getLocationCount() int
-	getLocationIds() []string
+	getLocationIds(locationType string) []string
 	reload() error
+	reloadContext(ctx context.Context) error
+	refresh() error
+	forceApply() error
 	getLoadStatus() loadStatus
+	getRejection() string
+	getDuplicates() []duplicateLocation
+	getEnrichmentIssues() []enrichmentIssue
+	getLoadStats() loadStats
+	setGuard(guard snapshotGuard)
+	stop()
+}
+
+// loadStats describe the latest loads from TME, for health checks.
+type loadStats struct {
+	// loadedAt is when the locations served were fetched from TME.
+	loadedAt      time.Time
+	terms         int
+	count         int
+	previousCount int
+	// invalid counts the duplicates and supplementary data that could not be applied in the latest load.
+	invalid int
 }
 
 type loadStatus string
@@ -28,17 +64,46 @@ const (
 	LoadingData      = loadStatus("Loading")
 	DataLoaded       = loadStatus("DataLoaded")
 	ErrorLoadingData = loadStatus("ErrorLoadingData")
+	RejectedData     = loadStatus("RejectedData")
+)
+
+var errNoRejectedSnapshot = errors.New("No rejected snapshot to apply")
+
+var (
+	errReloadCancelled = errors.New("Reload cancelled as the service is shutting down")
+	errShuttingDown    = errors.New("Not reloading as the service is shutting down")
 )
 
 type locationServiceImpl struct {
 	sync.Mutex
 	repository    tmereader.Repository
-	baseURL       string
+	taxonomy      taxonomyConfig
 	locationsMap  atomic.Value
 	locationLinks atomic.Value
-	taxonomyName  string
+	byType        atomic.Value
+	byIdentifier  atomic.Value
+	spatial       atomic.Value
+	search        atomic.Value
+	deprecated    atomic.Value
+	versions      atomic.Value
+	version       atomic.Value
+	payloads      atomic.Value
 	maxTmeRecords int
 	status        atomic.Value
+	guard         snapshotGuard
+	rejected      *snapshot
+	rejection     atomic.Value
+	duplicates    atomic.Value
+	issues        atomic.Value
+	uuids         uuidStrategy
+	classifier    *classifier
+	enrichers     []enricher
+	deprecations  *deprecations
+	terms         []term
+	fetchedAt     time.Time
+	stats         atomic.Value
+	stopping      chan struct{}
+	stopOnce      sync.Once
 }
 
 type locationsMap map[string]location
@@ -52,16 +117,60 @@ func (s *locationServiceImpl) getLoadStatus() loadStatus {
 	return i.(loadStatus)
 }
 
-func newLocationService(repo tmereader.Repository, baseURL string, taxonomyName string, maxTmeRecords int) (locationService, error) {
-	s := &locationServiceImpl{repository: repo, baseURL: baseURL, taxonomyName: taxonomyName, maxTmeRecords: maxTmeRecords}
-	err := s.reload()
+func (s *locationServiceImpl) getRejection() string {
+	i := s.rejection.Load()
+	if i == nil {
+		return ""
+	}
+	return i.(string)
+}
+
+// newLocationService creates the service of a taxonomy and makes its first load from TME. The service is kept when the
+// first load fails, so a later reload can bring it back, and a load rejected by the guard can be force applied.
+func newLocationService(repo tmereader.Repository, taxonomy taxonomyConfig, maxTmeRecords int, guard snapshotGuard, uuids uuidStrategy, classifier *classifier, enrichers []enricher, deprecations *deprecations) (locationService, error) {
+	s, err := createLocationService(repo, taxonomy, maxTmeRecords, guard, uuids, classifier, enrichers, deprecations)
+	if err != nil {
+		return s, err
+	}
+	return s, s.reload()
+}
+
+// createLocationService creates the service of a taxonomy without loading it, so the first load can be made later.
+func createLocationService(repo tmereader.Repository, taxonomy taxonomyConfig, maxTmeRecords int, guard snapshotGuard, uuids uuidStrategy, classifier *classifier, enrichers []enricher, deprecations *deprecations) (locationService, error) {
+	s := &locationServiceImpl{repository: repo, taxonomy: taxonomy, maxTmeRecords: maxTmeRecords, guard: guard, uuids: uuids, classifier: classifier, enrichers: enrichers, deprecations: deprecations, stopping: make(chan struct{})}
+	deprecated, err := deprecations.load()
 	if err != nil {
 		return &locationServiceImpl{}, err
 	}
+	s.deprecated.Store(deprecated)
+	metrics.GetOrRegister("locations."+taxonomy.name+".snapshot_age_seconds", metrics.NewFunctionalGauge(s.snapshotAge))
 	return s, nil
 }
 
-func (s *locationServiceImpl) getLocations() ([]locationLink, bool) {
+func (s *locationServiceImpl) getLoadStats() loadStats {
+	st, _ := s.stats.Load().(loadStats)
+	return st
+}
+
+// snapshotAge is the number of seconds since the locations served were fetched from TME, or 0 before the first load.
+func (s *locationServiceImpl) snapshotAge() int64 {
+	loadedAt := s.getLoadStats().loadedAt
+	if loadedAt.IsZero() {
+		return 0
+	}
+	return int64(time.Since(loadedAt) / time.Second)
+}
+
+func (s *locationServiceImpl) getLocations(locationType string) ([]locationLink, bool) {
+	if locationType != "" {
+		uuids := s.getLocationIds(locationType)
+		links := make([]locationLink, len(uuids))
+		for i, uuid := range uuids {
+			links[i] = locationLink{APIURL: s.taxonomy.baseURL + uuid}
+		}
+		return links, len(links) > 0
+	}
+
 	val := s.locationLinks.Load()
 	if val == nil {
 		return nil, false
@@ -83,16 +192,158 @@ func (s *locationServiceImpl) getLocationByUUID(uuid string) (location, bool) {
 	return location{}, false
 }
 
-func (s *locationServiceImpl) initLocationsMap(terms []interface{}) (map[string]location, []locationLink) {
-	lMap := make(map[string]location)
-	ll := make([]locationLink, len(terms))
-	for i, iTerm := range terms {
-		t := iTerm.(term)
-		top := transformLocation(t, s.taxonomyName)
-		lMap[top.UUID] = top
-		ll[i] = locationLink{APIURL: s.baseURL + top.UUID}
+func (s *locationServiceImpl) getLocationByIdentifier(authority string, value string) (location, bool) {
+	val := s.byIdentifier.Load()
+	if val == nil {
+		return location{}, false
+	}
+	uuid, found := val.(map[string]string)[identifierKey(authority, value)]
+	if !found {
+		return location{}, false
+	}
+	return s.getLocationByUUID(uuid)
+}
+
+func (s *locationServiceImpl) getLocationsNear(c coordinates, radiusKm float64, limit int) []nearbyLocation {
+	val := s.spatial.Load()
+	if val == nil {
+		return []nearbyLocation{}
+	}
+	hits := val.(*spatialIndex).near(c, radiusKm)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	nearby := make([]nearbyLocation, 0, len(hits))
+	for _, h := range hits {
+		if l, found := s.getLocationByUUID(h.uuid); found {
+			nearby = append(nearby, nearbyLocation{location: l, DistanceKm: roundKm(h.distanceKm)})
+		}
+	}
+	return nearby
+}
+
+func (s *locationServiceImpl) getLocationsWithin(b boundingBox, limit int) []location {
+	val := s.spatial.Load()
+	if val == nil {
+		return []location{}
+	}
+	uuids := val.(*spatialIndex).within(b)
+	if len(uuids) > limit {
+		uuids = uuids[:limit]
+	}
+	locations := make([]location, 0, len(uuids))
+	for _, uuid := range uuids {
+		if l, found := s.getLocationByUUID(uuid); found {
+			locations = append(locations, l)
+		}
+	}
+	return locations
+}
+
+func (s *locationServiceImpl) searchLocations(query string, locationType string, limit int) []location {
+	val := s.search.Load()
+	if val == nil {
+		return []location{}
+	}
+	locations := make([]location, 0, limit)
+	for _, uuid := range val.(*searchIndex).search(query) {
+		if len(locations) == limit {
+			break
+		}
+		if l, found := s.getLocationByUUID(uuid); found && (locationType == "" || contains(l.Types, locationType)) {
+			locations = append(locations, l)
+		}
+	}
+	return locations
+}
+
+// initLocationsMap adds the locations transformed from TME terms to the builder, returning those TME marks as deprecated instead.
+func (s *locationServiceImpl) initLocationsMap(terms []term, b *snapshotBuilder) []deprecatedLocation {
+	depths := termDepths(terms)
+	var deprecated []deprecatedLocation
+	for _, t := range terms {
+		l := transformLocation(t, s.taxonomy, s.uuids)
+		if isDeprecated, replacedBy := termDeprecation(t); isDeprecated {
+			dl := deprecatedLocation{UUID: l.UUID, PrefLabel: l.PrefLabel, TMEIdentifier: tmeIdentifier(l), Status: retiredStatus, Reason: "Deprecated in TME"}
+			if replacedBy != "" {
+				dl.Status, dl.MergedInto = mergedStatus, s.uuids.uuidFor(buildTmeIdentifier(replacedBy, s.taxonomy.name))
+			}
+			deprecated = append(deprecated, dl)
+			continue
+		}
+		b.add(s.classifier.classify(l, t, depths[t.RawID]))
+	}
+	return deprecated
+}
+
+func (s *locationServiceImpl) getLocationVersion(uuid string) (contentVersion, bool) {
+	val := s.versions.Load()
+	if val == nil {
+		return contentVersion{}, false
+	}
+	v, found := val.(map[string]contentVersion)[uuid]
+	return v, found
+}
+
+func (s *locationServiceImpl) getSnapshotVersion() contentVersion {
+	val := s.version.Load()
+	if val == nil {
+		return contentVersion{}
+	}
+	return val.(contentVersion)
+}
+
+func (s *locationServiceImpl) getPayload(name string) (*payload, bool) {
+	val := s.payloads.Load()
+	if val == nil {
+		return nil, false
+	}
+	p, found := val.(map[string]*payload)[name]
+	return p, found
+}
+
+func (s *locationServiceImpl) getDeprecation(uuid string) (deprecatedLocation, bool) {
+	dl, found := s.deprecatedMap()[uuid]
+	return dl, found
+}
+
+func (s *locationServiceImpl) getDeprecations() []deprecatedLocation {
+	return sortedDeprecations(s.deprecatedMap())
+}
+
+func (s *locationServiceImpl) deprecatedMap() map[string]deprecatedLocation {
+	val := s.deprecated.Load()
+	if val == nil {
+		return map[string]deprecatedLocation{}
+	}
+	return val.(map[string]deprecatedLocation)
+}
+
+func (s *locationServiceImpl) enrich(locations locationsMap) []enrichmentIssue {
+	issues := []enrichmentIssue{}
+	for _, e := range s.enrichers {
+		issues = append(issues, e.enrich(s.taxonomy, locations)...)
+	}
+	for _, i := range issues {
+		log.Warnf("Could not apply %s data for %s: %s", i.Source, i.Key, i.Issue)
+	}
+	return issues
+}
+
+func (s *locationServiceImpl) getEnrichmentIssues() []enrichmentIssue {
+	val := s.issues.Load()
+	if val == nil {
+		return []enrichmentIssue{}
+	}
+	return val.([]enrichmentIssue)
+}
+
+func (s *locationServiceImpl) getDuplicates() []duplicateLocation {
+	val := s.duplicates.Load()
+	if val == nil {
+		return []duplicateLocation{}
 	}
-	return lMap, ll
+	return val.([]duplicateLocation)
 }
 
 func (s *locationServiceImpl) getLocationCount() int {
@@ -103,7 +354,15 @@ func (s *locationServiceImpl) getLocationCount() int {
 	return len(val.(locationLinks))
 }
 
-func (s *locationServiceImpl) getLocationIds() []string {
+func (s *locationServiceImpl) getLocationIds(locationType string) []string {
+	if locationType != "" {
+		val := s.byType.Load()
+		if val == nil {
+			return []string{}
+		}
+		return append([]string{}, val.(map[string][]string)[locationType]...)
+	}
+
 	i := 0
 	val := s.locationsMap.Load()
 
@@ -118,22 +377,48 @@ func (s *locationServiceImpl) getLocationIds() []string {
 		keys[i] = k
 		i++
 	}
+	sort.Strings(keys)
 	return keys
 }
 
 func (s *locationServiceImpl) reload() error {
+	return s.reloadContext(context.Background())
+}
+
+// reloadContext reloads the locations from TME as part of the transaction of the context, or of a new one when it has
+// none, as for scheduled reloads. Cancelling the context cancels the reload, as stopping the service does.
+func (s *locationServiceImpl) reloadContext(ctx context.Context) (err error) {
 	s.Lock() // lock as updating the stores
 	defer s.Unlock()
-	s.locationsMap.Store(make(locationsMap))
-	s.locationLinks.Store(make(locationLinks, 0))
+	if s.stopped() {
+		return errShuttingDown
+	}
+	previous := s.getLoadStatus()
 	s.status.Store(LoadingData)
+	defer getOrRegisterBucketedTimer("locations."+s.taxonomy.name+".reload_duration", reloadBuckets, metrics.DefaultRegistry).UpdateSince(time.Now())
+	tid, _ := ctx.Value(transactionIDKey{}).(string)
+	if tid == "" {
+		tid = newTransactionID()
+		ctx = withTransactionID(ctx, tid)
+	}
+	ctx, span := tracer().Start(ctx, "reload", trace.WithAttributes(attribute.String("taxonomy", s.taxonomy.name), attribute.String("ft.transaction_id", tid)))
+	defer func() { endSpan(span, err) }()
 	responseCount := 0
-	log.Println("Fetching locations from TME")
+	log.Printf("Fetching locations from TME taxonomy %s, transaction_id=%s", s.taxonomy.name, tid)
 
-	tempLocationsMap := make(locationsMap)
-	tempLocationLinks := make(locationLinks, 0)
+	var collected []term
 	for {
-		terms, err := s.repository.GetTmeTermsFromIndex(responseCount)
+		if s.stopped() || ctx.Err() != nil {
+			log.Warnf("Cancelling the reload of taxonomy %s after %d terms as the service is shutting down", s.taxonomy.name, len(collected))
+			s.status.Store(previous)
+			return errReloadCancelled
+		}
+		terms, err := s.fetchTerms(ctx, responseCount)
+		if err != nil && ctx.Err() != nil {
+			log.Warnf("Cancelling the reload of taxonomy %s after %d terms as the service is shutting down", s.taxonomy.name, len(collected))
+			s.status.Store(previous)
+			return errReloadCancelled
+		}
 		if err != nil {
 			log.Warnf("Got an error loading data from tme '%v'", err)
 			s.status.Store(ErrorLoadingData)
@@ -147,18 +432,143 @@ func (s *locationServiceImpl) reload() error {
 		}
 		log.Infof("Processing '%v' terms", tc)
 
-		lMap, ll := s.initLocationsMap(terms)
+		for _, t := range terms {
+			collected = append(collected, t.(term))
+		}
+		responseCount += s.maxTmeRecords
+	}
+	s.terms = collected
+	s.fetchedAt = time.Now()
+	metrics.GetOrRegisterGauge("locations."+s.taxonomy.name+".terms", metrics.DefaultRegistry).Update(int64(len(collected)))
+	span.SetAttributes(attribute.Int("tme.terms", len(collected)))
+	_, rebuildSpan := tracer().Start(ctx, "rebuild locations")
+	err = s.rebuild()
+	endSpan(rebuildSpan, err)
+	return err
+}
+
+// fetchTerms fetches a page of terms from TME, as part of the trace of a reload when the repository is traced.
+func (s *locationServiceImpl) fetchTerms(ctx context.Context, startRecord int) ([]interface{}, error) {
+	if r, ok := s.repository.(contextRepository); ok {
+		return r.getTmeTermsFromIndexContext(ctx, startRecord)
+	}
+	return s.repository.GetTmeTermsFromIndex(startRecord)
+}
 
-		tempLocationLinks = append(tempLocationLinks, ll...)
-		for k, v := range lMap {
-			tempLocationsMap[k] = v
+// stop cancels a running reload between pages of terms, and refuses further ones. It returns once no reload is running,
+// though that may be after the page being fetched from TME arrives.
+func (s *locationServiceImpl) stop() {
+	s.stopOnce.Do(func() {
+		if s.stopping != nil {
+			close(s.stopping)
 		}
+	})
+	s.Lock()
+	defer s.Unlock()
+}
 
-		responseCount += s.maxTmeRecords
+// setGuard changes the guard checking the next loads. It waits for a reload in progress to finish.
+func (s *locationServiceImpl) setGuard(guard snapshotGuard) {
+	s.Lock()
+	defer s.Unlock()
+	s.guard = guard
+}
+
+func (s *locationServiceImpl) stopped() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
 	}
-	s.locationsMap.Store(tempLocationsMap)
-	s.locationLinks.Store(tempLocationLinks)
-	s.status.Store(DataLoaded)
-	log.Infof("Added %d location links\n", s.getLocationCount())
+}
+
+// refresh rebuilds the snapshot from the terms of the latest load, picking up changes to supplementary data and overrides
+// without fetching from TME again.
+func (s *locationServiceImpl) refresh() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.terms == nil {
+		return nil
+	}
+	return s.rebuild()
+}
+
+// rebuild builds a snapshot from the latest terms loaded from TME and applies it unless the guard rejects it. Callers must hold the lock.
+func (s *locationServiceImpl) rebuild() error {
+	collected := s.terms
+	b := newSnapshotBuilder(s.taxonomy.baseURL)
+	fromTME := s.initLocationsMap(collected, b)
+	issues := s.enrich(b.locations)
+	current, _ := s.locationsMap.Load().(locationsMap)
+	deprecated := s.deprecations.update(s.taxonomy, s.uuids, b, fromTME, current, s.deprecatedMap())
+	snap := b.build()
+	snap.issues = issues
+	snap.deprecated = deprecated
+	snap.fetchedAt, snap.terms, snap.invalid = s.fetchedAt, len(collected), len(snap.duplicates)+len(issues)
+	if len(snap.duplicates) > 0 {
+		log.Warnf("Found %d duplicate locations while loading from TME", len(snap.duplicates))
+	}
+	if err := s.guard.check(s.getLocationCount(), len(snap.links)); err != nil {
+		log.Errorf("Rejecting reloaded locations, keeping the current ones: %v", err)
+		metrics.GetOrRegisterCounter("locations."+s.taxonomy.name+".validation_failures", metrics.DefaultRegistry).Inc(1)
+		s.rejected = &snap
+		s.rejection.Store(err.Error())
+		s.status.Store(RejectedData)
+		return err
+	}
+	s.apply(snap)
+	return nil
+}
+
+func (s *locationServiceImpl) forceApply() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.rejected == nil {
+		return errNoRejectedSnapshot
+	}
+	log.Warnf("Force applying %d previously rejected location links", len(s.rejected.links))
+	s.apply(*s.rejected)
 	return nil
 }
+
+// apply swaps the served data for the given snapshot. Callers must hold the lock.
+func (s *locationServiceImpl) apply(snap snapshot) {
+	previous, _ := s.versions.Load().(map[string]contentVersion)
+	versions, version := versionLocations(snap.locations, previous, s.getSnapshotVersion(), time.Now())
+	s.locationsMap.Store(snap.locations)
+	s.locationLinks.Store(snap.links)
+	s.byType.Store(snap.byType)
+	s.byIdentifier.Store(snap.byIdentifier)
+	s.spatial.Store(snap.spatial)
+	s.search.Store(snap.search)
+	s.deprecated.Store(snap.deprecated)
+	s.versions.Store(versions)
+	s.version.Store(version)
+	payloads, err := snapshotPayloads(snap.locations, snap.links)
+	if err != nil {
+		log.Errorf("Could not serialise locations of taxonomy %s, they will be encoded on every request: %v", s.taxonomy.name, err)
+		payloads = map[string]*payload{}
+	}
+	s.payloads.Store(payloads)
+	metrics.GetOrRegisterGauge("locations."+s.taxonomy.name+".deprecated", metrics.DefaultRegistry).Update(int64(len(snap.deprecated)))
+	if err := s.deprecations.save(snap.deprecated); err != nil {
+		log.Errorf("Could not persist deprecated locations for taxonomy %s: %v", s.taxonomy.name, err)
+	}
+	s.issues.Store(snap.issues)
+	metrics.GetOrRegisterGauge("locations."+s.taxonomy.name+".enrichment_issues", metrics.DefaultRegistry).Update(int64(len(snap.issues)))
+	s.duplicates.Store(snap.duplicates)
+	metrics.GetOrRegisterGauge("locations."+s.taxonomy.name+".duplicates", metrics.DefaultRegistry).Update(int64(len(snap.duplicates)))
+	s.rejected = nil
+	s.rejection.Store("")
+	s.status.Store(DataLoaded)
+	// The stats describe the locations served, so a rejected load leaves them alone. Refreshing the locations served
+	// from the same terms keeps the count of the load before them.
+	st := s.getLoadStats()
+	if !snap.fetchedAt.Equal(st.loadedAt) {
+		st.previousCount = st.count
+	}
+	st.loadedAt, st.terms, st.count, st.invalid = snap.fetchedAt, snap.terms, len(snap.links), snap.invalid
+	s.stats.Store(st)
+	log.Infof("Added %d location links for taxonomy %s\n", s.getLocationCount(), s.taxonomy.name)
+}
@@ -5,12 +5,18 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
+var (
+	glTaxonomy        = taxonomyConfig{name: "GL", locationType: "Location"}
+	testClassifier, _ = newClassifier("")
+)
+
 func TestGetLocations(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -29,8 +35,8 @@ func TestGetLocations(t *testing.T) {
 
 	for _, test := range tests {
 		repo := dummyRepo{terms: test.terms, err: test.err}
-		service, err := newLocationService(&repo, test.baseURL, "Locations", 10000)
-		expectedLocations, found := service.getLocations()
+		service, err := newLocationService(&repo, taxonomyConfig{name: "Locations", locationType: "Location", baseURL: test.baseURL}, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+		expectedLocations, found := service.getLocations("")
 		assert.Equal(t, test.locations, expectedLocations, fmt.Sprintf("%s: Expected locations link incorrect", test.name))
 		assert.Equal(t, test.found, found)
 		assert.Equal(t, test.err, err)
@@ -56,7 +62,7 @@ func TestGetLocationByUuid(t *testing.T) {
 	for _, test := range tests {
 		log.Infof("Running test: %v", test.name)
 		repo := dummyRepo{terms: test.terms, err: test.err}
-		service, err := newLocationService(&repo, "", "GL", 10000)
+		service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
 		expectedLocation, found := service.getLocationByUUID(test.uuid)
 		assert.Equal(t, test.location, expectedLocation, fmt.Sprintf("%s: Expected location incorrect", test.name))
 		assert.Equal(t, test.found, found)
@@ -70,7 +76,7 @@ func TestReloadChangesStatus(t *testing.T) {
 			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
 			{CanonicalName: "Test_location", RawID: "NGQ2MWQ0NDMtMDc5Mi00NWExLTlkMGQtNWZhZjk0NGExOWU2-Z2VucmVz"}},
 		err: nil}
-	service, err := newLocationService(&repo, "", "GL", 10000)
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
 	assert.NoError(t, err)
 	assert.Equal(t, DataLoaded, service.getLoadStatus())
 	repo.Add(1)
@@ -118,7 +124,7 @@ func TestGetCount(t *testing.T) {
 
 	for _, test := range tests {
 		repo := dummyRepo{terms: test.terms, err: test.err}
-		service, err := newLocationService(&repo, test.baseURL, "Locations", 10000)
+		service, err := newLocationService(&repo, taxonomyConfig{name: "Locations", locationType: "Location", baseURL: test.baseURL}, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
 		actualCount := service.getLocationCount()
 		assert.Equal(t, len(test.locations), actualCount, fmt.Sprintf("%s: Expected locations count incorrect", test.name))
 		assert.Equal(t, test.err, err)
@@ -143,8 +149,8 @@ func TestGetLocationIds(t *testing.T) {
 
 	for _, test := range tests {
 		repo := dummyRepo{terms: test.terms, err: test.err}
-		service, err := newLocationService(&repo, test.baseURL, "Locations", 10000)
-		actualIds := service.getLocationIds()
+		service, err := newLocationService(&repo, taxonomyConfig{name: "Locations", locationType: "Location", baseURL: test.baseURL}, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+		actualIds := service.getLocationIds("")
 		for _, v := range test.locations {
 			expectedID := strings.Split(v.APIURL, "/")[3]
 			assert.Contains(t, actualIds, expectedID, fmt.Sprintf("%s: Expected locations IDS incorrect", test.name))
@@ -160,7 +166,7 @@ func TestReload(t *testing.T) {
 			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
 			{CanonicalName: "Test_location", RawID: "NGQ2MWQ0NDMtMDc5Mi00NWExLTlkMGQtNWZhZjk0NGExOWU2-Z2VucmVz"}},
 		err: nil}
-	service, err := newLocationService(&repo, "", "GL", 10000)
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
 	assert.NoError(t, err)
 	assert.Equal(t, 2, service.getLocationCount())
 
@@ -174,6 +180,132 @@ func TestReload(t *testing.T) {
 	assert.Equal(t, 3, service.getLocationCount())
 }
 
+func TestReloadRejectsDrop(t *testing.T) {
+	repo := dummyRepo{
+		terms: []term{
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "NGQ2MWQ0NDMtMDc5Mi00NWExLTlkMGQtNWZhZjk0NGExOWU2-Z2VucmVz"},
+			{CanonicalName: "Test_location", RawID: "NGQ2MWQZ2VucmVz"}},
+		err: nil}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{maxDropPercent: 50}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	assert.Equal(t, errNoRejectedSnapshot, service.forceApply())
+
+	repo.terms = repo.terms[:1]
+	err = service.reload()
+	assert.Error(t, err)
+	assert.Equal(t, RejectedData, service.getLoadStatus())
+	assert.Equal(t, err.Error(), service.getRejection())
+	assert.Equal(t, 3, service.getLocationCount())
+	assert.Len(t, service.getLocationIds(""), 3)
+
+	assert.NoError(t, service.forceApply())
+	assert.Equal(t, DataLoaded, service.getLoadStatus())
+	assert.Empty(t, service.getRejection())
+	assert.Equal(t, 1, service.getLocationCount())
+	assert.Len(t, service.getLocationIds(""), 1)
+}
+
+func TestForceApplyLoadRejectedAtStartup(t *testing.T) {
+	repo := dummyRepo{
+		terms: []term{
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "NGQ2MWQZ2VucmVz"}},
+		err: nil}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{minCount: 10}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.Error(t, err)
+	assert.Equal(t, RejectedData, service.getLoadStatus())
+	assert.Equal(t, err.Error(), service.getRejection())
+	assert.Equal(t, 0, service.getLocationCount())
+
+	assert.NoError(t, service.forceApply())
+	assert.Equal(t, DataLoaded, service.getLoadStatus())
+	assert.Equal(t, 2, service.getLocationCount())
+}
+
+func TestReloadAfterFailedStartup(t *testing.T) {
+	repo := dummyRepo{err: errors.New("TME is down")}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.Error(t, err)
+	assert.Equal(t, ErrorLoadingData, service.getLoadStatus())
+
+	repo.err = nil
+	repo.terms = []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}
+	assert.NoError(t, service.reload())
+	assert.Equal(t, DataLoaded, service.getLoadStatus())
+	assert.Equal(t, 1, service.getLocationCount())
+}
+
+func TestReloadDuplicates(t *testing.T) {
+	repo := dummyRepo{
+		terms: []term{
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"},
+			{CanonicalName: "Test_location", RawID: "NGQ2MWQZ2VucmVz"}},
+		err: nil}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, service.getLocationCount())
+	assert.Len(t, service.getLocationIds(""), 2)
+	assert.Len(t, service.getDuplicates(), 1)
+
+	repo.terms = repo.terms[1:]
+	assert.NoError(t, service.reload())
+	assert.Empty(t, service.getDuplicates())
+}
+
+func TestGetLocationsByType(t *testing.T) {
+	repo := dummyRepo{
+		terms: []term{
+			{CanonicalName: "France", RawID: "france", Attributes: []tmeAttribute{{Name: "type", Value: "Country"}}},
+			{CanonicalName: "Paris", RawID: "paris", ParentID: "france", Attributes: []tmeAttribute{{Name: "type", Value: "City"}}},
+			{CanonicalName: "Somewhere", RawID: "somewhere"}},
+		err: nil}
+	service, err := newLocationService(&repo, taxonomyConfig{name: "GL", locationType: "Location", baseURL: "localhost:8080/transformers/locations/"}, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+
+	countries, found := service.getLocations("Country")
+	assert.True(t, found)
+	assert.Len(t, countries, 1)
+	assert.Len(t, service.getLocationIds("City"), 1)
+	assert.Len(t, service.getLocationIds("Location"), 3)
+	_, found = service.getLocations("Continent")
+	assert.False(t, found)
+	assert.Empty(t, service.getLocationIds("Continent"))
+
+	paris, found := service.getLocationByUUID(service.getLocationIds("City")[0])
+	assert.True(t, found)
+	assert.Equal(t, "City", paris.Type)
+	assert.Equal(t, []string{"Thing", "Concept", "Location", "City"}, paris.Types)
+}
+
+func TestGetLocationByIdentifier(t *testing.T) {
+	path := writeTempFile(t, "concordances*.csv", "tmeIdentifier,authority,identifierValue\n"+
+		"Y0dGeWFYTT0=-R0w=,GeoNames,2988507\n"+
+		"Y0dGeWFYTT0=-R0w=,ISO3166,FR-75\n"+
+		"bWlzc2luZw==-R0w=,Wikidata,Q1\n")
+	defer os.Remove(path)
+	c, err := newConcordances(path)
+	assert.NoError(t, err)
+
+	repo := dummyRepo{terms: []term{{CanonicalName: "Paris", RawID: "cGFyaXM="}}}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, []enricher{c}, &deprecations{})
+	assert.NoError(t, err)
+
+	paris, found := service.getLocationByIdentifier("geonames", "2988507")
+	assert.True(t, found)
+	assert.Equal(t, "Paris", paris.PrefLabel)
+	assert.Equal(t, []string{"FR-75"}, paris.AlternativeIdentifiers.ISO3166)
+
+	byUUID, found := service.getLocationByIdentifier("UUID", paris.UUID)
+	assert.True(t, found)
+	assert.Equal(t, paris, byUUID)
+
+	_, found = service.getLocationByIdentifier("Wikidata", "Q1")
+	assert.False(t, found)
+	assert.Len(t, service.getEnrichmentIssues(), 1)
+}
+
 type dummyLockRepo struct {
 	sync.WaitGroup
 	terms []term
@@ -216,8 +348,9 @@ func (d *dummyRepo) GetTmeTermById(uuid string) (interface{}, error) {
 
 func getDummyLocation(uuid string, prefLabel string, tmeId string) location {
 	return location{
-		UUID:      uuid,
-		PrefLabel: prefLabel,
-		Type:      "Location",
+		UUID:                   uuid,
+		PrefLabel:              prefLabel,
+		Type:                   "Location",
+		Types:                  []string{"Thing", "Concept", "Location"},
 		AlternativeIdentifiers: alternativeIdentifiers{TME: []string{tmeId}, Uuids: []string{uuid}}}
 }
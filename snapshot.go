@@ -0,0 +1,137 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+type snapshot struct {
+	locations    locationsMap
+	links        locationLinks
+	duplicates   []duplicateLocation
+	byType       map[string][]string
+	byIdentifier map[string]string
+	spatial      *spatialIndex
+	search       *searchIndex
+	deprecated   map[string]deprecatedLocation
+	issues       []enrichmentIssue
+	// fetchedAt is when the terms the snapshot was built from were fetched from TME, telling snapshots of the same load
+	// apart from those of a new one.
+	fetchedAt time.Time
+	terms     int
+	invalid   int
+}
+
+// duplicateLocation records TME terms that transformed to the same UUID during a load.
+type duplicateLocation struct {
+	UUID           string   `json:"uuid"`
+	TMEIdentifiers []string `json:"tmeIdentifiers"`
+	Kept           string   `json:"kept"`
+}
+
+// snapshotBuilder accumulates transformed locations into a snapshot, keeping the map and links consistent.
+// When several terms share a UUID the one with the lowest TME identifier, then the lowest prefLabel, wins,
+// so the outcome does not depend on the order TME returns terms in.
+type snapshotBuilder struct {
+	baseURL    string
+	locations  locationsMap
+	links      locationLinks
+	order      []string
+	duplicates map[string]*duplicateLocation
+}
+
+func newSnapshotBuilder(baseURL string) *snapshotBuilder {
+	return &snapshotBuilder{
+		baseURL:    baseURL,
+		locations:  make(locationsMap),
+		links:      make(locationLinks, 0),
+		duplicates: make(map[string]*duplicateLocation),
+	}
+}
+
+func (b *snapshotBuilder) add(l location) {
+	existing, found := b.locations[l.UUID]
+	if !found {
+		b.locations[l.UUID] = l
+		b.links = append(b.links, locationLink{APIURL: b.baseURL + l.UUID})
+		b.order = append(b.order, l.UUID)
+		return
+	}
+
+	d, found := b.duplicates[l.UUID]
+	if !found {
+		d = &duplicateLocation{UUID: l.UUID, TMEIdentifiers: append([]string{}, existing.AlternativeIdentifiers.TME...)}
+		b.duplicates[l.UUID] = d
+	}
+	d.TMEIdentifiers = append(d.TMEIdentifiers, l.AlternativeIdentifiers.TME...)
+	if precedes(l, existing) {
+		b.locations[l.UUID] = l
+	}
+	d.Kept = tmeIdentifier(b.locations[l.UUID])
+	log.Warnf("Found duplicate location with uuid=%s, tmeIdentifiers=%v, kept=%s", d.UUID, d.TMEIdentifiers, d.Kept)
+}
+
+// remove drops a location that should no longer be served.
+func (b *snapshotBuilder) remove(uuid string) {
+	if _, found := b.locations[uuid]; !found {
+		return
+	}
+	delete(b.locations, uuid)
+	for i, u := range b.order {
+		if u == uuid {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	for i, link := range b.links {
+		if link.APIURL == b.baseURL+uuid {
+			b.links = append(b.links[:i], b.links[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *snapshotBuilder) build() snapshot {
+	duplicates := make([]duplicateLocation, 0, len(b.duplicates))
+	for _, d := range b.duplicates {
+		duplicates = append(duplicates, *d)
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].UUID < duplicates[j].UUID
+	})
+	byType := make(map[string][]string)
+	for _, uuid := range b.order {
+		for _, t := range b.locations[uuid].Types {
+			byType[t] = append(byType[t], uuid)
+		}
+	}
+	byIdentifier := make(map[string]string)
+	for _, uuid := range b.order {
+		for authority, values := range b.locations[uuid].AlternativeIdentifiers.byAuthority() {
+			for _, v := range values {
+				byIdentifier[identifierKey(authority, v)] = uuid
+			}
+		}
+	}
+	return snapshot{locations: b.locations, links: b.links, duplicates: duplicates, byType: byType, byIdentifier: byIdentifier, spatial: newSpatialIndex(b.locations, b.order), search: newSearchIndex(b.locations, b.order), issues: []enrichmentIssue{}}
+}
+
+func identifierKey(authority string, value string) string {
+	return strings.ToLower(authority) + ":" + value
+}
+
+func precedes(a location, b location) bool {
+	if tmeIdentifier(a) != tmeIdentifier(b) {
+		return tmeIdentifier(a) < tmeIdentifier(b)
+	}
+	return a.PrefLabel < b.PrefLabel
+}
+
+func tmeIdentifier(l location) string {
+	if len(l.AlternativeIdentifiers.TME) == 0 {
+		return ""
+	}
+	return l.AlternativeIdentifiers.TME[0]
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSnapshotBuilderDuplicates(t *testing.T) {
+	first := getDummyLocation(testUUID, "Second", "Qg==-R0w=")
+	second := getDummyLocation(testUUID, "First", "QQ==-R0w=")
+	other := getDummyLocation("e559b6c0-2241-35b9-b970-e55cb8be4cba", "Other", "Qw==-R0w=")
+
+	for _, order := range [][]location{{first, second, other}, {other, second, first}} {
+		b := newSnapshotBuilder("localhost:8080/transformers/locations/")
+		for _, l := range order {
+			b.add(l)
+		}
+		snap := b.build()
+
+		assert.Len(t, snap.locations, 2)
+		assert.Len(t, snap.links, 2)
+		assert.Equal(t, second, snap.locations[testUUID])
+		assert.Equal(t, []duplicateLocation{{UUID: testUUID, TMEIdentifiers: snap.duplicates[0].TMEIdentifiers, Kept: "QQ==-R0w="}}, snap.duplicates)
+		assert.ElementsMatch(t, []string{"Qg==-R0w=", "QQ==-R0w="}, snap.duplicates[0].TMEIdentifiers)
+	}
+}
+
+func TestSnapshotBuilderSameTmeIdentifier(t *testing.T) {
+	b := newSnapshotBuilder("")
+	b.add(getDummyLocation(testUUID, "Zurich", "QQ==-R0w="))
+	b.add(getDummyLocation(testUUID, "Geneva", "QQ==-R0w="))
+	snap := b.build()
+
+	assert.Equal(t, "Geneva", snap.locations[testUUID].PrefLabel)
+	assert.Equal(t, locationLinks{{APIURL: testUUID}}, snap.links)
+	assert.Equal(t, []string{"QQ==-R0w=", "QQ==-R0w="}, snap.duplicates[0].TMEIdentifiers)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// taxonomyConfig describes one TME taxonomy served by this instance.
+type taxonomyConfig struct {
+	name         string
+	locationType string
+	routePrefix  string
+	baseURL      string
+}
+
+// parseTaxonomies parses entries of the form name:type:routePrefix[:baseURL]. When the base URL is omitted, it is
+// defaultBaseURL with its route prefix swapped for the taxonomy's, so the default GL entry keeps the existing links and
+// every taxonomy keeps the path the service is mounted under behind a proxy.
+func parseTaxonomies(entries []string, defaultBaseURL string) ([]taxonomyConfig, error) {
+	base, err := url.Parse(defaultBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base url %q: %v", defaultBaseURL, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("At least one taxonomy must be configured")
+	}
+
+	taxonomies := make([]taxonomyConfig, 0, len(entries))
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 4)
+		if len(parts) < 3 || parts[0] == "" || parts[1] == "" || !strings.HasPrefix(parts[2], "/") {
+			return nil, fmt.Errorf("Invalid taxonomy %q, expected name:type:/route/prefix[:baseURL]", entry)
+		}
+		t := taxonomyConfig{name: parts[0], locationType: parts[1], routePrefix: strings.TrimSuffix(parts[2], "/")}
+		if len(parts) == 4 {
+			t.baseURL = parts[3]
+		}
+		if names[t.name] || prefixes[t.routePrefix] {
+			return nil, fmt.Errorf("Taxonomy %q reuses a name or route prefix", entry)
+		}
+		names[t.name] = true
+		prefixes[t.routePrefix] = true
+		taxonomies = append(taxonomies, t)
+	}
+
+	// The base url points at the routes of one of the taxonomies, under whatever path the service is mounted at.
+	mount := strings.TrimSuffix(base.Path, "/")
+	for _, t := range taxonomies {
+		if strings.HasSuffix(mount, t.routePrefix) {
+			mount = strings.TrimSuffix(mount, t.routePrefix)
+			break
+		}
+	}
+	for i, t := range taxonomies {
+		if t.baseURL == "" {
+			u := *base
+			u.Path, u.RawPath = mount+t.routePrefix+"/", ""
+			taxonomies[i].baseURL = u.String()
+		}
+	}
+	return taxonomies, nil
+}
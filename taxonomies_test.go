@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTaxonomies(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []string
+		taxonomies []taxonomyConfig
+		valid      bool
+	}{
+		{"Default", []string{"GL:Location:/transformers/locations"},
+			[]taxonomyConfig{{name: "GL", locationType: "Location", routePrefix: "/transformers/locations", baseURL: "http://localhost:8080/transformers/locations/"}}, true},
+		{"Several", []string{"GL:Location:/transformers/locations/", "ON:Region:/transformers/regions:http://api.ft.com/things/"},
+			[]taxonomyConfig{
+				{name: "GL", locationType: "Location", routePrefix: "/transformers/locations", baseURL: "http://localhost:8080/transformers/locations/"},
+				{name: "ON", locationType: "Region", routePrefix: "/transformers/regions", baseURL: "http://api.ft.com/things/"}}, true},
+		{"None", []string{}, nil, false},
+		{"Missing type", []string{"GL::/transformers/locations"}, nil, false},
+		{"Relative prefix", []string{"GL:Location:transformers/locations"}, nil, false},
+		{"Duplicate prefix", []string{"GL:Location:/transformers/locations", "ON:Region:/transformers/locations"}, nil, false},
+	}
+
+	for _, test := range tests {
+		taxonomies, err := parseTaxonomies(test.entries, "http://localhost:8080/transformers/locations/")
+		assert.Equal(t, test.valid, err == nil, fmt.Sprintf("%s: Unexpected error %v", test.name, err))
+		assert.Equal(t, test.taxonomies, taxonomies, fmt.Sprintf("%s: Unexpected taxonomies", test.name))
+	}
+}
+
+func TestParseTaxonomiesUnderPrefixedBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		entries  []string
+		baseURLs []string
+	}{
+		{"Default", "https://host/__locations-transformer/transformers/locations/", []string{"GL:Location:/transformers/locations"},
+			[]string{"https://host/__locations-transformer/transformers/locations/"}},
+		{"Several", "https://host/__locations-transformer/transformers/locations/", []string{"GL:Location:/transformers/locations", "ON:Region:/transformers/regions"},
+			[]string{"https://host/__locations-transformer/transformers/locations/", "https://host/__locations-transformer/transformers/regions/"}},
+		{"Base url of a later taxonomy", "https://host/__locations-transformer/transformers/regions", []string{"GL:Location:/transformers/locations", "ON:Region:/transformers/regions"},
+			[]string{"https://host/__locations-transformer/transformers/locations/", "https://host/__locations-transformer/transformers/regions/"}},
+		{"Base url of no taxonomy", "https://host/things/", []string{"GL:Location:/transformers/locations"},
+			[]string{"https://host/things/transformers/locations/"}},
+	}
+
+	for _, test := range tests {
+		taxonomies, err := parseTaxonomies(test.entries, test.baseURL)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		var baseURLs []string
+		for _, taxonomy := range taxonomies {
+			baseURLs = append(baseURLs, taxonomy.baseURL)
+		}
+		assert.Equal(t, test.baseURLs, baseURLs, test.name)
+	}
+}
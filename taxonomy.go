@@ -6,6 +6,13 @@ type taxonomy struct {
 
 //TODO revise fields
 type term struct {
-	CanonicalName string `xml:"name"`
-	RawID         string `xml:"id"`
+	CanonicalName string         `xml:"name"`
+	RawID         string         `xml:"id"`
+	ParentID      string         `xml:"parent>id"`
+	Attributes    []tmeAttribute `xml:"attributes>attribute"`
+}
+
+type tmeAttribute struct {
+	Name  string `xml:"name"`
+	Value string `xml:"value"`
 }
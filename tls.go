@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+)
+
+const pinPrefix = "sha256/"
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// tlsOptions set how the connections to TME are secured.
+type tlsOptions struct {
+	// caFile holds PEM certificates trusted on top of the system ones.
+	caFile string
+	// certFile and keyFile hold the PEM client certificate and key presented to TME.
+	certFile   string
+	keyFile    string
+	minVersion string
+	// pins are base64 SHA-256 hashes of the public keys TME may present, as sha256/<hash>. A connection is only made if
+	// a certificate of the chain verified for TME has one of them.
+	pins []string
+	// insecure skips verifying TME's certificate, for local fake servers only.
+	insecure bool
+}
+
+func newTLSConfig(opts tlsOptions) (*tls.Config, error) {
+	version, found := tlsVersions[opts.minVersion]
+	if !found {
+		return nil, fmt.Errorf("Unsupported minimum TLS version %q, expected 1.2 or 1.3", opts.minVersion)
+	}
+	config := &tls.Config{MinVersion: version}
+
+	if opts.caFile != "" {
+		pem, err := ioutil.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No PEM certificates found in %s", opts.caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (opts.certFile == "") != (opts.keyFile == "") {
+		return nil, errors.New("A client certificate needs both a certificate and a key file")
+	}
+	if opts.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.pins) > 0 {
+		if opts.insecure {
+			return nil, errors.New("Pins cannot be checked when TLS certificates are not verified")
+		}
+		pins := make(map[string]bool)
+		for _, pin := range opts.pins {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+			if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("Invalid pin %q, expected sha256/ followed by the base64 SHA-256 hash of a public key", pin)
+			}
+			pins[pin] = true
+		}
+		// Only the chains verified up to a trusted root count, as a server may present any certificate beside them.
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if pins[publicKeyPin(cert)] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("No certificate of the chain verified for %s matches a pinned public key", cs.ServerName)
+		}
+	}
+
+	if opts.insecure {
+		log.WithField("event", "insecure_tls").Warn("TLS certificates of TME are NOT verified, so credentials may be sent to anyone. Only use tme-tls-insecure-skip-verify with local fake servers")
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// publicKeyPin hashes the public key of a certificate, so pins survive the certificate being renewed with the same key.
+func publicKeyPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+// newCertificate signs a certificate made from template with the parent's key, or self-signs it without a parent.
+func newCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return cert, key
+}
+
+// clientCertificate makes a self-signed client certificate, returning the paths of its certificate and key files.
+func clientCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "locations-transformer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var clientCerts int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		clientCerts = len(req.TLS.PeerCertificates)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	certFile, keyFile := clientCertificate(t, dir)
+	serverPin := publicKeyPin(server.Certificate())
+	otherPin := "sha256/47DEQpj8HBSa+/TZIW2cVsJ3gkx8PJFPCw5bE2UsPZY="
+
+	tests := []struct {
+		name        string
+		opts        tlsOptions
+		connects    bool
+		clientCerts int
+	}{
+		{"Unknown CA", tlsOptions{minVersion: "1.2"}, false, 0},
+		{"Custom CA", tlsOptions{minVersion: "1.2", caFile: caFile}, true, 0},
+		{"Client certificate", tlsOptions{minVersion: "1.2", caFile: caFile, certFile: certFile, keyFile: keyFile}, true, 1},
+		{"TLS 1.3", tlsOptions{minVersion: "1.3", caFile: caFile}, true, 0},
+		{"Pinned key", tlsOptions{minVersion: "1.2", caFile: caFile, pins: []string{otherPin, serverPin}}, true, 0},
+		{"Other pinned key", tlsOptions{minVersion: "1.2", caFile: caFile, pins: []string{otherPin}}, false, 0},
+		{"Insecure", tlsOptions{minVersion: "1.2", insecure: true}, true, 0},
+	}
+	for _, test := range tests {
+		config, err := newTLSConfig(test.opts)
+		if !assert.NoError(t, err, test.name) {
+			continue
+		}
+		clientCerts = 0
+		client := &http.Client{Transport: getTMETransport(config)}
+		resp, err := client.Get(server.URL)
+		if !test.connects {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			resp.Body.Close()
+			assert.Equal(t, test.clientCerts, clientCerts, test.name)
+		}
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts tlsOptions
+		err  string
+	}{
+		{"Old TLS version", tlsOptions{minVersion: "1.0"}, "Unsupported minimum TLS version \"1.0\", expected 1.2 or 1.3"},
+		{"Missing CA file", tlsOptions{minVersion: "1.2", caFile: "/does/not/exist.pem"}, "open /does/not/exist.pem: no such file or directory"},
+		{"Certificate without key", tlsOptions{minVersion: "1.2", certFile: "client.pem"}, "A client certificate needs both a certificate and a key file"},
+		{"Invalid pin", tlsOptions{minVersion: "1.2", pins: []string{"md5/abc"}}, "Invalid pin \"md5/abc\", expected sha256/ followed by the base64 SHA-256 hash of a public key"},
+		{"Insecure with pins", tlsOptions{minVersion: "1.2", insecure: true, pins: []string{"sha256/47DEQpj8HBSa+/TZIW2cVsJ3gkx8PJFPCw5bE2UsPZY="}}, "Pins cannot be checked when TLS certificates are not verified"},
+	}
+	for _, test := range tests {
+		_, err := newTLSConfig(test.opts)
+		assert.EqualError(t, err, test.err, test.name)
+	}
+}
+
+func TestPinsIgnoreCertificatesOutsideTheVerifiedChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca, caKey := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Trusted CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	leaf, leafKey := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "tme"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	pinned, _ := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "Pinned TME key"}}, nil, nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw, pinned.Raw}, PrivateKey: leafKey}}}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw)
+
+	tests := []struct {
+		name     string
+		pins     []string
+		connects bool
+	}{
+		{"Pinned certificate sent beside a valid chain", []string{publicKeyPin(pinned)}, false},
+		{"Pinned leaf", []string{publicKeyPin(leaf)}, true},
+		{"Pinned CA", []string{publicKeyPin(ca)}, true},
+	}
+	for _, test := range tests {
+		config, err := newTLSConfig(tlsOptions{minVersion: "1.2", caFile: caFile, pins: test.pins})
+		assert.NoError(t, err, test.name)
+		resp, err := (&http.Client{Transport: getTMETransport(config)}).Get(server.URL)
+		if !test.connects {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		if assert.NoError(t, err, test.name) {
+			resp.Body.Close()
+		}
+	}
+}
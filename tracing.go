@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Financial-Times/tme-reader/tmereader"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	serviceName = "locations-transformer"
+	tracerName  = "github.com/Financial-Times/locations-transformer"
+)
+
+// newTracerProvider sets up tracing with spans sent to an OTLP collector, configured by the standard
+// OTEL_EXPORTER_OTLP_* variables, or written to stdout. Without an exporter spans are not recorded, but W3C trace
+// context is still passed on.
+func newTracerProvider(exporter string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var e sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		e, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		e, err = otlptracehttp.New(context.Background())
+	default:
+		return nil, fmt.Errorf("Unknown tracing exporter %q, expected none, stdout or otlp", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(e),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracingHandler starts a span for each request, continuing the trace of the caller.
+func tracingHandler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, serviceName)
+}
+
+// routeSpans is a mux middleware naming the span of a request after the route it matched, and tagging it with the
+// transaction id of the request.
+func routeSpans(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		span := trace.SpanFromContext(req.Context())
+		if route := mux.CurrentRoute(req); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				span.SetName(req.Method + " " + template)
+				span.SetAttributes(attribute.String("http.route", template))
+			}
+		}
+		if tid := transactionID(writer, req); tid != "" {
+			span.SetAttributes(attribute.String("ft.transaction_id", tid))
+		}
+		next.ServeHTTP(writer, req)
+	})
+}
+
+type transactionIDKey struct{}
+
+// newTransactionID makes up a transaction id for work not started by a request, such as reloads.
+func newTransactionID() string {
+	return "tid_" + uuid.NewRandom().String()
+}
+
+func withTransactionID(ctx context.Context, tid string) context.Context {
+	return context.WithValue(ctx, transactionIDKey{}, tid)
+}
+
+// endSpan records an error, if any, on a span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// contextRepository fetches terms as part of a trace.
+type contextRepository interface {
+	getTmeTermsFromIndexContext(ctx context.Context, startRecord int) ([]interface{}, error)
+}
+
+// tracedRepository traces the pages of terms fetched from TME. The TME reader takes no context, so the page being
+// fetched is kept for the client the reader was given, which is one made by client.
+type tracedRepository struct {
+	tmereader.Repository
+	taxonomy string
+	current  atomic.Value
+}
+
+// pageContext holds the context of the page being fetched, as an atomic.Value only holds values of a single type.
+type pageContext struct {
+	ctx context.Context
+}
+
+func newTracedRepository(taxonomy string) *tracedRepository {
+	return &tracedRepository{taxonomy: taxonomy}
+}
+
+func (r *tracedRepository) getTmeTermsFromIndexContext(ctx context.Context, startRecord int) ([]interface{}, error) {
+	ctx, span := tracer().Start(ctx, "fetch TME terms", trace.WithAttributes(attribute.String("taxonomy", r.taxonomy), attribute.Int("tme.start_record", startRecord)))
+	r.current.Store(pageContext{ctx})
+	defer r.current.Store(pageContext{})
+	terms, err := r.GetTmeTermsFromIndex(startRecord)
+	span.SetAttributes(attribute.Int("tme.terms", len(terms)))
+	endSpan(span, err)
+	return terms, err
+}
+
+// client passes the trace context and transaction id of the page being fetched on to TME.
+func (r *tracedRepository) client(next httpClient) httpClient {
+	return tracedClient{next: next, repository: r}
+}
+
+type tracedClient struct {
+	next       httpClient
+	repository *tracedRepository
+}
+
+func (c tracedClient) Do(req *http.Request) (*http.Response, error) {
+	if page, ok := c.repository.current.Load().(pageContext); ok && page.ctx != nil {
+		req = req.WithContext(page.ctx)
+		if tid, ok := page.ctx.Value(transactionIDKey{}).(string); ok && req.Header.Get(transactionIDHeader) == "" {
+			req.Header.Set(transactionIDHeader, tid)
+		}
+	}
+	return c.next.Do(req)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordSpans records the spans ended during a test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func endedSpan(recorder *tracetest.SpanRecorder, name string) (sdktrace.ReadOnlySpan, bool) {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span, true
+		}
+	}
+	return nil, false
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	tp, err := newTracerProvider("none", 1)
+	assert.NoError(t, err)
+	assert.Nil(t, tp)
+
+	tp, err = newTracerProvider("stdout", 0.5)
+	assert.NoError(t, err)
+	assert.NotNil(t, tp)
+	assert.NoError(t, tp.Shutdown(context.Background()))
+
+	_, err = newTracerProvider("jaeger", 1)
+	assert.Error(t, err)
+}
+
+func TestRouteSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	_, err := newTracerProvider("none", 1)
+	assert.NoError(t, err)
+	m := mux.NewRouter()
+	m.HandleFunc("/transformers/locations/{uuid}", func(writer http.ResponseWriter, req *http.Request) {}).Methods("GET")
+	m.Use(routeSpans)
+
+	req := newRequest("GET", "/transformers/locations/"+testUUID)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(transactionIDHeader, "tid_test")
+	tracingHandler(m).ServeHTTP(httptest.NewRecorder(), req)
+
+	span, found := endedSpan(recorder, "GET /transformers/locations/{uuid}")
+	assert.True(t, found, "The span should be named after the route")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(), "The span should continue the caller's trace")
+	assert.Contains(t, span.Attributes(), attribute.String("ft.transaction_id", "tid_test"))
+	assert.Contains(t, span.Attributes(), attribute.String("http.route", "/transformers/locations/{uuid}"))
+}
+
+// httpRepository fetches a page of terms over HTTP, as the TME reader does.
+type httpRepository struct {
+	dummyRepo
+	client httpClient
+	url    string
+}
+
+func (r *httpRepository) GetTmeTermsFromIndex(startRecord int) ([]interface{}, error) {
+	req, _ := http.NewRequest("GET", r.url, nil)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return r.dummyRepo.GetTmeTermsFromIndex(startRecord)
+}
+
+func TestReloadSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	_, err := newTracerProvider("none", 1)
+	assert.NoError(t, err)
+	var traceparents, transactionIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		traceparents = append(traceparents, req.Header.Get("traceparent"))
+		transactionIDs = append(transactionIDs, req.Header.Get(transactionIDHeader))
+	}))
+	defer server.Close()
+
+	repo := newTracedRepository("GL")
+	repo.Repository = &httpRepository{
+		dummyRepo: dummyRepo{terms: []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}},
+		client:    repo.client(&http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}),
+		url:       server.URL,
+	}
+	_, err = newLocationService(repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+
+	reload, found := endedSpan(recorder, "reload")
+	assert.True(t, found)
+	for _, name := range []string{"fetch TME terms", "rebuild locations"} {
+		span, found := endedSpan(recorder, name)
+		assert.True(t, found, name)
+		assert.Equal(t, reload.SpanContext().TraceID(), span.SpanContext().TraceID(), name)
+	}
+	assert.Len(t, traceparents, 2, "One request for the page of terms, and one finding there are no more")
+	for i, tp := range traceparents {
+		assert.Contains(t, tp, reload.SpanContext().TraceID().String(), "TME should be sent the trace context of the reload")
+		assert.True(t, strings.HasPrefix(transactionIDs[i], "tid_"), transactionIDs[i])
+	}
+	assert.Contains(t, reload.Attributes(), attribute.String("ft.transaction_id", transactionIDs[0]))
+}
+
+func TestManualReloadKeepsTransactionID(t *testing.T) {
+	service := &dummyService{dataLoaded: DataLoaded, reloads: make(chan context.Context, 1)}
+	req := newAdminRequest("POST", "/transformers/locations/__reload", testAdminToken)
+	req.Header.Set(transactionIDHeader, "tid_caller")
+	rec := httptest.NewRecorder()
+	router(service).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	ctx := <-service.reloads
+	assert.Equal(t, "tid_caller", ctx.Value(transactionIDKey{}), "The reload should be part of the transaction of the request")
+
+	recorder := recordSpans(t)
+	_, err := newTracerProvider("none", 1)
+	assert.NoError(t, err)
+	repo := dummyRepo{terms: []term{{CanonicalName: "Test_location", RawID: "b8337559-ac08-3404-9025-bad51ebe2fc7"}}}
+	loaded, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	assert.NoError(t, loaded.reloadContext(ctx))
+	var tids []attribute.KeyValue
+	for _, span := range recorder.Ended() {
+		if span.Name() == "reload" {
+			for _, a := range span.Attributes() {
+				if a.Key == "ft.transaction_id" {
+					tids = append(tids, a)
+				}
+			}
+		}
+	}
+	if assert.Len(t, tids, 2, "One reload at startup and one asked for") {
+		assert.True(t, strings.HasPrefix(tids[0].Value.AsString(), "tid_") && tids[0].Value.AsString() != "tid_caller", "A reload not asked for by a request should get a transaction id of its own")
+		assert.Equal(t, "tid_caller", tids[1].Value.AsString())
+	}
+}
@@ -3,19 +3,51 @@ package main
 import (
 	"encoding/base64"
 	"encoding/xml"
-	"github.com/pborman/uuid"
+	log "github.com/Sirupsen/logrus"
+	"strings"
 )
 
-func transformLocation(tmeTerm term, taxonomyName string) location {
-	tmeIdentifier := buildTmeIdentifier(tmeTerm.RawID, taxonomyName)
-	uuid := uuid.NewMD5(uuid.UUID{}, []byte(tmeIdentifier)).String()
+func transformLocation(tmeTerm term, taxonomy taxonomyConfig, uuids uuidStrategy) location {
+	tmeIdentifier := buildTmeIdentifier(tmeTerm.RawID, taxonomy.name)
+	uuid := uuids.uuidFor(tmeIdentifier)
 
-	return location{
+	var broader []string
+	if tmeTerm.ParentID != "" {
+		broader = []string{uuids.uuidFor(buildTmeIdentifier(tmeTerm.ParentID, taxonomy.name))}
+	}
+
+	l := location{
 		UUID:                   uuid,
 		PrefLabel:              tmeTerm.CanonicalName,
 		AlternativeIdentifiers: alternativeIdentifiers{TME: []string{tmeIdentifier}, Uuids: []string{uuid}},
-		Type: "Location",
+		Type:                   taxonomy.locationType,
+		Types:                  typeAncestry(taxonomy.locationType),
+		BroaderUUIDs:           broader,
+		Coordinates:            coordinatesFromAttributes(tmeIdentifier, tmeTerm.Attributes),
+	}
+	return labelsFromAttributes(tmeIdentifier, l, tmeTerm.Attributes)
+}
+
+// coordinatesFromAttributes reads the latitude and longitude attributes TME holds for some places.
+func coordinatesFromAttributes(tmeIdentifier string, attributes []tmeAttribute) *coordinates {
+	var lat, lon string
+	for _, a := range attributes {
+		switch strings.ToLower(a.Name) {
+		case "latitude":
+			lat = a.Value
+		case "longitude":
+			lon = a.Value
+		}
+	}
+	if lat == "" || lon == "" {
+		return nil
+	}
+	c, err := parseCoordinates(lat, lon)
+	if err != nil {
+		log.Warnf("Ignoring coordinates of TME term %s: %v", tmeIdentifier, err)
+		return nil
 	}
+	return &c
 }
 
 func buildTmeIdentifier(rawID string, tmeTermTaxonomyName string) string {
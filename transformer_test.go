@@ -23,12 +23,34 @@ func TestTransform(t *testing.T) {
 					TME:   []string{"VWpCNFprMVVXVEJQUkUweExWSXlWblZqYlZaNi1SMHc9-R0w="},
 					Uuids: []string{"6334792f-baf0-3764-8936-fc4f240ca53c"},
 				},
-				Type: "Location"}},
+				Type:  "Location",
+				Types: []string{"Thing", "Concept", "Location"}}},
+		{"Transform term with a parent", term{
+			CanonicalName: "Location1",
+			RawID:         "UjB4Zk1UWTBPRE0xLVIyVnVjbVZ6-R0w=",
+			ParentID:      "ZnJhbmNl"},
+			location{
+				UUID:      "6334792f-baf0-3764-8936-fc4f240ca53c",
+				PrefLabel: "Location1",
+				AlternativeIdentifiers: alternativeIdentifiers{
+					TME:   []string{"VWpCNFprMVVXVEJQUkUweExWSXlWblZqYlZaNi1SMHc9-R0w="},
+					Uuids: []string{"6334792f-baf0-3764-8936-fc4f240ca53c"},
+				},
+				Type:         "Location",
+				Types:        []string{"Thing", "Concept", "Location"},
+				BroaderUUIDs: []string{"4966daf4-b717-35db-8765-324f2e535d61"}}},
 	}
 
 	for _, test := range tests {
-		expectedLocation := transformLocation(test.term, "GL")
+		expectedLocation := transformLocation(test.term, glTaxonomy, md5UUIDStrategy{})
 		assert.Equal(test.location, expectedLocation, fmt.Sprintf("%s: Expected location incorrect", test.name))
 	}
 
 }
+
+func TestUnMarshallTerm(t *testing.T) {
+	content := []byte(`<term><name>Paris</name><id>cGFyaXM=</id><parent><id>ZnJhbmNl</id></parent><attributes><attribute><name>type</name><value>City</value></attribute></attributes></term>`)
+	parsed, err := new(locationTransformer).UnMarshallTerm(content)
+	assert.NoError(t, err)
+	assert.Equal(t, term{CanonicalName: "Paris", RawID: "cGFyaXM=", ParentID: "ZnJhbmNl", Attributes: []tmeAttribute{{Name: "type", Value: "City"}}}, parsed)
+}
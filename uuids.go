@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pborman/uuid"
+	"io/ioutil"
+)
+
+// uuidStrategy derives the UP UUID of a location from its TME identifier.
+type uuidStrategy interface {
+	uuidFor(tmeIdentifier string) string
+}
+
+// md5UUIDStrategy is the original derivation: a v3 UUID hashed with an empty, rather than nil, namespace.
+// Changing it changes every location UUID.
+type md5UUIDStrategy struct{}
+
+func (md5UUIDStrategy) uuidFor(tmeIdentifier string) string {
+	return uuid.NewMD5(uuid.UUID{}, []byte(tmeIdentifier)).String()
+}
+
+type sha1UUIDStrategy struct {
+	namespace uuid.UUID
+}
+
+func (s sha1UUIDStrategy) uuidFor(tmeIdentifier string) string {
+	return uuid.NewSHA1(s.namespace, []byte(tmeIdentifier)).String()
+}
+
+// overrideUUIDStrategy returns hand curated UUIDs for the TME identifiers it knows about and defers to another strategy otherwise.
+type overrideUUIDStrategy struct {
+	overrides map[string]string
+	fallback  uuidStrategy
+}
+
+func (s overrideUUIDStrategy) uuidFor(tmeIdentifier string) string {
+	if u, found := s.overrides[tmeIdentifier]; found {
+		return u
+	}
+	return s.fallback.uuidFor(tmeIdentifier)
+}
+
+func newUUIDStrategy(name string, namespace string, overridesFile string) (uuidStrategy, error) {
+	var strategy uuidStrategy
+	switch name {
+	case "md5":
+		strategy = md5UUIDStrategy{}
+	case "sha1":
+		ns := uuid.NIL
+		if namespace != "" {
+			if ns = uuid.Parse(namespace); ns == nil {
+				return nil, fmt.Errorf("Invalid UUID namespace %q", namespace)
+			}
+		}
+		strategy = sha1UUIDStrategy{namespace: ns}
+	default:
+		return nil, fmt.Errorf("Unknown UUID strategy %q, expected md5 or sha1", name)
+	}
+
+	if overridesFile == "" {
+		return strategy, nil
+	}
+	overrides, err := loadUUIDOverrides(overridesFile)
+	if err != nil {
+		return nil, err
+	}
+	return overrideUUIDStrategy{overrides: overrides, fallback: strategy}, nil
+}
+
+// loadUUIDOverrides reads a JSON object mapping TME identifiers to UUIDs.
+func loadUUIDOverrides(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]string)
+	if err := json.Unmarshal(contents, &overrides); err != nil {
+		return nil, fmt.Errorf("Could not parse UUID overrides file %s: %v", path, err)
+	}
+	for tmeIdentifier, u := range overrides {
+		parsed := uuid.Parse(u)
+		if parsed == nil {
+			return nil, fmt.Errorf("Invalid UUID %q for TME identifier %s in %s", u, tmeIdentifier, path)
+		}
+		overrides[tmeIdentifier] = parsed.String()
+	}
+	return overrides, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// uuidCompatibilityTests pins UUIDs already published for TME terms. They must never change for the default strategy.
+var uuidCompatibilityTests = []struct {
+	rawID        string
+	taxonomyName string
+	uuid         string
+}{
+	{"UjB4Zk1UWTBPRE0xLVIyVnVjbVZ6-R0w=", "GL", "6334792f-baf0-3764-8936-fc4f240ca53c"},
+	{"NGQ2MWQ0NDMtMDc5Mi00NWExLTlkMGQtNWZhZjk0NGExOWU2-Z2VucmVz", "GL", "f7de594e-daa7-3d0e-a997-da4440d0c3b6"},
+	{"b8337559-ac08-3404-9025-bad51ebe2fc7", "Locations", "e559b6c0-2241-35b9-b970-e55cb8be4cba"},
+	{"mNGQ2MWQ0NDMtMDc5Mi00NWExLTlkMGQtNWZhZjk0NGExOWU2-Z2VucVz", "Locations", "ab4861b5-ba5e-3b67-9871-3bb3e52db103"},
+}
+
+func TestDefaultUUIDStrategyCompatibility(t *testing.T) {
+	strategy, err := newUUIDStrategy("md5", "", "")
+	assert.NoError(t, err)
+	for _, test := range uuidCompatibilityTests {
+		tmeIdentifier := buildTmeIdentifier(test.rawID, test.taxonomyName)
+		assert.Equal(t, test.uuid, strategy.uuidFor(tmeIdentifier), fmt.Sprintf("UUID changed for %s", tmeIdentifier))
+		assert.Equal(t, test.uuid, transformLocation(term{RawID: test.rawID}, taxonomyConfig{name: test.taxonomyName}, strategy).UUID)
+	}
+}
+
+func TestSha1UUIDStrategy(t *testing.T) {
+	tmeIdentifier := buildTmeIdentifier(uuidCompatibilityTests[0].rawID, uuidCompatibilityTests[0].taxonomyName)
+
+	nilNamespace, err := newUUIDStrategy("sha1", "", "")
+	assert.NoError(t, err)
+	urlNamespace, err := newUUIDStrategy("sha1", "6ba7b811-9dad-11d1-80b4-00c04fd430c8", "")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "897ad7c1-adea-594e-8959-ac8179d9dcca", nilNamespace.uuidFor(tmeIdentifier))
+	assert.Equal(t, "a2c46fb6-e444-5e45-b1ec-1934674d6e45", urlNamespace.uuidFor(tmeIdentifier))
+}
+
+func TestUUIDStrategyErrors(t *testing.T) {
+	_, err := newUUIDStrategy("md4", "", "")
+	assert.Error(t, err)
+	_, err = newUUIDStrategy("sha1", "not-a-uuid", "")
+	assert.Error(t, err)
+	_, err = newUUIDStrategy("md5", "", "does-not-exist.json")
+	assert.Error(t, err)
+}
+
+func TestUUIDOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		valid    bool
+	}{
+		{"Valid", `{"VWpCNFprMVVXVEJQUkUweExWSXlWblZqYlZaNi1SMHc9-R0w=": "BBA39990-C78D-3629-AE83-808C333C6DBC"}`, true},
+		{"Invalid UUID", `{"VWpCNFprMVVXVEJQUkUweExWSXlWblZqYlZaNi1SMHc9-R0w=": "bba39990"}`, false},
+		{"Invalid JSON", `["bba39990-c78d-3629-ae83-808c333c6dbc"]`, false},
+	}
+
+	for _, test := range tests {
+		f, err := ioutil.TempFile("", "uuid-overrides")
+		assert.NoError(t, err)
+		_, err = f.WriteString(test.contents)
+		assert.NoError(t, err)
+		f.Close()
+
+		strategy, err := newUUIDStrategy("md5", "", f.Name())
+		os.Remove(f.Name())
+		if !test.valid {
+			assert.Error(t, err, test.name)
+			continue
+		}
+		assert.NoError(t, err, test.name)
+		assert.Equal(t, testUUID, strategy.uuidFor("VWpCNFprMVVXVEJQUkUweExWSXlWblZqYlZaNi1SMHc9-R0w="), test.name)
+		for _, compat := range uuidCompatibilityTests[1:] {
+			assert.Equal(t, compat.uuid, strategy.uuidFor(buildTmeIdentifier(compat.rawID, compat.taxonomyName)), test.name)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// contentVersion identifies the content of a location, or of a whole snapshot, and when it last changed.
+type contentVersion struct {
+	Hash     string
+	Modified time.Time
+}
+
+// versionLocations hashes each location of a snapshot and the snapshot as a whole. Modification times carry over from
+// the previous versions when a hash is unchanged, so reloads that change nothing keep responses cacheable.
+func versionLocations(locations locationsMap, previous map[string]contentVersion, previousSnapshot contentVersion, now time.Time) (map[string]contentVersion, contentVersion) {
+	now = now.UTC().Truncate(time.Second)
+	versions := make(map[string]contentVersion, len(locations))
+	uuids := make([]string, 0, len(locations))
+	for uuid, l := range locations {
+		versions[uuid] = contentVersion{Hash: hashLocation(l), Modified: now}
+		if p, found := previous[uuid]; found && p.Hash == versions[uuid].Hash {
+			versions[uuid] = p
+		}
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+
+	h := sha256.New()
+	for _, uuid := range uuids {
+		io.WriteString(h, uuid+":"+versions[uuid].Hash+"\n")
+	}
+	snapshot := contentVersion{Hash: hex.EncodeToString(h.Sum(nil))[:32], Modified: now}
+	if snapshot.Hash == previousSnapshot.Hash {
+		snapshot = previousSnapshot
+	}
+	return versions, snapshot
+}
+
+func hashLocation(l location) string {
+	contents, err := json.Marshal(l)
+	if err != nil {
+		log.Errorf("Could not hash location with uuid=%s: %v", l.UUID, err)
+		return ""
+	}
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// entityTag combines the version of the data behind a response with what shapes its representation, so JSON and
+// GeoJSON, or labels in different languages, never share a tag.
+func entityTag(v contentVersion, req *http.Request) string {
+	h := fnv.New64a()
+	io.WriteString(h, req.URL.RawQuery+"|"+req.Header.Get("Accept-Language"))
+	if wantsGeoJSON(req) {
+		io.WriteString(h, "|geojson")
+	}
+	return fmt.Sprintf(`"%s-%x"`, v.Hash, h.Sum64())
+}
+
+// notModified sets the ETag and Last-Modified headers of a response and answers 304 Not Modified when the request's
+// If-None-Match, or else If-Modified-Since, shows the client already has it. It tells whether it did.
+func notModified(writer http.ResponseWriter, req *http.Request, v contentVersion) bool {
+	if v.Hash == "" {
+		return false
+	}
+	etag := entityTag(v, req)
+	varyOnRepresentation(writer)
+	writer.Header().Set("ETag", etag)
+	writer.Header().Set("Last-Modified", v.Modified.UTC().Format(http.TimeFormat))
+
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		metrics.GetOrRegisterCounter("conditional.requests", metrics.DefaultRegistry).Inc(1)
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+		if err != nil || v.Modified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	metrics.GetOrRegisterCounter("conditional.not_modified", metrics.DefaultRegistry).Inc(1)
+	writer.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares an If-None-Match header with an entity tag, weakly as RFC 7232 requires for GET.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersionLocations(t *testing.T) {
+	first := time.Date(2016, 10, 19, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	locations := locationsMap{testUUID: paris, london.UUID: london}
+
+	versions, version := versionLocations(locations, nil, contentVersion{}, first)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, first, version.Modified)
+
+	unchanged, unchangedVersion := versionLocations(locationsMap{testUUID: paris, london.UUID: london}, versions, version, second)
+	assert.Equal(t, versions, unchanged)
+	assert.Equal(t, version, unchangedVersion)
+
+	renamed := london
+	renamed.PrefLabel = "Greater London"
+	changed, changedVersion := versionLocations(locationsMap{testUUID: paris, london.UUID: renamed}, versions, version, second)
+	assert.Equal(t, versions[testUUID], changed[testUUID])
+	assert.NotEqual(t, versions[london.UUID].Hash, changed[london.UUID].Hash)
+	assert.Equal(t, second, changed[london.UUID].Modified)
+	assert.NotEqual(t, version.Hash, changedVersion.Hash)
+	assert.Equal(t, second, changedVersion.Modified)
+}
+
+func TestNotModified(t *testing.T) {
+	v := contentVersion{Hash: "abc", Modified: time.Date(2016, 10, 19, 10, 0, 0, 0, time.UTC)}
+	etag := entityTag(v, newRequest("GET", "/transformers/locations"))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"Unconditional", nil, http.StatusOK},
+		{"Matching etag", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"Weak matching etag in a list", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified},
+		{"Any etag", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"Stale etag", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"Stale etag wins over date", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Wed, 19 Oct 2016 11:00:00 GMT"}, http.StatusOK},
+		{"Not modified since", map[string]string{"If-Modified-Since": "Wed, 19 Oct 2016 10:00:00 GMT"}, http.StatusNotModified},
+		{"Modified since", map[string]string{"If-Modified-Since": "Wed, 19 Oct 2016 09:59:59 GMT"}, http.StatusOK},
+		{"Invalid date", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := newRequest("GET", "/transformers/locations")
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		if !notModified(rec, req, v) {
+			rec.WriteHeader(http.StatusOK)
+		}
+		assert.Equal(t, test.status, rec.Code, test.name)
+		assert.Equal(t, etag, rec.Header().Get("ETag"), test.name)
+		assert.Equal(t, "Wed, 19 Oct 2016 10:00:00 GMT", rec.Header().Get("Last-Modified"), test.name)
+	}
+}
+
+func TestEntityTagDependsOnRepresentation(t *testing.T) {
+	v := contentVersion{Hash: "abc"}
+	url := fmt.Sprintf("/transformers/locations/%s", testUUID)
+	tags := map[string]bool{
+		entityTag(v, newRequest("GET", url)):                           true,
+		entityTag(v, newGeoJSONRequest("GET", url)):                    true,
+		entityTag(v, newLanguageRequest("GET", url, "fr")):             true,
+		entityTag(v, newRequest("GET", url+"?lang=de")):                true,
+		entityTag(contentVersion{Hash: "def"}, newRequest("GET", url)): true,
+	}
+	assert.Len(t, tags, 5)
+}
+
+func TestConditionalLocation(t *testing.T) {
+	s := &dummyService{found: true, locations: []location{paris}, version: contentVersion{Hash: "abc", Modified: time.Date(2016, 10, 19, 10, 0, 0, 0, time.UTC)}}
+	url := fmt.Sprintf("/transformers/locations/%s", testUUID)
+	rec := httptest.NewRecorder()
+	router(s).ServeHTTP(rec, newRequest("GET", url))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	for _, path := range []string{url, "/transformers/locations", "/transformers/locations/__ids", "/transformers/locations/__count"} {
+		req := newRequest("GET", path)
+		rec = httptest.NewRecorder()
+		router(s).ServeHTTP(rec, req)
+		req = newRequest("GET", path)
+		req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+		rec = httptest.NewRecorder()
+		router(s).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotModified, rec.Code, path)
+		assert.Empty(t, rec.Body.String(), path)
+	}
+
+	req := newGeoJSONRequest("GET", url)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	router(s).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReloadKeepsVersionsOfUnchangedLocations(t *testing.T) {
+	repo := dummyRepo{terms: []term{{CanonicalName: "Paris", RawID: "paris"}, {CanonicalName: "London", RawID: "london"}}}
+	service, err := newLocationService(&repo, glTaxonomy, 10000, snapshotGuard{}, md5UUIDStrategy{}, testClassifier, nil, &deprecations{})
+	assert.NoError(t, err)
+	version := service.getSnapshotVersion()
+	assert.NotEmpty(t, version.Hash)
+	paris := md5UUIDStrategy{}.uuidFor(buildTmeIdentifier("paris", "GL"))
+	parisVersion, found := service.getLocationVersion(paris)
+	assert.True(t, found)
+
+	assert.NoError(t, service.reload())
+	assert.Equal(t, version, service.getSnapshotVersion())
+
+	repo.terms[1].CanonicalName = "Greater London"
+	assert.NoError(t, service.reload())
+	assert.NotEqual(t, version.Hash, service.getSnapshotVersion().Hash)
+	unchanged, _ := service.getLocationVersion(paris)
+	assert.Equal(t, parisVersion, unchanged)
+}